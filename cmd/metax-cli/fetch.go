@@ -1,7 +1,7 @@
 package main
 
 import (
-	//"context"
+	"context"
 	"flag"
 	"fmt"
 	"os"
@@ -85,7 +85,10 @@ func runFetch(url string, args []string) error {
 
 	api := metax.NewMetaxService(METAX_HOST, metax.WithCredentials(os.Getenv("APP_METAX_API_USER"), os.Getenv("APP_METAX_API_PASS")))
 
-	err = shared.FetchSince(api, db, Logger, uid, identity, sinceHeader)
+	ctx, cancel := context.WithTimeout(context.Background(), shared.DefaultRequestTimeout)
+	defer cancel()
+
+	err = shared.FetchSince(ctx, api, db, Logger, uid, identity, sinceHeader, shared.TriggerCli)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/NatLibFi/qvain-api/pkg/models"
+)
+
+// Admins is the set of user identities allowed to use the admin parts of the API.
+type Admins map[string]bool
+
+// parseAdmins creates a set of admin identities from a comma or space separated list.
+func parseAdmins(list string) Admins {
+	admins := make(Admins)
+	for _, identity := range strings.FieldsFunc(list, func(c rune) bool { return c == ',' || c == ' ' }) {
+		admins[identity] = true
+	}
+	return admins
+}
+
+// Has returns true if the user logged in with an admin identity.
+func (admins Admins) Has(user *models.User) bool {
+	return user != nil && user.Identity != "" && admins[user.Identity]
+}
@@ -5,7 +5,6 @@ import (
 	"net/http"
 	"strings"
 
-	"github.com/NatLibFi/qvain-api/pkg/metax"
 	"github.com/rs/zerolog"
 )
 
@@ -36,12 +35,15 @@ type Apis struct {
 	config *Config
 	logger zerolog.Logger
 
-	datasets *DatasetApi
-	objects  *ObjectApi
-	sessions *SessionApi
-	auth     *AuthApi
-	proxy    *ApiProxy
-	lookup   *LookupApi
+	datasets  *DatasetApi
+	objects   *ObjectApi
+	sessions  *SessionApi
+	auth      *AuthApi
+	proxy     *ApiProxy
+	lookup    *LookupApi
+	schemas   *SchemaApi
+	templates *TemplateApi
+	jobs      *JobApi
 }
 
 // NewApis constructs a collection of APIs with a given configuration.
@@ -51,13 +53,15 @@ func NewApis(config *Config) *Apis {
 		logger: config.NewLogger("apis"),
 	}
 
-	metax := metax.NewMetaxService(config.MetaxApiHost, metax.WithCredentials(config.metaxApiUser, config.metaxApiPass))
+	metax := config.NewMetaxService()
 
 	apis.datasets = NewDatasetApi(config.db, config.sessions, metax, config.NewLogger("datasets"))
+	apis.datasets.SetSchemas(config.schemas)
+	apis.datasets.SetJobs(config.jobs)
 	apis.objects = NewObjectApi(config.db, config.NewLogger("objects"))
 	apis.sessions = NewSessionApi(config.sessions, config.db, config.messenger, config.NewLogger("sessions"))
 	apis.sessions.AllowCreate(config.DevMode)
-	apis.auth = NewAuthApi(config, makeOnFairdataLogin(metax, config.db, config.NewLogger("sync")), config.NewLogger("auth"))
+	apis.auth = NewAuthApi(config, makeOnFairdataLogin(config.jobs, config.NewLogger("sync")), config.NewLogger("auth"))
 	apis.proxy = NewApiProxy(
 		"https://"+config.MetaxApiHost+"/rest/",
 		config.metaxApiUser,
@@ -66,6 +70,9 @@ func NewApis(config *Config) *Apis {
 		config.NewLogger("proxy"),
 	)
 	apis.lookup = NewLookupApi(config.db)
+	apis.schemas = NewSchemaApi(config.db, config.sessions, config.schemas, config.Admins, config.NewLogger("schemas"))
+	apis.templates = NewTemplateApi(config.db, config.sessions, config.Admins, config.loadTemplates, config.NewLogger("templates"))
+	apis.jobs = NewJobApi(config.db, config.sessions, config.Admins, config.NewLogger("jobs"))
 
 	return apis
 }
@@ -100,6 +107,15 @@ func (apis *Apis) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	case "lookup/":
 		lookupC.Add(1)
 		apis.lookup.ServeHTTP(w, r)
+	case "schemas", "schemas/":
+		schemasC.Add(1)
+		apis.schemas.ServeHTTP(w, r)
+	case "templates", "templates/":
+		templatesC.Add(1)
+		apis.templates.ServeHTTP(w, r)
+	case "jobs", "jobs/":
+		jobsC.Add(1)
+		apis.jobs.ServeHTTP(w, r)
 	case "version":
 		versionC.Add(1)
 		ifGet(w, r, apiVersion)
@@ -1,8 +1,11 @@
 package main
 
 import (
+	"errors"
 	"net/http"
+	"net/url"
 	"path"
+	"strconv"
 	"strings"
 
 	"github.com/NatLibFi/qvain-api/internal/psql"
@@ -54,8 +57,8 @@ func apiWriteHeadersWithCache(w http.ResponseWriter, cc uint) {
 func apiWriteOptions(w http.ResponseWriter, opts string) {
 	apiWriteHeaders(w)
 	// pre-flight
-	w.Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type, Content-Length, Range")
-	w.Header().Set("Access-Control-Expose-Headers", "Content-Length, Retry-After, Accept-Ranges")
+	w.Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type, Content-Length, Range, If-Match, If-None-Match")
+	w.Header().Set("Access-Control-Expose-Headers", "Content-Length, Retry-After, Accept-Ranges, ETag, Link, X-Sync-Skipped")
 	w.Header().Set("Access-Control-Allow-Methods", "*") // wildcard in spec but not implemented by all browsers yet
 	w.Header().Set("Access-Control-Max-Age", "3600")
 
@@ -234,6 +237,26 @@ func dbError(w http.ResponseWriter, err error) bool {
 		jsonError(w, "not resource owner", http.StatusForbidden)
 	case psql.ErrInvalidJson:
 		jsonError(w, "invalid input", http.StatusBadRequest)
+	case psql.ErrNotPublic:
+		jsonError(w, "path not public", http.StatusForbidden)
+	case psql.ErrSeqMismatch:
+		jsonError(w, "resource has been modified", http.StatusPreconditionFailed)
+	case psql.ErrInvalidSort:
+		jsonError(w, "invalid sort order", http.StatusBadRequest)
+	case psql.ErrInvalidCursor:
+		jsonError(w, "invalid cursor", http.StatusBadRequest)
+	case psql.ErrInvalidLanguage:
+		jsonError(w, "invalid language", http.StatusBadRequest)
+	case psql.ErrSelfTransfer:
+		jsonError(w, "can't transfer to self", http.StatusBadRequest)
+	case psql.ErrNoAccess:
+		jsonError(w, "insufficient role for this operation", http.StatusForbidden)
+	case psql.ErrInvalidRole:
+		jsonError(w, "invalid role", http.StatusBadRequest)
+	case psql.ErrIsOwner:
+		jsonError(w, "user is the dataset owner", http.StatusConflict)
+	case psql.ErrInvalidResolution:
+		jsonError(w, "invalid conflict resolution", http.StatusBadRequest)
 	// connection
 	case psql.ErrConnection:
 		jsonError(w, "no database connection", http.StatusServiceUnavailable)
@@ -283,8 +306,7 @@ func convertExternalStatusCode(code int) int {
 	}
 }
 
-// responseNotModified
-/*
+// responseNotModified writes a 304 Not Modified response with the given ETag, if any.
 func responseNotModified(w http.ResponseWriter, r *http.Request, etag string) {
 	//w.Header().Set("Content-Type", "application/json")
 	if etag != "" {
@@ -292,7 +314,68 @@ func responseNotModified(w http.ResponseWriter, r *http.Request, etag string) {
 	}
 	w.WriteHeader(http.StatusNotModified)
 }
-*/
+
+// errInvalidETag is returned for If-Match headers that can't possibly match one of our ETags.
+var errInvalidETag = errors.New("invalid etag")
+
+// seqETag makes a strong ETag from a dataset's sequence number.
+func seqETag(seq int) string {
+	return `"` + strconv.Itoa(seq) + `"`
+}
+
+// etagMatches checks if an If-None-Match header value matches the given ETag, using weak comparison as RFC 7232 prescribes.
+func etagMatches(header string, etag string) bool {
+	if header == "" {
+		return false
+	}
+	if strings.TrimSpace(header) == "*" {
+		return true
+	}
+
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(header, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(candidate), "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// ifMatchSeq parses an If-Match header into the dataset sequence number the client expects.
+// It returns nil if the header is absent or `*`, and errInvalidETag if it's not a single strong ETag made by seqETag.
+func ifMatchSeq(r *http.Request) (*int, error) {
+	header := strings.TrimSpace(r.Header.Get("If-Match"))
+	if header == "" || header == "*" {
+		return nil, nil
+	}
+
+	if len(header) < 3 || header[0] != '"' || header[len(header)-1] != '"' {
+		return nil, errInvalidETag
+	}
+
+	seq, err := strconv.Atoi(header[1 : len(header)-1])
+	if err != nil {
+		return nil, errInvalidETag
+	}
+	return &seq, nil
+}
+
+// intParam parses an optional integer query parameter, returning the default if the parameter is missing and an error if it is out of range.
+func intParam(query url.Values, key string, def int, min int, max int) (int, error) {
+	value := query.Get(key)
+	if value == "" {
+		return def, nil
+	}
+
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, err
+	}
+	if i < min || i > max {
+		return 0, strconv.ErrRange
+	}
+	return i, nil
+}
 
 func ShiftPath(p string) (head, tail string) {
 	if p == "" {
@@ -365,3 +448,9 @@ func GetUuidParam(head string) (uuid.UUID, error) {
 func TrimSlash(s string) string {
 	return strings.TrimRight(s, "/")
 }
+
+// isBodyTooLarge tells if a read error came from http.MaxBytesReader because the request body is over its limit.
+// The error has no type of its own before Go 1.19, so it is recognised by its message.
+func isBodyTooLarge(err error) bool {
+	return err != nil && err.Error() == "http: request body too large"
+}
@@ -2,11 +2,14 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	//"io"
 	"io/ioutil"
-	//"net/http"
+	"net/http"
 	"bytes"
 	"net/http/httptest"
+	"net/url"
+	"strings"
 	"testing"
 )
 
@@ -114,3 +117,107 @@ func TestJsonErrors(t *testing.T) {
 
 	}
 }
+
+func TestEtagMatches(t *testing.T) {
+	var tests = []struct {
+		header string
+		etag   string
+		match  bool
+	}{
+		{header: "", etag: `"1"`, match: false},
+		{header: "*", etag: `"1"`, match: true},
+		{header: `"1"`, etag: `"1"`, match: true},
+		{header: `"2"`, etag: `"1"`, match: false},
+		{header: `W/"1"`, etag: `"1"`, match: true},
+		{header: `"0", "1"`, etag: `"1"`, match: true},
+		{header: `"10"`, etag: `"1"`, match: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.header, func(t *testing.T) {
+			if match := etagMatches(test.header, test.etag); match != test.match {
+				t.Errorf("expected %v, got %v", test.match, match)
+			}
+		})
+	}
+}
+
+func TestIfMatchSeq(t *testing.T) {
+	var tests = []struct {
+		header string
+		seq    *int
+		err    error
+	}{
+		{header: "", seq: nil, err: nil},
+		{header: "*", seq: nil, err: nil},
+		{header: `"0"`, seq: intptr(0), err: nil},
+		{header: `"42"`, seq: intptr(42), err: nil},
+		{header: `W/"42"`, seq: nil, err: errInvalidETag},
+		{header: `"42", "43"`, seq: nil, err: errInvalidETag},
+		{header: `"abc"`, seq: nil, err: errInvalidETag},
+		{header: `42`, seq: nil, err: errInvalidETag},
+	}
+
+	for _, test := range tests {
+		t.Run(test.header, func(t *testing.T) {
+			r := httptest.NewRequest("PUT", "/api/datasets/", nil)
+			if test.header != "" {
+				r.Header.Set("If-Match", test.header)
+			}
+
+			seq, err := ifMatchSeq(r)
+			if err != test.err {
+				t.Fatalf("error: expected %v, got %v", test.err, err)
+			}
+			if (seq == nil) != (test.seq == nil) || (seq != nil && *seq != *test.seq) {
+				t.Errorf("seq: expected %v, got %v", test.seq, seq)
+			}
+		})
+	}
+}
+
+func intptr(i int) *int {
+	return &i
+}
+
+func TestIntParam(t *testing.T) {
+	var tests = []struct {
+		query    string
+		expected int
+		ok       bool
+	}{
+		{query: "", expected: 20, ok: true},
+		{query: "n=", expected: 20, ok: true},
+		{query: "n=1", expected: 1, ok: true},
+		{query: "n=100", expected: 100, ok: true},
+		{query: "n=0", ok: false},
+		{query: "n=101", ok: false},
+		{query: "n=-5", ok: false},
+		{query: "n=five", ok: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.query, func(t *testing.T) {
+			query, _ := url.ParseQuery(test.query)
+			n, err := intParam(query, "n", 20, 1, 100)
+			if (err == nil) != test.ok {
+				t.Fatalf("expected ok=%v, got error %v", test.ok, err)
+			}
+			if test.ok && n != test.expected {
+				t.Errorf("expected %d, got %d", test.expected, n)
+			}
+		})
+	}
+}
+
+func TestIsBodyTooLarge(t *testing.T) {
+	rec := httptest.NewRecorder()
+	body := http.MaxBytesReader(rec, ioutil.NopCloser(strings.NewReader("0123456789")), 5)
+	_, err := ioutil.ReadAll(body)
+	if !isBodyTooLarge(err) {
+		t.Errorf("expected body too large, got %v", err)
+	}
+	if isBodyTooLarge(errors.New("unexpected EOF")) {
+		t.Error("expected other errors not to match")
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/NatLibFi/qvain-api/internal/psql"
+	"github.com/NatLibFi/qvain-api/pkg/models"
+
+	"github.com/wvh/uuid"
+)
+
+// Collaborators handles requests for sharing a dataset with other users:
+//
+//   GET    collaborators             list users with access (any role)
+//   POST   collaborators             give a user a role or change it (owner)
+//   DELETE collaborators/<identity>  take away a user's access (owner, or the user themselves)
+func (api *DatasetApi) Collaborators(w http.ResponseWriter, r *http.Request, user *models.User, id uuid.UUID) {
+	identity := ShiftUrlWithTrailing(r)
+
+	if identity != "" {
+		if checkMethod(w, r, http.MethodDelete) {
+			api.removeCollaborator(w, r, user.Uid, id, identity)
+		}
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		api.listCollaborators(w, r, user.Uid, id)
+	case http.MethodPost:
+		api.setCollaborator(w, r, user.Uid, id)
+	case http.MethodOptions:
+		apiWriteOptions(w, "GET, POST, DELETE, OPTIONS")
+	default:
+		jsonError(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+func (api *DatasetApi) listCollaborators(w http.ResponseWriter, r *http.Request, uid uuid.UUID, id uuid.UUID) {
+	jsondata, err := api.db.ViewCollaborators(id, uid, api.identity)
+	if err != nil {
+		dbError(w, err)
+		return
+	}
+
+	apiWriteHeaders(w)
+	w.Write(jsondata)
+}
+
+// collaboratorRequest is the body of a request to add a collaborator.
+type collaboratorRequest struct {
+	// Identity is the external identity of the collaborator.
+	Identity string `json:"identity"`
+
+	// Role is the name of the role to give; see psql.Role.
+	Role string `json:"role"`
+}
+
+// parseCollaboratorRequest decodes and checks a collaborator request.
+func parseCollaboratorRequest(r *http.Request) (string, psql.Role, error) {
+	var req collaboratorRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return "", psql.RoleNone, psql.ErrInvalidJson
+	}
+
+	if req.Identity == "" {
+		return "", psql.RoleNone, errMissingIdentity
+	}
+
+	role, err := psql.ParseRole(req.Role)
+	if err != nil {
+		return "", psql.RoleNone, err
+	}
+
+	return req.Identity, role, nil
+}
+
+func (api *DatasetApi) setCollaborator(w http.ResponseWriter, r *http.Request, owner uuid.UUID, id uuid.UUID) {
+	if r.Body == nil || r.Body == http.NoBody {
+		jsonError(w, "empty body", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	identity, role, err := parseCollaboratorRequest(r)
+	if err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	collaborator, err := api.db.GetUidForIdentity(api.identity, identity)
+	if err == psql.ErrNotFound {
+		jsonError(w, "unknown user", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		dbError(w, err)
+		return
+	}
+
+	err = api.db.SetCollaborator(id, owner, collaborator, role)
+	if err != nil {
+		dbError(w, err)
+		return
+	}
+
+	api.logger.Info().Str("dataset", id.String()).Str("owner", owner.String()).Str("collaborator", collaborator.String()).Str("role", role.String()).Msg("set collaborator")
+
+	apiWriteHeaders(w)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (api *DatasetApi) removeCollaborator(w http.ResponseWriter, r *http.Request, uid uuid.UUID, id uuid.UUID, identity string) {
+	collaborator, err := api.db.GetUidForIdentity(api.identity, identity)
+	if err != nil {
+		dbError(w, err)
+		return
+	}
+
+	err = api.db.RemoveCollaborator(id, uid, collaborator)
+	if err != nil {
+		dbError(w, err)
+		return
+	}
+
+	api.logger.Info().Str("dataset", id.String()).Str("uid", uid.String()).Str("collaborator", collaborator.String()).Msg("removed collaborator")
+
+	apiWriteHeaders(w)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/NatLibFi/qvain-api/internal/psql"
+)
+
+func TestParseCollaboratorRequest(t *testing.T) {
+	var tests = []struct {
+		body string
+		role psql.Role
+		err  error
+	}{
+		{body: `{"identity":"someone@fairdataid","role":"viewer"}`, role: psql.RoleViewer},
+		{body: `{"identity":"someone@fairdataid","role":"editor"}`, role: psql.RoleEditor},
+		{body: `{"identity":"someone@fairdataid","role":"owner"}`, role: psql.RoleOwner},
+		{body: `{"identity":"someone@fairdataid","role":"admin"}`, err: psql.ErrInvalidRole},
+		{body: `{"identity":"someone@fairdataid"}`, err: psql.ErrInvalidRole},
+		{body: `{"role":"viewer"}`, err: errMissingIdentity},
+		{body: `{"identity":`, err: psql.ErrInvalidJson},
+	}
+
+	for _, test := range tests {
+		t.Run(test.body, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/api/datasets/x/collaborators", strings.NewReader(test.body))
+
+			_, role, err := parseCollaboratorRequest(r)
+			if err != test.err {
+				t.Fatalf("error: expected %v, got %v", test.err, err)
+			}
+			if role != test.role {
+				t.Errorf("role: expected %v, got %v", test.role, role)
+			}
+		})
+	}
+}
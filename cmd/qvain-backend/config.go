@@ -5,15 +5,22 @@ import (
 	"encoding/hex"
 	"fmt"
 	"os"
+	"strconv"
+	"sync"
+	"time"
 
 	"github.com/rs/zerolog"
 
+	"github.com/NatLibFi/qvain-api/internal/jobs"
 	"github.com/NatLibFi/qvain-api/internal/jwt"
 	"github.com/NatLibFi/qvain-api/internal/psql"
 	"github.com/NatLibFi/qvain-api/internal/secmsg"
 	"github.com/NatLibFi/qvain-api/internal/sessions"
+	"github.com/NatLibFi/qvain-api/internal/shared"
 	"github.com/NatLibFi/qvain-api/pkg/env"
+	"github.com/NatLibFi/qvain-api/pkg/metax"
 	"github.com/NatLibFi/qvain-api/pkg/models"
+	"github.com/NatLibFi/qvain-api/pkg/validation"
 )
 
 // Config holds the configuration for the application.
@@ -31,11 +38,32 @@ type Config struct {
 	UseHttpErrors bool
 	Logger        zerolog.Logger
 
+	// datasets in the trash bin are purged after this period
+	TrashRetention time.Duration
+
+	// users' datasets are synced from Metax in the background at this interval; 0 disables background sync
+	SyncInterval time.Duration
+
+	// number of workers running background jobs such as syncs and publishing
+	JobWorkers int
+
+	// directory with JSON Schemas overriding the built-in ones, named `<schema>.json`
+	SchemaDir string
+
+	// directory with dataset templates overriding the built-in ones, named `<schema>.json` or `<org>/<schema>.json`
+	TemplateDir string
+
+	// identities of the users allowed to use the admin parts of the API
+	Admins Admins
+
 	// Metax service related settings
 	MetaxApiHost string
 	metaxApiUser string
 	metaxApiPass string
 
+	// idempotent Metax requests are tried this many times; 1 disables retries
+	MetaxRetries int
+
 	// session settings
 	tokenKey         []byte
 	oidcProviderName string
@@ -45,9 +73,17 @@ type Config struct {
 
 	// configured service instances
 	db        *psql.DB
+	jobs      *jobs.Queue
+	metax     *metax.MetaxService
+	schemas   *validation.Registry
 	sessions  *sessions.Manager
 	tokens    *jwt.JwtHandler
 	messenger *secmsg.MessageService
+
+	// stamp of the database templates in use; see loadTemplates
+	templatesMu      sync.Mutex
+	templatesCount   int
+	templatesUpdated time.Time
 }
 
 // ConfigFromEnv() creates the application configuration by reading in environment variables.
@@ -65,6 +101,26 @@ func ConfigFromEnv() (*Config, error) {
 		return nil, fmt.Errorf("invalid token key: %s", err)
 	}
 
+	retention, err := shared.TrashRetention()
+	if err != nil {
+		return nil, fmt.Errorf("invalid trash retention: %s", err)
+	}
+
+	syncInterval, err := time.ParseDuration(env.GetDefault("APP_SYNC_INTERVAL", DefaultSyncInterval))
+	if err != nil || syncInterval < 0 {
+		return nil, fmt.Errorf("invalid sync interval: %s", env.Get("APP_SYNC_INTERVAL"))
+	}
+
+	jobWorkers, err := strconv.Atoi(env.GetDefault("APP_JOB_WORKERS", strconv.Itoa(DefaultJobWorkers)))
+	if err != nil || jobWorkers < 1 {
+		return nil, fmt.Errorf("invalid number of job workers: %s", env.Get("APP_JOB_WORKERS"))
+	}
+
+	metaxRetries, err := strconv.Atoi(env.GetDefault("APP_METAX_RETRIES", strconv.Itoa(metax.DefaultRetryAttempts)))
+	if err != nil || metaxRetries < 1 {
+		return nil, fmt.Errorf("invalid number of metax retries: %s", env.Get("APP_METAX_RETRIES"))
+	}
+
 	if *appDevMode {
 		*appDebug = true
 		*forceHttpOnly = true
@@ -97,6 +153,12 @@ func ConfigFromEnv() (*Config, error) {
 		LogRequests:      !*disableHttpLog,
 		Logger:           createAppLogger(ServiceName, *appDebug, *disableLogging),
 		UseHttpErrors:    env.GetBool("APP_HTTP_ERRORS"),
+		TrashRetention:   retention,
+		SyncInterval:     syncInterval,
+		JobWorkers:       jobWorkers,
+		SchemaDir:        env.Get("APP_SCHEMA_DIR"),
+		TemplateDir:      env.Get("APP_TEMPLATE_DIR"),
+		Admins:           parseAdmins(env.Get("APP_ADMINS")),
 		tokenKey:         key,
 		oidcProviderName: env.Get("APP_OIDC_PROVIDER_NAME"),
 		oidcProviderUrl:  env.Get("APP_OIDC_PROVIDER_URL"),
@@ -105,6 +167,7 @@ func ConfigFromEnv() (*Config, error) {
 		MetaxApiHost:     env.Get("APP_METAX_API_HOST"),
 		metaxApiUser:     env.Get("APP_METAX_API_USER"),
 		metaxApiPass:     env.Get("APP_METAX_API_PASS"),
+		MetaxRetries:     metaxRetries,
 	}, nil
 }
 
@@ -113,15 +176,157 @@ func (config *Config) NewLogger(name string) zerolog.Logger {
 	return config.Logger.With().Str("component", name).Logger()
 }
 
+// initSchemas loads the JSON Schemas used to validate datasets.
+func (config *Config) initSchemas() error {
+	config.schemas = validation.Builtin()
+	if config.SchemaDir != "" {
+		if _, err := config.schemas.LoadDir(config.SchemaDir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadActiveSchemas replaces the file-based schemas with the versions activated in the database.
+// Schemas already in use in their active version are not compiled again. It returns the number of schemas loaded.
+func (config *Config) loadActiveSchemas() (int, error) {
+	active, err := config.db.ActiveSchemas()
+	if err != nil {
+		return 0, err
+	}
+
+	loaded := 0
+	for _, sv := range active {
+		if info, ok := config.schemas.Get(sv.Name); ok && info.Version == sv.Version {
+			continue
+		}
+		if err := config.schemas.AddVersion(sv.Name, sv.Version, sv.Schema, sv.Ui); err != nil {
+			return loaded, fmt.Errorf("%s version %d: %s", sv.Name, sv.Version, err)
+		}
+		loaded++
+	}
+	return loaded, nil
+}
+
+// watchActiveSchemas spawns a background job that periodically loads schema versions activated in the database,
+// so versions activated through another backend instance are put into use here too.
+// NOTE: This function returns immediately.
+func (config *Config) watchActiveSchemas(interval time.Duration, logger zerolog.Logger) {
+	go func() {
+		for {
+			time.Sleep(interval)
+			loaded, err := config.loadActiveSchemas()
+			if err != nil {
+				logger.Error().Err(err).Msg("failed to reload schemas from database")
+			}
+			if loaded > 0 {
+				logger.Info().Int("loaded", loaded).Msg("loaded newly activated schemas")
+			}
+		}
+	}()
+}
+
+// loadTemplates builds the dataset templates from the built-in ones, the template directory and the database, in order of precedence,
+// and puts them into use. On error, the templates in use are left unchanged. It returns the number of templates loaded from the directory and database.
+func (config *Config) loadTemplates() (int, error) {
+	config.templatesMu.Lock()
+	defer config.templatesMu.Unlock()
+
+	templates := metax.BuiltinTemplates()
+
+	var n int
+	if config.TemplateDir != "" {
+		loaded, err := templates.LoadDir(config.TemplateDir)
+		if err != nil {
+			return 0, err
+		}
+		n += loaded
+	}
+
+	var (
+		count   int
+		updated time.Time
+	)
+	if config.db != nil {
+		// take the stamp first, so a change made while loading is picked up by the next check
+		var err error
+		count, updated, err = config.db.TemplatesStamp()
+		if err != nil {
+			return 0, err
+		}
+
+		stored, err := config.db.Templates()
+		if err != nil {
+			return 0, err
+		}
+		for _, t := range stored {
+			if err := templates.Add(t.Schema, t.Org, t.Template); err != nil {
+				return 0, fmt.Errorf("template %s (org %q): %s", t.Schema, t.Org, err)
+			}
+		}
+		n += len(stored)
+	}
+
+	metax.SetTemplates(templates)
+	config.templatesCount, config.templatesUpdated = count, updated
+	return n, nil
+}
+
+// templatesChanged tells if the templates in the database changed since they were last loaded.
+func (config *Config) templatesChanged() (bool, error) {
+	count, updated, err := config.db.TemplatesStamp()
+	if err != nil {
+		return false, err
+	}
+
+	config.templatesMu.Lock()
+	defer config.templatesMu.Unlock()
+	return count != config.templatesCount || !updated.Equal(config.templatesUpdated), nil
+}
+
+// watchTemplates spawns a background job that periodically checks the templates in the database and reloads them if they changed,
+// so templates stored through another backend instance are put into use here too.
+// NOTE: This function returns immediately.
+func (config *Config) watchTemplates(interval time.Duration, logger zerolog.Logger) {
+	go func() {
+		for {
+			time.Sleep(interval)
+			changed, err := config.templatesChanged()
+			if err != nil {
+				logger.Error().Err(err).Msg("failed to check templates in database")
+				continue
+			}
+			if !changed {
+				continue
+			}
+			loaded, err := config.loadTemplates()
+			if err != nil {
+				logger.Error().Err(err).Msg("failed to reload templates")
+				continue
+			}
+			logger.Info().Int("loaded", loaded).Msg("reloaded changed templates")
+		}
+	}()
+}
+
 // initDB initialises a new database pool to be used across the application.
 func (config *Config) initDB(logger zerolog.Logger) (err error) {
 	config.db, err = psql.NewPoolServiceFromEnv()
 	if err == nil {
 		config.db.SetLogger(logger)
+		if config.schemas != nil {
+			config.db.SetValidator(config.schemas)
+		}
 	}
 	return err
 }
 
+// initJobs sets up the job queue with the handlers for sync and publish jobs; workers are started separately.
+func (config *Config) initJobs() {
+	config.jobs = jobs.NewQueue(config.db, config.NewLogger("jobs"))
+	shared.RegisterJobs(config.jobs, config.NewMetaxService(), config.db, config.NewLogger("sync"))
+}
+
 // initSessions initialises the session manager.
 func (config *Config) initSessions() error {
 	config.sessions = sessions.NewManager()
@@ -139,9 +344,21 @@ func (config *Config) initMessenger() (err error) {
 	return
 }
 
-// NewMetaxService initialises a metax service.
-// TODO: worth putting it here?
-//func (config *Config) NewMetaxService() *metax.MetaxService {}
+// NewMetaxService initialises the metax service on first use; later calls return the same instance,
+// so all users share its connections and circuit breaker.
+func (config *Config) NewMetaxService() *metax.MetaxService {
+	if config.metax == nil {
+		policy := metax.DefaultRetryPolicy
+		policy.Attempts = config.MetaxRetries
+		config.metax = metax.NewMetaxService(
+			config.MetaxApiHost,
+			metax.WithCredentials(config.metaxApiUser, config.metaxApiPass),
+			metax.WithRetry(policy),
+		)
+		metricsState.Set("metax", config.metax.Breaker())
+	}
+	return config.metax
+}
 
 // getHostname gets the HTTP hostname from the environment or os, and returns an error on failure.
 // The hostname is used as vhost in http and in token audience checks, so it is important to get this right.
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/NatLibFi/qvain-api/internal/psql"
+	"github.com/NatLibFi/qvain-api/pkg/jsondiff"
+	"github.com/NatLibFi/qvain-api/pkg/models"
+
+	"github.com/francoispqt/gojay"
+	"github.com/wvh/uuid"
+)
+
+var errMissingMerge = errors.New("merge needs research_dataset")
+
+// Conflict handles requests for a dataset that was edited both locally and in Metax since the last sync:
+//
+//   GET  conflict          view conflict with changes from local copy to incoming record (viewer)
+//   POST conflict/resolve  resolve conflict with "mine", "theirs" or "merge" (editor)
+func (api *DatasetApi) Conflict(w http.ResponseWriter, r *http.Request, user *models.User, id uuid.UUID) {
+	op := ShiftUrlWithTrailing(r)
+
+	switch op {
+	case "":
+		if checkMethod(w, r, http.MethodGet) {
+			api.getConflict(w, r, user.Uid, id)
+		}
+	case "resolve":
+		if checkMethod(w, r, http.MethodPost) {
+			api.resolveConflict(w, r, user.Uid, id)
+		}
+	default:
+		jsonError(w, "invalid conflict operation", http.StatusNotFound)
+	}
+}
+
+// ListConflicts lists the user's datasets with unresolved sync conflicts.
+func (api *DatasetApi) ListConflicts(w http.ResponseWriter, r *http.Request, user *models.User) {
+	jsondata, err := api.db.ViewConflicts(user.Uid)
+	if err != nil {
+		api.logger.Error().Err(err).Str("uid", user.Uid.String()).Msg("error listing conflicts")
+		dbError(w, err)
+		return
+	}
+
+	apiWriteHeaders(w)
+	w.Write(jsondata)
+}
+
+func (api *DatasetApi) getConflict(w http.ResponseWriter, r *http.Request, uid uuid.UUID, id uuid.UUID) {
+	conflict, err := api.db.ViewConflict(id, uid)
+	if dbError(w, err) {
+		return
+	}
+
+	changes, err := jsondiff.Diff(conflict.Mine, conflict.Theirs)
+	if err != nil {
+		jsonError(w, "can't compare datasets: "+err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	out, err := json.Marshal(struct {
+		Id             string            `json:"id"`
+		Detected       time.Time         `json:"detected"`
+		RemoteModified *time.Time        `json:"remote_modified,omitempty"`
+		Mine           json.RawMessage   `json:"mine"`
+		Theirs         json.RawMessage   `json:"theirs"`
+		Changes        []jsondiff.Change `json:"changes"`
+	}{id.String(), conflict.Detected, conflict.RemoteModified, conflict.Mine, conflict.Theirs, changes})
+	if err != nil {
+		jsonError(w, "can't serialise conflict", http.StatusInternalServerError)
+		return
+	}
+
+	apiWriteHeaders(w)
+	w.Write(out)
+}
+
+// resolveRequest is the body of a request to resolve a conflict.
+type resolveRequest struct {
+	// Resolution is one of psql.ResolveMine, psql.ResolveTheirs or psql.ResolveMerge.
+	Resolution string `json:"resolution"`
+
+	// ResearchDataset is the merged research_dataset; only used for psql.ResolveMerge.
+	ResearchDataset json.RawMessage `json:"research_dataset"`
+}
+
+// parseResolveRequest decodes and checks a request to resolve a conflict.
+func parseResolveRequest(r *http.Request) (*resolveRequest, error) {
+	req := new(resolveRequest)
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		return nil, psql.ErrInvalidJson
+	}
+
+	switch req.Resolution {
+	case psql.ResolveMine, psql.ResolveTheirs:
+		req.ResearchDataset = nil
+	case psql.ResolveMerge:
+		if len(req.ResearchDataset) == 0 || string(req.ResearchDataset) == "null" {
+			return nil, errMissingMerge
+		}
+	default:
+		return nil, psql.ErrInvalidResolution
+	}
+
+	return req, nil
+}
+
+func (api *DatasetApi) resolveConflict(w http.ResponseWriter, r *http.Request, uid uuid.UUID, id uuid.UUID) {
+	if r.Body == nil || r.Body == http.NoBody {
+		jsonError(w, "empty body", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	req, err := parseResolveRequest(r)
+	if err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	seq, err := api.db.ResolveConflict(id, uid, req.Resolution, req.ResearchDataset)
+	if err != nil {
+		api.logger.Error().Err(err).Str("dataset", id.String()).Str("resolution", req.Resolution).Msg("can't resolve conflict")
+		dbError(w, err)
+		return
+	}
+
+	api.logger.Info().Str("dataset", id.String()).Str("uid", uid.String()).Str("resolution", req.Resolution).Msg("resolved conflict")
+
+	apiWriteHeaders(w)
+	w.Header().Set("ETag", seqETag(seq))
+
+	enc := gojay.BorrowEncoder(w)
+	defer enc.Release()
+
+	enc.AppendByte('{')
+	enc.AddIntKey("status", http.StatusOK)
+	enc.AddStringKey("msg", "conflict resolved")
+	enc.AddStringKey("id", id.String())
+	enc.AddStringKey("resolution", req.Resolution)
+	enc.AddIntKey("seq", seq)
+	enc.AppendByte('}')
+	enc.Write()
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/NatLibFi/qvain-api/internal/psql"
+)
+
+func TestParseResolveRequest(t *testing.T) {
+	var tests = []struct {
+		body   string
+		merged string
+		err    error
+	}{
+		{body: `{"resolution":"mine"}`},
+		{body: `{"resolution":"theirs","research_dataset":{"title":{"en":"ignored"}}}`},
+		{body: `{"resolution":"merge","research_dataset":{"title":{"en":"merged"}}}`, merged: `{"title":{"en":"merged"}}`},
+		{body: `{"resolution":"merge"}`, err: errMissingMerge},
+		{body: `{"resolution":"merge","research_dataset":null}`, err: errMissingMerge},
+		{body: `{"resolution":"yours"}`, err: psql.ErrInvalidResolution},
+		{body: `{}`, err: psql.ErrInvalidResolution},
+		{body: `{"resolution":`, err: psql.ErrInvalidJson},
+	}
+
+	for _, test := range tests {
+		t.Run(test.body, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/api/datasets/x/conflict/resolve", strings.NewReader(test.body))
+
+			req, err := parseResolveRequest(r)
+			if err != test.err {
+				t.Fatalf("error: expected %v, got %v", test.err, err)
+			}
+			if err == nil && string(req.ResearchDataset) != test.merged {
+				t.Errorf("research_dataset: expected %q, got %q", test.merged, req.ResearchDataset)
+			}
+		})
+	}
+}
@@ -1,31 +1,56 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"math"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
-	//"time"
+	"time"
 
+	"github.com/NatLibFi/qvain-api/internal/jobs"
 	"github.com/NatLibFi/qvain-api/internal/psql"
 	"github.com/NatLibFi/qvain-api/internal/sessions"
 	"github.com/NatLibFi/qvain-api/internal/shared"
+	"github.com/NatLibFi/qvain-api/pkg/export"
+	"github.com/NatLibFi/qvain-api/pkg/jsondiff"
 	"github.com/NatLibFi/qvain-api/pkg/metax"
 	"github.com/NatLibFi/qvain-api/pkg/models"
+	"github.com/NatLibFi/qvain-api/pkg/validation"
 
 	"github.com/francoispqt/gojay"
 	"github.com/rs/zerolog"
+	"github.com/tidwall/gjson"
 	"github.com/wvh/uuid"
 )
 
 // DefaultIdentity is the user identity to show to the outside world.
 const DefaultIdentity = "fairdata"
 
+// MaxImportSize is the maximum size in bytes of a bulk import request body.
+const MaxImportSize = 64 << 20
+
+// SyncWait and PublishWait are how long a request waits for its sync or publish job before answering; the job carries on regardless.
+const (
+	SyncWait    = 15 * time.Second
+	PublishWait = 15 * time.Second
+)
+
+var errInvalidKeyPath = errors.New("invalid key path")
+
 type DatasetApi struct {
 	db       *psql.DB
 	sessions *sessions.Manager
 	metax    *metax.MetaxService
 	logger   zerolog.Logger
 
+	jobs     *jobs.Queue
 	identity string
+	schemas  *validation.Registry
 }
 
 func NewDatasetApi(db *psql.DB, sessions *sessions.Manager, metax *metax.MetaxService, logger zerolog.Logger) *DatasetApi {
@@ -35,6 +60,7 @@ func NewDatasetApi(db *psql.DB, sessions *sessions.Manager, metax *metax.MetaxSe
 		metax:    metax,
 		logger:   logger,
 		identity: DefaultIdentity,
+		schemas:  validation.Builtin(),
 	}
 }
 
@@ -44,6 +70,18 @@ func (api *DatasetApi) SetIdentity(identity string) {
 	api.identity = identity
 }
 
+// SetJobs sets the job queue used to sync and publish datasets.
+// It is not safe to call this method after instantiation.
+func (api *DatasetApi) SetJobs(queue *jobs.Queue) {
+	api.jobs = queue
+}
+
+// SetSchemas sets the schema registry used for validation reports.
+// It is not safe to call this method after instantiation.
+func (api *DatasetApi) SetSchemas(schemas *validation.Registry) {
+	api.schemas = schemas
+}
+
 func (api *DatasetApi) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// authenticated api
 	session, err := api.sessions.SessionFromRequest(r)
@@ -77,6 +115,44 @@ func (api *DatasetApi) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// full-text search
+	if head == "search" {
+		if checkMethod(w, r, http.MethodGet) {
+			api.SearchDatasets(w, r, user)
+		}
+		return
+	}
+
+	// incoming transfers
+	if head == "transfers" {
+		if checkMethod(w, r, http.MethodGet) {
+			api.ListTransfers(w, r, user)
+		}
+		return
+	}
+
+	// sync history
+	if head == "sync" || head == "sync/" {
+		api.Sync(w, r, user)
+		return
+	}
+
+	// unresolved sync conflicts
+	if head == "conflicts" {
+		if checkMethod(w, r, http.MethodGet) {
+			api.ListConflicts(w, r, user)
+		}
+		return
+	}
+
+	// bulk import
+	if head == "import" {
+		if checkMethod(w, r, http.MethodPost) {
+			api.importDatasets(w, r, user)
+		}
+		return
+	}
+
 	// dataset uuid
 	id, err := GetUuidParam(head)
 	if err != nil {
@@ -89,26 +165,34 @@ func (api *DatasetApi) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 }
 
 func (api *DatasetApi) ListDatasets(w http.ResponseWriter, r *http.Request, user *models.User) {
-	switch r.URL.RawQuery {
-	case "":
-	case "fetch":
+	query := r.URL.Query()
+
+	filter, err := parseListFilter(query)
+	if err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if filter.Project != "" && !user.HasProject(filter.Project) {
+		jsonError(w, "not a project member", http.StatusForbidden)
+		return
+	}
+
+	if _, fetch := query["fetch"]; fetch {
 		api.logger.Debug().Str("op", "fetch").Msg("datasets")
-		err := shared.Fetch(api.metax, api.db, api.logger, user.Uid, user.Identity)
-		if err != nil {
-			// TODO: handle mixed error
-			jsonError(w, err.Error(), http.StatusBadRequest)
-			//dbError(w, err)
+		jobId, err := shared.EnqueueFetch(api.jobs, user.Uid, user.Identity, shared.TriggerUser)
+		if !api.waitForSync(w, r, jobId, err) {
 			return
 		}
-	case "fetchall":
+	} else if _, fetchall := query["fetchall"]; fetchall {
 		api.logger.Debug().Str("op", "fetchall").Msg("datasets")
-		shared.FetchAll(api.metax, api.db, api.logger, user.Uid, user.Identity)
-	default:
-		jsonError(w, "invalid parameter", http.StatusBadRequest)
-		return
+		jobId, err := shared.EnqueueFetchAll(api.jobs, user.Uid, user.Identity, shared.TriggerUser)
+		if !api.waitForSync(w, r, jobId, err) {
+			return
+		}
 	}
 
-	jsondata, err := api.db.ViewDatasetsByOwner(user.Uid)
+	jsondata, next, err := api.db.ViewDatasetsByOwnerWithFilter(user.Uid, filter)
 	if err != nil {
 		api.logger.Error().Err(err).Str("uid", user.Uid.String()).Msg("error listing datasets")
 		dbError(w, err)
@@ -116,10 +200,149 @@ func (api *DatasetApi) ListDatasets(w http.ResponseWriter, r *http.Request, user
 	}
 
 	apiWriteHeaders(w)
+	if next != nil {
+		w.Header().Set("Link", nextLink(r, next))
+	}
 	//w.Header().Set("Cache-Control", "private, max-age=300")
 	w.Write(jsondata)
 }
 
+// waitForSync waits up to SyncWait for a queued sync job and tells if the request can go on; if not, it has written an error response.
+// A sync that takes longer finishes in the background, so the list may not be up to date yet; see /api/datasets/sync for the outcome of each sync.
+// If the sync was skipped because the user synced just before, the X-Sync-Skipped header names the last sync run.
+func (api *DatasetApi) waitForSync(w http.ResponseWriter, r *http.Request, jobId int64, err error) bool {
+	if err != nil {
+		api.logger.Error().Err(err).Msg("can't queue sync")
+		dbError(w, err)
+		return false
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), SyncWait)
+	defer cancel()
+
+	job, err := api.jobs.Wait(ctx, jobId)
+	switch err {
+	case nil:
+		var result shared.SyncResult
+		if len(job.Result) > 0 && json.Unmarshal(job.Result, &result) == nil && result.Skipped {
+			lastRun := result.LastRun
+			if lastRun == "" {
+				lastRun = "unknown"
+			}
+			w.Header().Set("X-Sync-Skipped", lastRun)
+		}
+	case jobs.ErrDead:
+		jsonError(w, "sync failed: "+jobError(job), http.StatusBadGateway)
+		return false
+	case context.DeadlineExceeded, context.Canceled:
+		api.logger.Debug().Int64("job", jobId).Msg("sync still running")
+	default:
+		api.logger.Error().Err(err).Int64("job", jobId).Msg("can't get sync job")
+	}
+	return true
+}
+
+// parseListFilter builds a dataset filter from the query parameters of a list request.
+func parseListFilter(query url.Values) (*psql.DatasetFilter, error) {
+	filter := new(psql.DatasetFilter)
+
+	for key, values := range query {
+		value := values[len(values)-1]
+
+		switch key {
+		case "fetch", "fetchall":
+		case "trash":
+			filter.Trashed = true
+		case "limit":
+			limit, err := strconv.Atoi(value)
+			if err != nil || limit < 1 || limit > psql.MaxListLimit {
+				return nil, errors.New("invalid limit")
+			}
+			filter.Limit = limit
+		case "cursor":
+			cursor, err := psql.ParseCursor(value)
+			if err != nil {
+				return nil, err
+			}
+			filter.Cursor = cursor
+		case "sort":
+			filter.Sort = value
+		case "published":
+			published, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, errors.New("invalid value for published")
+			}
+			filter.Published = &published
+		case "schema":
+			filter.Schema = value
+		case "q":
+			filter.Query = value
+		case "project":
+			filter.Project = value
+		default:
+			return nil, errors.New("invalid parameter")
+		}
+	}
+
+	return filter, nil
+}
+
+// nextLink returns a Link header value pointing to the next page of the current request.
+func nextLink(r *http.Request, next *psql.Cursor) string {
+	query := r.URL.Query()
+	query.Del("fetch")
+	query.Del("fetchall")
+	query.Set("cursor", next.String())
+
+	// the router shifts r.URL.Path, so take the full path from the original request
+	link := &url.URL{RawQuery: query.Encode()}
+	if orig, err := url.ParseRequestURI(r.RequestURI); err == nil {
+		link.Path = orig.Path
+	}
+	return "<" + link.String() + `>; rel="next"`
+}
+
+// SearchDatasets does a full-text search over the user's datasets.
+//
+// Query parameters: q (required), lang (fi, sv, en), limit and offset.
+func (api *DatasetApi) SearchDatasets(w http.ResponseWriter, r *http.Request, user *models.User) {
+	query := r.URL.Query()
+
+	q := strings.TrimSpace(query.Get("q"))
+	if q == "" {
+		jsonError(w, "missing search query", http.StatusBadRequest)
+		return
+	}
+
+	lang := query.Get("lang")
+	if !psql.SearchLanguageIsValid(lang) {
+		jsonError(w, "invalid language", http.StatusBadRequest)
+		return
+	}
+
+	limit, err := intParam(query, "limit", 20, 1, psql.MaxSearchLimit)
+	if err != nil {
+		jsonError(w, "invalid limit", http.StatusBadRequest)
+		return
+	}
+
+	offset, err := intParam(query, "offset", 0, 0, math.MaxInt32)
+	if err != nil {
+		jsonError(w, "invalid offset", http.StatusBadRequest)
+		return
+	}
+
+	jsondata, err := api.db.SearchDatasetsByOwner(user.Uid, q, lang, limit, offset)
+	if err != nil {
+		api.logger.Error().Err(err).Str("uid", user.Uid.String()).Msg("error searching datasets")
+		dbError(w, err)
+		return
+	}
+
+	apiWriteHeaders(w)
+	w.Write(jsondata)
+}
+
 // Dataset handles requests for a dataset by UUID. It dispatches to request method specific handlers.
 func (api *DatasetApi) Dataset(w http.ResponseWriter, r *http.Request, user *models.User, id uuid.UUID) {
 	//api.logger.Debug().Str("head", "").Str("path", r.URL.Path).Msg("dataset")
@@ -137,11 +360,14 @@ func (api *DatasetApi) Dataset(w http.ResponseWriter, r *http.Request, user *mod
 		case http.MethodPut:
 			api.updateDataset(w, r, user, id)
 			return
+		case http.MethodPatch:
+			api.patchDataset(w, r, user, id)
+			return
 		case http.MethodDelete:
 			api.deleteDataset(w, r, user.Uid, id)
 			return
 		case http.MethodOptions:
-			apiWriteOptions(w, "GET, PUT, DELETE, OPTIONS")
+			apiWriteOptions(w, "GET, PUT, PATCH, DELETE, OPTIONS")
 			return
 
 		default:
@@ -153,26 +379,235 @@ func (api *DatasetApi) Dataset(w http.ResponseWriter, r *http.Request, user *mod
 	// dataset operations
 	switch op {
 	case "export":
-		// TODO: assess security implementations before enabling this
-		jsonError(w, "export not implemented", http.StatusNotImplemented)
+		if checkMethod(w, r, http.MethodGet) {
+			api.exportDataset(w, r, user.Uid, id)
+		}
 		return
 	case "versions":
 		if checkMethod(w, r, http.MethodGet) {
 			api.ListVersions(w, r, user.Uid, id)
 		}
 		return
+	case "clone":
+		if checkMethod(w, r, http.MethodPost) {
+			api.cloneDataset(w, r, user.Uid, id)
+		}
+		return
+	case "validate":
+		if checkMethod(w, r, http.MethodGet) {
+			api.validateDataset(w, r, user.Uid, id)
+		}
+		return
+	case "diff":
+		if checkMethod(w, r, http.MethodGet) {
+			api.diffDataset(w, r, user.Uid, id)
+		}
+		return
 	case "publish":
 		if checkMethod(w, r, http.MethodPost) {
-			api.publishDataset(w, r, user.Uid, id)
+			api.publishDataset(w, r, user, id)
+		}
+		return
+	case "revisions", "revisions/":
+		api.Revisions(w, r, user, id)
+		return
+	case "transfer", "transfer/":
+		api.Transfer(w, r, user, id)
+		return
+	case "conflict", "conflict/":
+		api.Conflict(w, r, user, id)
+		return
+	case "collaborators", "collaborators/":
+		api.Collaborators(w, r, user, id)
+		return
+	case "project":
+		api.Project(w, r, user, id)
+		return
+	case "restore":
+		if checkMethod(w, r, http.MethodPost) {
+			api.restoreDataset(w, r, user.Uid, id)
 		}
 		return
 	default:
-		jsonError(w, "invalid dataset operation", http.StatusNotFound)
+		// anything else is a key path into the dataset
+		path := strings.TrimSuffix(op, "/") + r.URL.Path
+		switch r.Method {
+		case http.MethodGet:
+			api.getDataset(w, r, user.Uid, id, path)
+		case http.MethodPut:
+			api.updateDatasetPath(w, r, user.Uid, id, path)
+		case http.MethodOptions:
+			apiWriteOptions(w, "GET, PUT, OPTIONS")
+		default:
+			jsonError(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		}
 		return
 	}
 	return
 }
 
+// exportDataset converts a dataset to the export format given in the `format` query parameter and sends it as attachment.
+func (api *DatasetApi) exportDataset(w http.ResponseWriter, r *http.Request, owner uuid.UUID, id uuid.UUID) {
+	name := r.URL.Query().Get("format")
+	if name == "" {
+		name = "qvain"
+	}
+
+	format, err := export.Lookup(name)
+	if err != nil {
+		jsonError(w, "unknown export format, available formats: "+strings.Join(export.Formats(), ", "), http.StatusBadRequest)
+		return
+	}
+
+	raw, err := api.db.ExportAsJsonWithOwner(id, owner, api.identity)
+	if err != nil {
+		dbError(w, err)
+		return
+	}
+
+	record, err := export.ParseRecord(raw)
+	if err != nil {
+		api.logger.Error().Err(err).Str("id", id.String()).Msg("can't parse record for export")
+		jsonError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	out, err := format.Export(record)
+	if err == export.ErrUnsupportedDataset {
+		jsonError(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	if err != nil {
+		api.logger.Error().Err(err).Str("id", id.String()).Str("format", name).Msg("export failed")
+		jsonError(w, "export failed", http.StatusInternalServerError)
+		return
+	}
+
+	apiWriteHeaders(w)
+	w.Header().Set("Content-Type", format.ContentType+"; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+format.Filename(record)+`"`)
+	w.Write(out)
+}
+
+// cloneDataset copies a dataset into a new unpublished draft ("save as new draft").
+func (api *DatasetApi) cloneDataset(w http.ResponseWriter, r *http.Request, owner uuid.UUID, id uuid.UUID) {
+	dataset, err := api.db.GetWithRole(id, owner, psql.RoleViewer)
+	if err != nil {
+		dbError(w, err)
+		return
+	}
+
+	newid, err := uuid.NewUUID()
+	if err != nil {
+		jsonError(w, "can't create id", http.StatusInternalServerError)
+		return
+	}
+
+	blob := dataset.Blob()
+	if dataset.Family() == metax.MetaxDatasetFamily {
+		blob, err = metax.CloneBlob(blob, newid)
+		if err != nil {
+			api.logger.Error().Err(err).Str("dataset", id.String()).Msg("can't clone dataset")
+			jsonError(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+	}
+
+	err = api.db.CloneWithOwner(id, newid, blob, owner)
+	if err != nil {
+		dbError(w, err)
+		return
+	}
+
+	api.logger.Debug().Str("dataset", id.String()).Str("clone", newid.String()).Msg("cloned dataset")
+	api.Created(w, r, newid)
+}
+
+// validateDataset runs all schema and business rule checks on a dataset and returns a report listing what needs fixing before publishing.
+func (api *DatasetApi) validateDataset(w http.ResponseWriter, r *http.Request, owner uuid.UUID, id uuid.UUID) {
+	dataset, err := api.db.GetWithRole(id, owner, psql.RoleViewer)
+	if dbError(w, err) {
+		return
+	}
+
+	report, err := api.schemas.Report(dataset.Schema(), dataset.Blob())
+	if err == validation.ErrUnknownSchema {
+		jsonError(w, "no validation schema for dataset schema "+dataset.Schema(), http.StatusUnprocessableEntity)
+		return
+	}
+	if err != nil {
+		api.logger.Error().Err(err).Str("dataset", id.String()).Msg("can't validate dataset")
+		jsonError(w, "can't validate dataset", http.StatusUnprocessableEntity)
+		return
+	}
+
+	out, err := json.Marshal(report)
+	if err != nil {
+		jsonError(w, "can't serialise report", http.StatusInternalServerError)
+		return
+	}
+
+	apiWriteHeaders(w)
+	w.Write(out)
+}
+
+// diffDataset compares the dataset's `research_dataset` with the published version in Metax and lists the changes made since.
+func (api *DatasetApi) diffDataset(w http.ResponseWriter, r *http.Request, owner uuid.UUID, id uuid.UUID) {
+	if against := r.URL.Query().Get("against"); against != "" && against != "published" {
+		jsonError(w, "can only compare against published version", http.StatusBadRequest)
+		return
+	}
+
+	dataset, err := api.db.GetWithRole(id, owner, psql.RoleViewer)
+	if dbError(w, err) {
+		return
+	}
+	if dataset.Family() != metax.MetaxDatasetFamily {
+		jsonError(w, "not a Metax dataset", http.StatusBadRequest)
+		return
+	}
+
+	identifier := metax.GetIdentifier(dataset.Blob())
+	if identifier == "" {
+		jsonError(w, "dataset has not been published", http.StatusConflict)
+		return
+	}
+
+	published, err := api.metax.GetId(r.Context(), identifier)
+	if err != nil {
+		api.logger.Warn().Err(err).Str("dataset", id.String()).Str("identifier", identifier).Msg("can't get published dataset")
+		if t, ok := err.(*metax.ApiError); ok {
+			jsonErrorWithPayload(w, t.Error(), "metax", t.OriginalError(), convertExternalStatusCode(t.StatusCode()))
+		} else {
+			jsonError(w, "can't get published dataset: "+err.Error(), http.StatusBadGateway)
+		}
+		return
+	}
+
+	changes, err := jsondiff.Diff(
+		[]byte(gjson.GetBytes(published, "research_dataset").Raw),
+		[]byte(gjson.GetBytes(dataset.Blob(), "research_dataset").Raw),
+	)
+	if err != nil {
+		jsonError(w, "can't compare datasets: "+err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	out, err := json.Marshal(struct {
+		Against    string            `json:"against"`
+		Identifier string            `json:"identifier"`
+		Changed    bool              `json:"changed"`
+		Changes    []jsondiff.Change `json:"changes"`
+	}{"published", identifier, len(changes) > 0, changes})
+	if err != nil {
+		jsonError(w, "can't serialise changes", http.StatusInternalServerError)
+		return
+	}
+
+	apiWriteHeaders(w)
+	w.Write(out)
+}
+
 // getDataset retrieves a dataset's whole blob or part thereof depending on the path.
 // Not all datasets are fully viewable through the API.
 func (api *DatasetApi) getDataset(w http.ResponseWriter, r *http.Request, owner uuid.UUID, id uuid.UUID, path string) {
@@ -183,16 +618,92 @@ func (api *DatasetApi) getDataset(w http.ResponseWriter, r *http.Request, owner
 		return
 	}
 
-	res, err := api.db.ViewDatasetWithOwner(id, owner, api.identity)
+	var (
+		res json.RawMessage
+		seq int
+		err error
+	)
+	if path == "" {
+		res, seq, err = api.db.ViewDatasetWithOwner(id, owner, api.identity)
+	} else {
+		keys, perr := splitKeyPath(path)
+		if perr != nil {
+			jsonError(w, perr.Error(), http.StatusBadRequest)
+			return
+		}
+		res, seq, err = api.db.ViewPathWithOwner(id, owner, keys)
+	}
 	if dbError(w, err) {
 		return
 	}
 
+	etag := seqETag(seq)
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		responseNotModified(w, r, etag)
+		return
+	}
+
 	apiWriteHeaders(w)
+	w.Header().Set("ETag", etag)
 	w.Write(res)
 	return
 }
 
+// updateDatasetPath replaces the JSON sub-tree at the given key path of a dataset.
+func (api *DatasetApi) updateDatasetPath(w http.ResponseWriter, r *http.Request, owner uuid.UUID, id uuid.UUID, path string) {
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		jsonError(w, http.StatusText(http.StatusUnsupportedMediaType), http.StatusUnsupportedMediaType)
+		return
+	}
+
+	keys, err := splitKeyPath(path)
+	if err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	seq, err := ifMatchSeq(r)
+	if err != nil {
+		jsonError(w, "invalid If-Match header", http.StatusPreconditionFailed)
+		return
+	}
+
+	if r.Body == nil || r.Body == http.NoBody {
+		jsonError(w, "empty body", http.StatusBadRequest)
+		return
+	}
+
+	defer r.Body.Close()
+
+	value, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		jsonError(w, "can't read body", http.StatusBadRequest)
+		return
+	}
+
+	newSeq, err := api.db.UpdatePathWithOwner(id, owner, keys, value, seq)
+	if err != nil {
+		api.logger.Debug().Err(err).Str("dataset", id.String()).Str("path", path).Msg("update dataset path failed")
+		dbError(w, err)
+		return
+	}
+
+	apiWriteHeaders(w)
+	w.Header().Set("ETag", seqETag(newSeq))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// splitKeyPath splits a slash-separated key path into its keys; numeric keys index arrays.
+func splitKeyPath(path string) ([]string, error) {
+	keys := strings.Split(path, "/")
+	for _, key := range keys {
+		if key == "" {
+			return nil, errInvalidKeyPath
+		}
+	}
+	return keys, nil
+}
+
 func (api *DatasetApi) createDataset(w http.ResponseWriter, r *http.Request, creator *models.User) {
 	var err error
 
@@ -208,6 +719,12 @@ func (api *DatasetApi) createDataset(w http.ResponseWriter, r *http.Request, cre
 
 	defer r.Body.Close()
 
+	project := r.URL.Query().Get("project")
+	if project != "" && !creator.HasProject(project) {
+		jsonError(w, "not a project member", http.StatusForbidden)
+		return
+	}
+
 	typed, err := models.CreateDatasetFromJson(creator.Uid, r.Body, map[string]string{"identity": creator.Identity, "org": creator.Organisation})
 	if err != nil {
 		api.logger.Error().Err(err).Msg("create dataset failed")
@@ -215,7 +732,11 @@ func (api *DatasetApi) createDataset(w http.ResponseWriter, r *http.Request, cre
 		return
 	}
 
-	err = api.db.Create(typed.Unwrap())
+	if project != "" {
+		err = api.db.CreateInProject(typed.Unwrap(), project)
+	} else {
+		err = api.db.Create(typed.Unwrap())
+	}
 	if err != nil {
 		//jsonError(w, "store failed", http.StatusBadRequest)
 		dbError(w, err)
@@ -225,6 +746,62 @@ func (api *DatasetApi) createDataset(w http.ResponseWriter, r *http.Request, cre
 	api.Created(w, r, typed.Unwrap().Id)
 }
 
+// importDatasets creates datasets in bulk from newline-delimited JSON or a JSON array and returns a report with the outcome for each dataset.
+func (api *DatasetApi) importDatasets(w http.ResponseWriter, r *http.Request, creator *models.User) {
+	ct := r.Header.Get("Content-Type")
+	if !strings.HasPrefix(ct, "application/x-ndjson") && !strings.HasPrefix(ct, "application/json") {
+		jsonError(w, http.StatusText(http.StatusUnsupportedMediaType), http.StatusUnsupportedMediaType)
+		return
+	}
+
+	if r.Body == nil || r.Body == http.NoBody {
+		jsonError(w, "empty body", http.StatusBadRequest)
+		return
+	}
+
+	defer r.Body.Close()
+
+	schema := r.URL.Query().Get("schema")
+	if schema == "" {
+		schema = metax.SchemaIda
+	}
+
+	body := http.MaxBytesReader(w, r.Body, MaxImportSize)
+	report, err := shared.Import(api.db, body, creator.Uid, map[string]string{"identity": creator.Identity, "org": creator.Organisation}, schema)
+	switch err {
+	case nil:
+	case shared.ErrTooManyRecords:
+		jsonError(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	case shared.ErrNothingToImport:
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	default:
+		if _, isDbError := err.(*psql.DatabaseError); isDbError {
+			dbError(w, err)
+			return
+		}
+		if isBodyTooLarge(err) {
+			jsonError(w, "import too large, maximum is "+strconv.Itoa(MaxImportSize>>20)+" MB", http.StatusRequestEntityTooLarge)
+			return
+		}
+		api.logger.Error().Err(err).Str("user", creator.Uid.String()).Msg("import failed")
+		jsonError(w, "import failed: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	api.logger.Info().Str("user", creator.Uid.String()).Int("imported", report.Imported).Int("failed", report.Failed).Msg("imported datasets")
+
+	out, err := json.Marshal(report)
+	if err != nil {
+		jsonError(w, "can't serialise report", http.StatusInternalServerError)
+		return
+	}
+
+	apiWriteHeaders(w)
+	w.Write(out)
+}
+
 func (api *DatasetApi) updateDataset(w http.ResponseWriter, r *http.Request, owner *models.User, id uuid.UUID) {
 	var err error
 
@@ -233,6 +810,12 @@ func (api *DatasetApi) updateDataset(w http.ResponseWriter, r *http.Request, own
 		return
 	}
 
+	seq, err := ifMatchSeq(r)
+	if err != nil {
+		jsonError(w, "invalid If-Match header", http.StatusPreconditionFailed)
+		return
+	}
+
 	if r.Body == nil || r.Body == http.NoBody {
 		jsonError(w, "empty body", http.StatusBadRequest)
 		return
@@ -251,37 +834,117 @@ func (api *DatasetApi) updateDataset(w http.ResponseWriter, r *http.Request, own
 
 	api.logger.Debug().Str("owner", owner.Uid.String()).Msg("owner")
 
-	err = api.db.SmartUpdateWithOwner(id, typed.Unwrap().Blob(), owner.Uid)
+	newSeq, err := api.db.SmartUpdateWithOwner(id, typed.Unwrap().Blob(), owner.Uid, seq)
 	if err != nil {
 		dbError(w, err)
 		return
 	}
 
+	w.Header().Set("ETag", seqETag(newSeq))
 	api.Created(w, r, typed.Unwrap().Id)
 }
 
-func (api *DatasetApi) publishDataset(w http.ResponseWriter, r *http.Request, owner uuid.UUID, id uuid.UUID) {
-	vId, nId, qId, err := shared.Publish(api.metax, api.db, id, owner)
+// patchDataset applies a JSON Merge Patch (RFC 7396) to a dataset, so clients can save single fields without sending the whole dataset.
+func (api *DatasetApi) patchDataset(w http.ResponseWriter, r *http.Request, owner *models.User, id uuid.UUID) {
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), "application/merge-patch+json") {
+		jsonError(w, http.StatusText(http.StatusUnsupportedMediaType), http.StatusUnsupportedMediaType)
+		return
+	}
+
+	seq, err := ifMatchSeq(r)
 	if err != nil {
-		switch t := err.(type) {
-		case *metax.ApiError:
-			api.logger.Warn().Err(err).Str("dataset", id.String()).Str("owner", owner.String()).Str("origin", "api").Msg("publish failed")
-			jsonErrorWithPayload(w, t.Error(), "metax", t.OriginalError(), convertExternalStatusCode(t.StatusCode()))
-		case *psql.DatabaseError:
-			api.logger.Error().Err(err).Str("dataset", id.String()).Str("owner", owner.String()).Str("origin", "database").Msg("publish failed")
-			dbError(w, err)
-		default:
-			api.logger.Error().Err(err).Str("dataset", id.String()).Str("owner", owner.String()).Str("origin", "other").Msg("publish failed")
-			jsonError(w, err.Error(), http.StatusInternalServerError)
+		jsonError(w, "invalid If-Match header", http.StatusPreconditionFailed)
+		return
+	}
+
+	if r.Body == nil || r.Body == http.NoBody {
+		jsonError(w, "empty body", http.StatusBadRequest)
+		return
+	}
+
+	defer r.Body.Close()
+
+	patch, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		jsonError(w, "can't read body", http.StatusBadRequest)
+		return
+	}
+
+	newSeq, err := api.db.MergePatchWithOwner(id, patch, owner.Uid, seq)
+	if err != nil {
+		api.logger.Debug().Err(err).Str("dataset", id.String()).Str("user", owner.Uid.String()).Msg("patch dataset failed")
+		dbError(w, err)
+		return
+	}
+
+	w.Header().Set("ETag", seqETag(newSeq))
+	api.Created(w, r, id)
+}
+
+// publishDataset queues publishing a dataset to Metax and waits up to PublishWait for the outcome.
+// If the job takes longer, or is waiting for a retry, the response is 202 Accepted with the job id to poll at /api/jobs/<id>.
+func (api *DatasetApi) publishDataset(w http.ResponseWriter, r *http.Request, user *models.User, id uuid.UUID) {
+	owner := user.Uid
+
+	// check before queueing, so the user gets the usual error right away
+	if err := api.db.CheckOwner(id, owner); err != nil {
+		dbError(w, err)
+		return
+	}
+
+	jobId, err := shared.EnqueuePublish(api.jobs, id, owner, api.identity)
+	if err != nil {
+		api.logger.Error().Err(err).Str("dataset", id.String()).Str("owner", owner.String()).Msg("can't queue publish")
+		dbError(w, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), PublishWait)
+	defer cancel()
+
+	job, err := api.jobs.Wait(ctx, jobId)
+	switch err {
+	case nil:
+	case jobs.ErrDead:
+		var res shared.PublishResult
+		json.Unmarshal(job.Result, &res)
+		if res.Origin == "metax" {
+			api.logger.Warn().Str("error", jobError(job)).Str("dataset", id.String()).Str("owner", owner.String()).Str("origin", "api").Msg("publish failed")
+			jsonErrorWithPayload(w, jobError(job), "metax", res.Payload, convertExternalStatusCode(res.Status))
+			return
 		}
+		api.logger.Error().Str("error", jobError(job)).Str("dataset", id.String()).Str("owner", owner.String()).Str("origin", "other").Msg("publish failed")
+		jsonError(w, jobError(job), http.StatusInternalServerError)
+		return
+	case context.DeadlineExceeded, context.Canceled:
+		api.Accepted(w, r, id, jobId, "publish queued")
+		return
+	default:
+		api.logger.Error().Err(err).Int64("job", jobId).Msg("can't get publish job")
+		dbError(w, err)
+		return
+	}
+
+	var res shared.PublishResult
+	if err := json.Unmarshal(job.Result, &res); err != nil {
+		jsonError(w, "invalid publish result", http.StatusInternalServerError)
 		return
 	}
 
-	api.Published(w, r, id, vId, qId, nId)
+	api.Published(w, r, id, res.Extid, res.NewId, res.NewExtid)
 }
 
+// jobError returns the error message of a failed job.
+func jobError(job *psql.Job) string {
+	if job == nil || job.Error == nil {
+		return "unknown error"
+	}
+	return *job.Error
+}
+
+// deleteDataset moves a dataset to the trash bin; it will be purged after the retention period.
 func (api *DatasetApi) deleteDataset(w http.ResponseWriter, r *http.Request, owner uuid.UUID, id uuid.UUID) {
-	err := api.db.Delete(id, &owner)
+	err := api.db.Trash(id, owner)
 	if err != nil {
 		dbError(w, err)
 		return
@@ -292,6 +955,19 @@ func (api *DatasetApi) deleteDataset(w http.ResponseWriter, r *http.Request, own
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// restoreDataset takes a dataset out of the trash bin.
+func (api *DatasetApi) restoreDataset(w http.ResponseWriter, r *http.Request, owner uuid.UUID, id uuid.UUID) {
+	err := api.db.RestoreFromTrash(id, owner)
+	if err != nil {
+		dbError(w, err)
+		return
+	}
+
+	// restored, return 204 No Content
+	apiWriteHeaders(w)
+	w.WriteHeader(http.StatusNoContent)
+}
+
 // ListVersions lists an array of existing versions for a given dataset and owner.
 func (api *DatasetApi) ListVersions(w http.ResponseWriter, r *http.Request, user uuid.UUID, id uuid.UUID) {
 	jsondata, err := api.db.ViewVersions(user, id)
@@ -305,6 +981,96 @@ func (api *DatasetApi) ListVersions(w http.ResponseWriter, r *http.Request, user
 	w.Write(jsondata)
 }
 
+// Revisions handles requests for the revision history of a dataset:
+//
+//   GET  revisions                list revisions
+//   GET  revisions/<seq>          view revision
+//   POST revisions/<seq>/restore  restore revision
+func (api *DatasetApi) Revisions(w http.ResponseWriter, r *http.Request, user *models.User, id uuid.UUID) {
+	head := ShiftUrlWithTrailing(r)
+	if head == "" {
+		if checkMethod(w, r, http.MethodGet) {
+			api.ListRevisions(w, r, user.Uid, id)
+		}
+		return
+	}
+
+	seq, err := strconv.Atoi(GetStringParam(head))
+	if err != nil || seq < 0 {
+		jsonError(w, "bad format for revision path parameter", http.StatusBadRequest)
+		return
+	}
+
+	op := ""
+	if HasSubroutes(head) {
+		op = ShiftUrlWithTrailing(r)
+	}
+
+	switch op {
+	case "":
+		if checkMethod(w, r, http.MethodGet) {
+			api.getRevision(w, r, user.Uid, id, seq)
+		}
+	case "restore":
+		if checkMethod(w, r, http.MethodPost) {
+			api.restoreRevision(w, r, user.Uid, id, seq)
+		}
+	default:
+		jsonError(w, "invalid revision operation", http.StatusNotFound)
+	}
+}
+
+// ListRevisions lists the revisions of a given dataset and owner, without the dataset contents.
+func (api *DatasetApi) ListRevisions(w http.ResponseWriter, r *http.Request, user uuid.UUID, id uuid.UUID) {
+	jsondata, err := api.db.ViewRevisions(id, user, api.identity)
+	if err != nil {
+		api.logger.Error().Err(err).Str("uid", user.String()).Str("dataset", id.String()).Msg("error getting revisions")
+		dbError(w, err)
+		return
+	}
+
+	apiWriteHeaders(w)
+	w.Write(jsondata)
+}
+
+// getRevision retrieves a single revision of a dataset.
+func (api *DatasetApi) getRevision(w http.ResponseWriter, r *http.Request, owner uuid.UUID, id uuid.UUID, seq int) {
+	res, err := api.db.ViewRevisionWithOwner(id, owner, seq, api.identity)
+	if dbError(w, err) {
+		return
+	}
+
+	// revisions never change
+	apiWriteHeaders(w)
+	w.Header().Set("ETag", seqETag(seq))
+	w.Write(res)
+}
+
+// restoreRevision makes a new revision of a dataset with the contents of an earlier one.
+func (api *DatasetApi) restoreRevision(w http.ResponseWriter, r *http.Request, owner uuid.UUID, id uuid.UUID, seq int) {
+	newSeq, err := api.db.RestoreRevisionWithOwner(id, owner, seq)
+	if err != nil {
+		api.logger.Error().Err(err).Str("dataset", id.String()).Str("owner", owner.String()).Int("seq", seq).Msg("restore failed")
+		dbError(w, err)
+		return
+	}
+
+	apiWriteHeaders(w)
+	w.Header().Set("ETag", seqETag(newSeq))
+
+	enc := gojay.BorrowEncoder(w)
+	defer enc.Release()
+
+	enc.AppendByte('{')
+	enc.AddIntKey("status", http.StatusOK)
+	enc.AddStringKey("msg", "revision restored")
+	enc.AddStringKey("id", id.String())
+	enc.AddIntKey("restored", seq)
+	enc.AddIntKey("seq", newSeq)
+	enc.AppendByte('}')
+	enc.Write()
+}
+
 // redirectToNew redirects to the location of a newly created (POST) or updated (PUT) resource.
 // Note that http.Redirect() will write and send the headers, so set ours before.
 func (api *DatasetApi) redirectToNew(w http.ResponseWriter, r *http.Request, id uuid.UUID) {
@@ -346,6 +1112,24 @@ func (api *DatasetApi) Created(w http.ResponseWriter, r *http.Request, id uuid.U
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// Accepted writes a 202 Accepted response for a dataset operation that runs as job id in the background.
+func (api *DatasetApi) Accepted(w http.ResponseWriter, r *http.Request, id uuid.UUID, jobId int64, msg string) {
+	apiWriteHeaders(w)
+	w.Header().Set("Location", "/api/jobs/"+strconv.FormatInt(jobId, 10))
+	w.WriteHeader(http.StatusAccepted)
+
+	enc := gojay.BorrowEncoder(w)
+	defer enc.Release()
+
+	enc.AppendByte('{')
+	enc.AddIntKey("status", http.StatusAccepted)
+	enc.AddStringKey("msg", msg)
+	enc.AddStringKey("id", id.String())
+	enc.AddInt64Key("job", jobId)
+	enc.AppendByte('}')
+	enc.Write()
+}
+
 func (api *DatasetApi) Published(w http.ResponseWriter, r *http.Request, id uuid.UUID, extid string, newId *uuid.UUID, newExtid string) {
 	apiWriteHeaders(w)
 	enc := gojay.BorrowEncoder(w)
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/NatLibFi/qvain-api/internal/psql"
+	"github.com/wvh/uuid"
+)
+
+func TestParseListFilter(t *testing.T) {
+	var tests = []struct {
+		query string
+		ok    bool
+		check func(*psql.DatasetFilter) bool
+	}{
+		{query: "", ok: true, check: func(f *psql.DatasetFilter) bool { return *f == psql.DatasetFilter{} }},
+		{query: "fetch", ok: true},
+		{query: "trash", ok: true, check: func(f *psql.DatasetFilter) bool { return f.Trashed }},
+		{query: "limit=20&sort=-created", ok: true, check: func(f *psql.DatasetFilter) bool { return f.Limit == 20 && f.Sort == "-created" }},
+		{query: "published=true&schema=metax-ida&q=rain", ok: true, check: func(f *psql.DatasetFilter) bool {
+			return f.Published != nil && *f.Published && f.Schema == "metax-ida" && f.Query == "rain"
+		}},
+		{query: "published=false", ok: true, check: func(f *psql.DatasetFilter) bool { return f.Published != nil && !*f.Published }},
+		{query: "project=2001036", ok: true, check: func(f *psql.DatasetFilter) bool { return f.Project == "2001036" }},
+		{query: "limit=0", ok: false},
+		{query: "limit=501", ok: false},
+		{query: "limit=ten", ok: false},
+		{query: "published=maybe", ok: false},
+		{query: "cursor=bogus", ok: false},
+		{query: "owner=someone", ok: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.query, func(t *testing.T) {
+			query, _ := url.ParseQuery(test.query)
+			filter, err := parseListFilter(query)
+			if (err == nil) != test.ok {
+				t.Fatalf("expected ok=%v, got error %v", test.ok, err)
+			}
+			if test.check != nil && !test.check(filter) {
+				t.Errorf("unexpected filter: %+v", filter)
+			}
+		})
+	}
+}
+
+func TestNextLink(t *testing.T) {
+	cursor := &psql.Cursor{Sort: "modified", Value: time.Now(), Id: uuid.MustNewUUID()}
+	r := httptest.NewRequest("GET", "/api/datasets/?fetch&limit=10", nil)
+	r.URL.Path = "/"
+
+	expected := `</api/datasets/?cursor=` + cursor.String() + `&limit=10>; rel="next"`
+	if link := nextLink(r, cursor); link != expected {
+		t.Errorf("expected %s, got %s", expected, link)
+	}
+}
+
+func TestSplitKeyPath(t *testing.T) {
+	var tests = []struct {
+		path string
+		keys []string
+	}{
+		{path: "contracts", keys: []string{"contracts"}},
+		{path: "research_dataset/creator", keys: []string{"research_dataset", "creator"}},
+		{path: "research_dataset/creator/0/name", keys: []string{"research_dataset", "creator", "0", "name"}},
+		{path: "research_dataset/", keys: nil},
+		{path: "research_dataset//creator", keys: nil},
+		{path: "", keys: nil},
+	}
+
+	for _, test := range tests {
+		keys, err := splitKeyPath(test.path)
+		if test.keys == nil {
+			if err != errInvalidKeyPath {
+				t.Errorf("%q: expected error, got %v", test.path, keys)
+			}
+			continue
+		}
+		if err != nil || strings.Join(keys, ",") != strings.Join(test.keys, ",") {
+			t.Errorf("%q: expected %v, got %v (%v)", test.path, test.keys, keys, err)
+		}
+	}
+}
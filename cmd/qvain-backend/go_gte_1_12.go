@@ -1,6 +0,0 @@
-// +build go1.12
-
-package main
-
-// Since go 1.12, some inlined functions are hidden from view.
-const skipFrameCount = 4
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/NatLibFi/qvain-api/internal/psql"
+	"github.com/NatLibFi/qvain-api/internal/sessions"
+	"github.com/NatLibFi/qvain-api/pkg/models"
+
+	"github.com/francoispqt/gojay"
+	"github.com/rs/zerolog"
+)
+
+const (
+	// DefaultJobs is the number of jobs listed if the request doesn't specify otherwise.
+	DefaultJobs = 50
+
+	// MaxJobs is the maximum number of jobs that can be listed at once.
+	MaxJobs = 500
+)
+
+var errInvalidJobState = errors.New("invalid job state")
+
+// JobApi shows the background job queue and lets admins retry dead jobs.
+type JobApi struct {
+	db       *psql.DB
+	sessions *sessions.Manager
+	admins   Admins
+	logger   zerolog.Logger
+}
+
+// NewJobApi sets up the job API.
+func NewJobApi(db *psql.DB, sessions *sessions.Manager, admins Admins, logger zerolog.Logger) *JobApi {
+	return &JobApi{
+		db:       db,
+		sessions: sessions,
+		admins:   admins,
+		logger:   logger,
+	}
+}
+
+// ServeHTTP handles requests for background jobs:
+//
+//   GET  /?state=&kind=&limit=  list latest jobs, newest first (admin)
+//   GET  /<id>                  view job (admin or the user the job runs for)
+//   POST /<id>/retry            run dead job again (admin)
+func (api *JobApi) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	session, err := api.sessions.SessionFromRequest(r)
+	if err != nil {
+		jsonError(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	user := session.User
+
+	head := ShiftUrlWithTrailing(r)
+	if head == "" {
+		if !checkMethod(w, r, http.MethodGet) {
+			return
+		}
+		if !api.admins.Has(user) {
+			jsonError(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		api.listJobs(w, r)
+		return
+	}
+
+	id, err := strconv.ParseInt(TrimSlash(head), 10, 64)
+	if err != nil || id < 1 {
+		jsonError(w, "bad format for job id path parameter", http.StatusBadRequest)
+		return
+	}
+
+	switch ShiftUrlWithTrailing(r) {
+	case "":
+		if checkMethod(w, r, http.MethodGet) {
+			api.getJob(w, r, user, id)
+		}
+	case "retry":
+		if !checkMethod(w, r, http.MethodPost) {
+			return
+		}
+		if !api.admins.Has(user) {
+			jsonError(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		api.retryJob(w, r, user, id)
+	default:
+		jsonError(w, "invalid job operation", http.StatusNotFound)
+	}
+}
+
+// parseJobFilter reads the state and kind to list jobs by from the query; both are optional.
+func parseJobFilter(query url.Values) (state string, kind string, err error) {
+	state = query.Get("state")
+	switch state {
+	case "", psql.JobPending, psql.JobRunning, psql.JobDone, psql.JobDead:
+	default:
+		return "", "", errInvalidJobState
+	}
+	return state, query.Get("kind"), nil
+}
+
+func (api *JobApi) listJobs(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	state, kind, err := parseJobFilter(query)
+	if err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	limit, err := intParam(query, "limit", DefaultJobs, 1, MaxJobs)
+	if err != nil {
+		jsonError(w, "invalid limit", http.StatusBadRequest)
+		return
+	}
+
+	jsondata, err := api.db.ViewJobs(state, kind, limit)
+	if err != nil {
+		api.logger.Error().Err(err).Msg("error listing jobs")
+		dbError(w, err)
+		return
+	}
+
+	apiWriteHeaders(w)
+	w.Write(jsondata)
+}
+
+func (api *JobApi) getJob(w http.ResponseWriter, r *http.Request, user *models.User, id int64) {
+	job, err := api.db.GetJob(id)
+	if dbError(w, err) {
+		return
+	}
+
+	// users can follow their own jobs, such as a queued publish; don't tell others the job exists
+	if !api.admins.Has(user) && (job.Uid == nil || *job.Uid != user.Uid) {
+		dbError(w, psql.ErrNotFound)
+		return
+	}
+
+	out, err := json.Marshal(job)
+	if err != nil {
+		jsonError(w, "can't serialise job", http.StatusInternalServerError)
+		return
+	}
+
+	apiWriteHeaders(w)
+	w.Write(out)
+}
+
+func (api *JobApi) retryJob(w http.ResponseWriter, r *http.Request, user *models.User, id int64) {
+	err := api.db.RetryJob(id)
+	if err == psql.ErrNotFound {
+		jsonError(w, "no dead job with this id", http.StatusNotFound)
+		return
+	}
+	if dbError(w, err) {
+		return
+	}
+
+	api.logger.Info().Int64("job", id).Str("admin", user.Uid.String()).Msg("retrying job")
+
+	apiWriteHeaders(w)
+	enc := gojay.BorrowEncoder(w)
+	defer enc.Release()
+
+	enc.AppendByte('{')
+	enc.AddIntKey("status", http.StatusOK)
+	enc.AddStringKey("msg", "job queued for retry")
+	enc.AddInt64Key("id", id)
+	enc.AppendByte('}')
+	enc.Write()
+}
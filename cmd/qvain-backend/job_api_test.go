@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestParseJobFilter(t *testing.T) {
+	var tests = []struct {
+		query string
+		state string
+		kind  string
+		fail  bool
+	}{
+		{query: ""},
+		{query: "state=dead", state: "dead"},
+		{query: "state=pending&kind=publish", state: "pending", kind: "publish"},
+		{query: "kind=sync", kind: "sync"},
+		{query: "state=failed", fail: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.query, func(t *testing.T) {
+			query, _ := url.ParseQuery(test.query)
+			state, kind, err := parseJobFilter(query)
+			if test.fail {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if state != test.state || kind != test.kind {
+				t.Errorf("expected state %q kind %q, got %q %q", test.state, test.kind, state, kind)
+			}
+		})
+	}
+}
@@ -13,6 +13,9 @@ import (
 	"github.com/rs/zerolog/log"
 )
 
+// skipFrameCount is the number of stack frames between the logging call and the hook; since go 1.12, some inlined functions are hidden from view.
+const skipFrameCount = 4
+
 type locationHook struct {
 	name          string
 	stackInfoFunc func() string
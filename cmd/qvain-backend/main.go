@@ -2,6 +2,7 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"net/http"
@@ -77,12 +78,50 @@ func main() {
 		logger.Warn().Msg("environment variable APP_ENV_CHECK is not set")
 	}
 
+	// load dataset schemas; built-in schemas stay in use for any schema that failed to load
+	err = config.initSchemas()
+	if err != nil {
+		logger.Error().Err(err).Str("dir", config.SchemaDir).Msg("failed to load schemas")
+	}
+
 	// initialise database pool
 	err = config.initDB(config.NewLogger("psql"))
 	if err != nil {
 		logger.Error().Err(err).Msg("daba baad")
 	}
 
+	// schema versions activated through the admin API take precedence over built-in and file-based schemas
+	if config.db != nil && config.schemas != nil {
+		if _, err := config.loadActiveSchemas(); err != nil {
+			logger.Error().Err(err).Msg("failed to load schemas from database")
+		}
+		config.watchActiveSchemas(SchemaReloadInterval, config.NewLogger("schemas"))
+	}
+
+	// dataset templates; built-in templates stay in use if loading fails
+	if _, err := config.loadTemplates(); err != nil {
+		logger.Error().Err(err).Str("dir", config.TemplateDir).Msg("failed to load templates")
+	}
+	if config.db != nil {
+		config.watchTemplates(TemplateReloadInterval, config.NewLogger("templates"))
+	}
+
+	// purge old datasets from the trash bin in the background
+	if config.db != nil {
+		startTrashPurger(config.db, config.TrashRetention, config.NewLogger("purge"))
+	}
+
+	// run syncs and publishing from the job queue in the database
+	config.initJobs()
+	if config.db != nil {
+		config.jobs.Start(context.Background(), config.JobWorkers)
+	}
+
+	// sync users' datasets from Metax in the background, so changes made in other tools show up without login
+	if config.db != nil && config.SyncInterval > 0 {
+		startSyncScheduler(config.jobs, config.db, config.SyncInterval, config.NewLogger("sync"))
+	}
+
 	// initialise session manager
 	err = config.initSessions()
 	if err != nil {
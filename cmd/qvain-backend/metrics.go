@@ -9,13 +9,16 @@ import (
 
 var (
 	// api counters
-	datasetsC expvar.Int
-	objectsC  expvar.Int
-	sessionsC expvar.Int
-	authC     expvar.Int
-	proxyC    expvar.Int
-	lookupC   expvar.Int
-	versionC  expvar.Int
+	datasetsC  expvar.Int
+	objectsC   expvar.Int
+	sessionsC  expvar.Int
+	authC      expvar.Int
+	proxyC     expvar.Int
+	lookupC    expvar.Int
+	schemasC   expvar.Int
+	templatesC expvar.Int
+	jobsC      expvar.Int
+	versionC   expvar.Int
 
 	// map containers
 	metricsState = expvar.NewMap("app.state")
@@ -65,6 +68,9 @@ func init() {
 	metricsApis.Set("auth", &authC)
 	metricsApis.Set("proxy", &proxyC)
 	metricsApis.Set("lookup", &lookupC)
+	metricsApis.Set("schemas", &schemasC)
+	metricsApis.Set("templates", &templatesC)
+	metricsApis.Set("jobs", &jobsC)
 	metricsApis.Set("version", &versionC)
 
 	startupVar.Set(startupTime.UTC().Format(time.RFC3339))
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/NatLibFi/qvain-api/internal/psql"
+	"github.com/NatLibFi/qvain-api/pkg/models"
+
+	"github.com/wvh/uuid"
+)
+
+var errMissingProject = errors.New("missing project")
+
+// Project handles requests for handing a dataset over to an IDA project, so all project members can view and edit it:
+//
+//   PUT    project  set the dataset's project (owner and project member)
+//   DELETE project  take the dataset away from its project (owner)
+func (api *DatasetApi) Project(w http.ResponseWriter, r *http.Request, user *models.User, id uuid.UUID) {
+	switch r.Method {
+	case http.MethodPut:
+		api.setProject(w, r, user, id)
+	case http.MethodDelete:
+		api.writeProject(w, id, user.Uid, nil)
+	case http.MethodOptions:
+		apiWriteOptions(w, "PUT, DELETE, OPTIONS")
+	default:
+		jsonError(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+// projectRequest is the body of a request to set a dataset's project.
+type projectRequest struct {
+	// Project is the IDA project identifier.
+	Project string `json:"project"`
+}
+
+// parseProjectRequest decodes and checks a project request.
+func parseProjectRequest(r *http.Request) (string, error) {
+	var req projectRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return "", psql.ErrInvalidJson
+	}
+
+	if req.Project == "" {
+		return "", errMissingProject
+	}
+
+	return req.Project, nil
+}
+
+func (api *DatasetApi) setProject(w http.ResponseWriter, r *http.Request, user *models.User, id uuid.UUID) {
+	if r.Body == nil || r.Body == http.NoBody {
+		jsonError(w, "empty body", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	project, err := parseProjectRequest(r)
+	if err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if !user.HasProject(project) {
+		jsonError(w, "not a project member", http.StatusForbidden)
+		return
+	}
+
+	api.writeProject(w, id, user.Uid, &project)
+}
+
+// writeProject changes the dataset's project and writes the response.
+func (api *DatasetApi) writeProject(w http.ResponseWriter, id uuid.UUID, owner uuid.UUID, project *string) {
+	err := api.db.SetProject(id, owner, project)
+	if err != nil {
+		dbError(w, err)
+		return
+	}
+
+	ev := api.logger.Info().Str("dataset", id.String()).Str("owner", owner.String())
+	if project != nil {
+		ev = ev.Str("project", *project)
+	}
+	ev.Msg("set project")
+
+	apiWriteHeaders(w)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/NatLibFi/qvain-api/internal/psql"
+)
+
+func TestParseProjectRequest(t *testing.T) {
+	var tests = []struct {
+		body    string
+		project string
+		err     error
+	}{
+		{body: `{"project":"2001036"}`, project: "2001036"},
+		{body: `{"project":""}`, err: errMissingProject},
+		{body: `{}`, err: errMissingProject},
+		{body: `{"project":`, err: psql.ErrInvalidJson},
+	}
+
+	for _, test := range tests {
+		t.Run(test.body, func(t *testing.T) {
+			r := httptest.NewRequest("PUT", "/api/datasets/x/project", strings.NewReader(test.body))
+
+			project, err := parseProjectRequest(r)
+			if err != test.err {
+				t.Fatalf("error: expected %v, got %v", test.err, err)
+			}
+			if project != test.project {
+				t.Errorf("project: expected %q, got %q", test.project, project)
+			}
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"time"
+
+	"github.com/NatLibFi/qvain-api/internal/psql"
+	"github.com/rs/zerolog"
+)
+
+// TrashPurgeInterval is the time between runs of the trash purge job.
+const TrashPurgeInterval = 6 * time.Hour
+
+// startTrashPurger spawns a background job that permanently deletes datasets that have been in the trash bin for longer than the retention period.
+// NOTE: This function returns immediately.
+func startTrashPurger(db *psql.DB, retention time.Duration, logger zerolog.Logger) {
+	logger.Info().Dur("retention", retention).Dur("interval", TrashPurgeInterval).Msg("starting trash purge job")
+	go func() {
+		for {
+			purged, err := db.PurgeTrash(retention)
+			if err != nil {
+				logger.Error().Err(err).Msg("trash purge failed")
+			} else if purged > 0 {
+				logger.Info().Int64("purged", purged).Msg("purged datasets from trash")
+			}
+
+			// sync history is kept as long as the trash
+			purged, err = db.PurgeSyncRuns(retention)
+			if err != nil {
+				logger.Error().Err(err).Msg("sync run purge failed")
+			} else if purged > 0 {
+				logger.Info().Int64("purged", purged).Msg("purged old sync runs")
+			}
+
+			// so are finished jobs; dead ones stay until an admin retries them
+			purged, err = db.PurgeJobs(retention)
+			if err != nil {
+				logger.Error().Err(err).Msg("job purge failed")
+			} else if purged > 0 {
+				logger.Info().Int64("purged", purged).Msg("purged finished jobs")
+			}
+			time.Sleep(TrashPurgeInterval)
+		}
+	}()
+}
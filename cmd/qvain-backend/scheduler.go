@@ -0,0 +1,85 @@
+package main
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/NatLibFi/qvain-api/internal/jobs"
+	"github.com/NatLibFi/qvain-api/internal/psql"
+	"github.com/NatLibFi/qvain-api/internal/shared"
+	"github.com/rs/zerolog"
+)
+
+const (
+	// DefaultSyncInterval is the time after which a user's datasets are synced from Metax in the background (env APP_SYNC_INTERVAL).
+	DefaultSyncInterval = "6h"
+
+	// DefaultJobWorkers is the number of background jobs, such as syncs, run at the same time (env APP_JOB_WORKERS).
+	DefaultJobWorkers = 4
+
+	// SyncJitter is the maximum random delay before syncing a user, to spread the load on Metax.
+	SyncJitter = time.Minute
+)
+
+// syncScheduler periodically queues syncs of the datasets of all login users from Metax.
+type syncScheduler struct {
+	jobs     *jobs.Queue
+	db       *psql.DB
+	identity string
+	interval time.Duration
+	logger   zerolog.Logger
+}
+
+// startSyncScheduler spawns a background job that queues syncs for users who haven't been synced successfully within the interval.
+// The syncs are run by the job workers, so their number bounds how many users are synced at the same time.
+// NOTE: This function returns immediately.
+func startSyncScheduler(queue *jobs.Queue, db *psql.DB, interval time.Duration, logger zerolog.Logger) {
+	s := &syncScheduler{
+		jobs:     queue,
+		db:       db,
+		identity: DefaultIdentity,
+		interval: interval,
+		logger:   logger,
+	}
+
+	logger.Info().Dur("interval", interval).Msg("starting sync scheduler")
+	go func() {
+		for {
+			// wait first, so restarts don't hammer Metax
+			time.Sleep(jitter(s.interval/10) + s.interval/10)
+			s.run()
+		}
+	}()
+}
+
+// run queues a sync for all users that are due, each with a random delay.
+// Users who already have a sync in the queue are not queued again.
+func (s *syncScheduler) run() {
+	users, err := s.db.SyncCandidates(s.identity, time.Now().Add(-s.interval))
+	if err != nil {
+		s.logger.Error().Err(err).Msg("can't get users to sync")
+		return
+	}
+	if len(users) == 0 {
+		return
+	}
+
+	failed := 0
+	for _, user := range users {
+		at := time.Now().Add(jitter(SyncJitter))
+		if _, err := shared.EnqueueFetchSince(s.jobs, user.Uid, user.Identity, user.Last, shared.TriggerSchedule, at); err != nil {
+			s.logger.Warn().Err(err).Str("user", user.Uid.String()).Msg("can't queue scheduled sync")
+			failed++
+		}
+	}
+
+	s.logger.Info().Int("users", len(users)).Int("failed", failed).Msg("queued scheduled syncs")
+}
+
+// jitter returns a random duration between 0 and max.
+func jitter(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(max)))
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestJitter(t *testing.T) {
+	if d := jitter(0); d != 0 {
+		t.Errorf("expected no jitter for zero max, got %v", d)
+	}
+	if d := jitter(-time.Second); d != 0 {
+		t.Errorf("expected no jitter for negative max, got %v", d)
+	}
+
+	for i := 0; i < 100; i++ {
+		if d := jitter(time.Second); d < 0 || d >= time.Second {
+			t.Fatalf("jitter out of range: %v", d)
+		}
+	}
+}
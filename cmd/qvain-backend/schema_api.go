@@ -0,0 +1,263 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/NatLibFi/qvain-api/internal/psql"
+	"github.com/NatLibFi/qvain-api/internal/sessions"
+	"github.com/NatLibFi/qvain-api/pkg/jsonschema"
+	"github.com/NatLibFi/qvain-api/pkg/models"
+	"github.com/NatLibFi/qvain-api/pkg/validation"
+
+	"github.com/francoispqt/gojay"
+	"github.com/rs/zerolog"
+)
+
+// MaxSchemaSize is the maximum size in bytes of an uploaded schema.
+const MaxSchemaSize = 1 << 20
+
+// SchemaReloadInterval is the time after which schema versions activated through another backend instance are put into use.
+const SchemaReloadInterval = time.Minute
+
+var (
+	errInvalidSchemaName = errors.New("invalid schema name")
+	errMissingSchema     = errors.New("missing schema")
+)
+
+// SchemaApi serves the JSON Schemas used to validate datasets and lets admins upload new versions.
+type SchemaApi struct {
+	db       *psql.DB
+	sessions *sessions.Manager
+	schemas  *validation.Registry
+	admins   Admins
+	logger   zerolog.Logger
+
+	identity string
+}
+
+// NewSchemaApi sets up the schema API.
+func NewSchemaApi(db *psql.DB, sessions *sessions.Manager, schemas *validation.Registry, admins Admins, logger zerolog.Logger) *SchemaApi {
+	return &SchemaApi{
+		db:       db,
+		sessions: sessions,
+		schemas:  schemas,
+		admins:   admins,
+		logger:   logger,
+		identity: DefaultIdentity,
+	}
+}
+
+// ServeHTTP handles requests for dataset schemas:
+//
+//   GET  /                                list schema names and active versions
+//   GET  /<name>                          view active schema with UI hints
+//   GET  /<name>/versions                 list uploaded versions (admin)
+//   POST /<name>/versions                 upload new version (admin)
+//   POST /<name>/versions/<ver>/activate  use version for validation (admin)
+func (api *SchemaApi) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	session, err := api.sessions.SessionFromRequest(r)
+	if err != nil {
+		jsonError(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	user := session.User
+
+	head := ShiftUrlWithTrailing(r)
+	if head == "" {
+		if checkMethod(w, r, http.MethodGet) {
+			api.listSchemas(w, r)
+		}
+		return
+	}
+
+	name := TrimSlash(head)
+	if !isValidSchemaName(name) {
+		jsonError(w, errInvalidSchemaName.Error(), http.StatusBadRequest)
+		return
+	}
+
+	op := ShiftUrlWithTrailing(r)
+	switch op {
+	case "":
+		if checkMethod(w, r, http.MethodGet) {
+			api.getSchema(w, r, name)
+		}
+		return
+	case "versions", "versions/":
+	default:
+		jsonError(w, "invalid schema operation", http.StatusNotFound)
+		return
+	}
+
+	if !api.admins.Has(user) {
+		jsonError(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+
+	version := ShiftUrlWithTrailing(r)
+	if version == "" {
+		switch r.Method {
+		case http.MethodGet:
+			api.listVersions(w, r, name)
+		case http.MethodPost:
+			api.uploadSchema(w, r, user, name)
+		case http.MethodOptions:
+			apiWriteOptions(w, "GET, POST, OPTIONS")
+		default:
+			jsonError(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
+	ver, err := strconv.Atoi(TrimSlash(version))
+	if err != nil || ver < 1 {
+		jsonError(w, "invalid schema version", http.StatusBadRequest)
+		return
+	}
+	if ShiftUrlWithTrailing(r) != "activate" {
+		jsonError(w, "invalid schema operation", http.StatusNotFound)
+		return
+	}
+	if checkMethod(w, r, http.MethodPost) {
+		api.activateSchema(w, r, user, name, ver)
+	}
+}
+
+// listSchemas lists the names and versions of the schemas in use.
+func (api *SchemaApi) listSchemas(w http.ResponseWriter, r *http.Request) {
+	out, err := json.Marshal(api.schemas.List())
+	if err != nil {
+		jsonError(w, "can't serialise schemas", http.StatusInternalServerError)
+		return
+	}
+
+	apiWriteHeaders(w)
+	w.Write(out)
+}
+
+// getSchema returns the schema in use for a name, along with its version and UI hints.
+func (api *SchemaApi) getSchema(w http.ResponseWriter, r *http.Request, name string) {
+	info, ok := api.schemas.Get(name)
+	if !ok {
+		jsonError(w, validation.ErrUnknownSchema.Error(), http.StatusNotFound)
+		return
+	}
+
+	out, err := json.Marshal(info)
+	if err != nil {
+		jsonError(w, "can't serialise schema", http.StatusInternalServerError)
+		return
+	}
+
+	apiWriteHeaders(w)
+	w.Write(out)
+}
+
+// listVersions lists the versions of a schema uploaded to the database.
+func (api *SchemaApi) listVersions(w http.ResponseWriter, r *http.Request, name string) {
+	jsondata, err := api.db.ViewSchemaVersions(name, api.identity)
+	if dbError(w, err) {
+		return
+	}
+
+	apiWriteHeaders(w)
+	w.Write(jsondata)
+}
+
+// uploadSchema stores a new version of a schema; the body is `{"schema": {...}, "ui": {...}}` where `ui` is optional.
+func (api *SchemaApi) uploadSchema(w http.ResponseWriter, r *http.Request, user *models.User, name string) {
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		jsonError(w, http.StatusText(http.StatusUnsupportedMediaType), http.StatusUnsupportedMediaType)
+		return
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, MaxSchemaSize))
+	if err != nil {
+		jsonError(w, "can't read request body", http.StatusBadRequest)
+		return
+	}
+
+	schema, ui, err := parseSchemaUpload(body)
+	if err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	version, err := api.db.AddSchema(name, schema, ui, user.Uid)
+	if dbError(w, err) {
+		return
+	}
+
+	api.logger.Info().Str("schema", name).Int("version", version).Str("uid", user.Uid.String()).Msg("uploaded schema")
+
+	apiWriteHeaders(w)
+	w.WriteHeader(http.StatusCreated)
+
+	enc := gojay.BorrowEncoder(w)
+	defer enc.Release()
+
+	enc.AppendByte('{')
+	enc.AddIntKey("status", http.StatusCreated)
+	enc.AddStringKey("msg", "created")
+	enc.AddStringKey("name", name)
+	enc.AddIntKey("version", version)
+	enc.AppendByte('}')
+	enc.Write()
+}
+
+// activateSchema makes a schema version the one used for validation.
+func (api *SchemaApi) activateSchema(w http.ResponseWriter, r *http.Request, user *models.User, name string, version int) {
+	sv, err := api.db.ActivateSchema(name, version)
+	if dbError(w, err) {
+		return
+	}
+
+	if err := api.schemas.AddVersion(sv.Name, sv.Version, sv.Schema, sv.Ui); err != nil {
+		api.logger.Error().Err(err).Str("schema", name).Int("version", version).Msg("can't load activated schema")
+		jsonError(w, "can't load schema: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	api.logger.Info().Str("schema", name).Int("version", version).Str("uid", user.Uid.String()).Msg("activated schema")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// parseSchemaUpload extracts the JSON Schema and UI hints from an upload request body and checks that the schema compiles.
+func parseSchemaUpload(body []byte) (json.RawMessage, json.RawMessage, error) {
+	var upload struct {
+		Schema json.RawMessage `json:"schema"`
+		Ui     json.RawMessage `json:"ui"`
+	}
+	if err := json.Unmarshal(body, &upload); err != nil {
+		return nil, nil, err
+	}
+	if len(upload.Schema) == 0 || string(upload.Schema) == "null" {
+		return nil, nil, errMissingSchema
+	}
+	if _, err := jsonschema.Compile(upload.Schema); err != nil {
+		return nil, nil, err
+	}
+	if string(upload.Ui) == "null" {
+		upload.Ui = nil
+	}
+	return upload.Schema, upload.Ui, nil
+}
+
+// isValidSchemaName checks that a schema name only has lower case letters, digits, dashes, underscores and dots.
+func isValidSchemaName(name string) bool {
+	if name == "" || len(name) > 64 {
+		return false
+	}
+	for _, c := range name {
+		if !((c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') || c == '-' || c == '_' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
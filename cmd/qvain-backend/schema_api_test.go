@@ -0,0 +1,72 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/NatLibFi/qvain-api/pkg/models"
+)
+
+func TestParseSchemaUpload(t *testing.T) {
+	var tests = []struct {
+		body   string
+		schema string
+		ui     string
+		fail   bool
+	}{
+		{body: `{"schema":{"required":["research_dataset"]}}`, schema: `{"required":["research_dataset"]}`},
+		{body: `{"schema":{"type":"object"},"ui":{"order":["title"]}}`, schema: `{"type":"object"}`, ui: `{"order":["title"]}`},
+		{body: `{"schema":true,"ui":null}`, schema: `true`},
+		{body: `{"ui":{}}`, fail: true},
+		{body: `{"schema":null}`, fail: true},
+		{body: `{"schema":{"pattern":"("}}`, fail: true},
+		{body: `{"schema":`, fail: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.body, func(t *testing.T) {
+			schema, ui, err := parseSchemaUpload([]byte(test.body))
+			if test.fail {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(schema) != test.schema {
+				t.Errorf("schema: expected %s, got %s", test.schema, schema)
+			}
+			if string(ui) != test.ui {
+				t.Errorf("ui: expected %s, got %s", test.ui, ui)
+			}
+		})
+	}
+}
+
+func TestIsValidSchemaName(t *testing.T) {
+	for _, name := range []string{"metax-ida", "metax_att", "v2.1"} {
+		if !isValidSchemaName(name) {
+			t.Errorf("expected %q to be valid", name)
+		}
+	}
+	for _, name := range []string{"", "Metax", "a/b", "a b", "ä"} {
+		if isValidSchemaName(name) {
+			t.Errorf("expected %q to be invalid", name)
+		}
+	}
+}
+
+func TestParseAdmins(t *testing.T) {
+	admins := parseAdmins("alice@fairdataid, bob@fairdataid")
+
+	if !admins.Has(&models.User{Identity: "alice@fairdataid"}) || !admins.Has(&models.User{Identity: "bob@fairdataid"}) {
+		t.Errorf("expected listed identities to be admins: %v", admins)
+	}
+	if admins.Has(&models.User{Identity: "eve@fairdataid"}) || admins.Has(&models.User{}) || admins.Has(nil) {
+		t.Error("unexpected admin")
+	}
+	if len(parseAdmins("")) != 0 {
+		t.Error("expected no admins for empty list")
+	}
+}
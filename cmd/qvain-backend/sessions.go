@@ -5,10 +5,10 @@ import (
 	"strings"
 	"time"
 
+	"github.com/NatLibFi/qvain-api/internal/jobs"
 	"github.com/NatLibFi/qvain-api/internal/psql"
 	"github.com/NatLibFi/qvain-api/internal/sessions"
 	"github.com/NatLibFi/qvain-api/internal/shared"
-	"github.com/NatLibFi/qvain-api/pkg/metax"
 	"github.com/NatLibFi/qvain-api/pkg/models"
 
 	gooidc "github.com/coreos/go-oidc"
@@ -87,6 +87,11 @@ func MakeSessionHandlerForFairdata(mgr *sessions.Manager, db *psql.DB, onLogin l
 				user.Projects = projects
 				logger.Debug().Strs("projects", projects).Msg("ida projects in token")
 			}
+
+			// project members can access the project's datasets; keep the database in sync with the token
+			if err := db.SetProjects(uid, user.Projects); err != nil {
+				logger.Warn().Err(err).Str("uid", uid.String()).Msg("failed to store project memberships")
+			}
 		}
 
 		sid, err := mgr.NewLoginWithCookie(
@@ -111,9 +116,13 @@ func MakeSessionHandlerForFairdata(mgr *sessions.Manager, db *psql.DB, onLogin l
 
 type loginHook func(*models.User) error
 
-func makeOnFairdataLogin(metax *metax.MetaxService, db *psql.DB, logger zerolog.Logger) loginHook {
+func makeOnFairdataLogin(queue *jobs.Queue, logger zerolog.Logger) loginHook {
 	return func(user *models.User) error {
-		return shared.Fetch(metax, db, logger, user.Uid, user.Identity)
+		_, err := shared.EnqueueFetch(queue, user.Uid, user.Identity, shared.TriggerLogin)
+		if err != nil {
+			logger.Warn().Err(err).Str("uid", user.Uid.String()).Msg("can't queue sync on login")
+		}
+		return err
 	}
 }
 
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/NatLibFi/qvain-api/pkg/models"
+
+	"github.com/rs/xid"
+)
+
+const (
+	// DefaultSyncRuns is the number of sync runs listed if the request doesn't specify otherwise.
+	DefaultSyncRuns = 20
+
+	// MaxSyncRuns is the maximum number of sync runs that can be listed at once.
+	MaxSyncRuns = 100
+)
+
+// Sync handles requests for the history of the user's syncs from Metax:
+//
+//   GET sync          list latest sync runs, newest first (?limit=)
+//   GET sync/<id>     view sync run with the reasons for skipped datasets
+func (api *DatasetApi) Sync(w http.ResponseWriter, r *http.Request, user *models.User) {
+	if !checkMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	head := ShiftUrlWithTrailing(r)
+	if head == "" {
+		api.listSyncRuns(w, r, user)
+		return
+	}
+	if HasSubroutes(head) {
+		jsonError(w, "invalid sync operation", http.StatusNotFound)
+		return
+	}
+
+	id, err := xid.FromString(head)
+	if err != nil {
+		jsonError(w, "bad format for sync id path parameter", http.StatusBadRequest)
+		return
+	}
+
+	jsondata, err := api.db.ViewSyncRun(user.Uid, id.String())
+	if dbError(w, err) {
+		return
+	}
+
+	apiWriteHeaders(w)
+	w.Write(jsondata)
+}
+
+func (api *DatasetApi) listSyncRuns(w http.ResponseWriter, r *http.Request, user *models.User) {
+	limit, err := intParam(r.URL.Query(), "limit", DefaultSyncRuns, 1, MaxSyncRuns)
+	if err != nil {
+		jsonError(w, "invalid limit", http.StatusBadRequest)
+		return
+	}
+
+	jsondata, err := api.db.ViewSyncRuns(user.Uid, limit)
+	if err != nil {
+		api.logger.Error().Err(err).Str("uid", user.Uid.String()).Msg("error listing sync runs")
+		dbError(w, err)
+		return
+	}
+
+	apiWriteHeaders(w)
+	w.Write(jsondata)
+}
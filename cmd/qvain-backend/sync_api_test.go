@@ -0,0 +1,35 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/NatLibFi/qvain-api/pkg/models"
+)
+
+func TestSyncRouting(t *testing.T) {
+	var tests = []struct {
+		method string
+		path   string
+		status int
+	}{
+		{method: "POST", path: "", status: http.StatusMethodNotAllowed},
+		{method: "GET", path: "/not-an-xid", status: http.StatusBadRequest},
+		{method: "GET", path: "/9m4e2mr0ui3e8a215n4g/skips", status: http.StatusNotFound},
+	}
+
+	api := &DatasetApi{}
+	for _, test := range tests {
+		t.Run(test.method+" "+test.path, func(t *testing.T) {
+			r := httptest.NewRequest(test.method, "/api/datasets/sync"+test.path, nil)
+			r.URL.Path = test.path
+			w := httptest.NewRecorder()
+
+			api.Sync(w, r, &models.User{})
+			if w.Code != test.status {
+				t.Errorf("expected status %d, got %d: %s", test.status, w.Code, w.Body)
+			}
+		})
+	}
+}
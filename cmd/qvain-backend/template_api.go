@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/NatLibFi/qvain-api/internal/psql"
+	"github.com/NatLibFi/qvain-api/internal/sessions"
+	"github.com/NatLibFi/qvain-api/pkg/metax"
+	"github.com/NatLibFi/qvain-api/pkg/models"
+
+	"github.com/francoispqt/gojay"
+	"github.com/rs/zerolog"
+)
+
+// MaxTemplateSize is the maximum size in bytes of an uploaded template.
+const MaxTemplateSize = 256 << 10
+
+// TemplateReloadInterval is the time after which templates changed through another backend instance are put into use.
+const TemplateReloadInterval = time.Minute
+
+// TemplateApi serves the templates for new datasets and lets admins change and reload them.
+type TemplateApi struct {
+	db       *psql.DB
+	sessions *sessions.Manager
+	admins   Admins
+	reload   func() (int, error)
+	logger   zerolog.Logger
+}
+
+// NewTemplateApi sets up the template API; reload is called to rebuild the templates in use after a change.
+func NewTemplateApi(db *psql.DB, sessions *sessions.Manager, admins Admins, reload func() (int, error), logger zerolog.Logger) *TemplateApi {
+	return &TemplateApi{
+		db:       db,
+		sessions: sessions,
+		admins:   admins,
+		reload:   reload,
+		logger:   logger,
+	}
+}
+
+// ServeHTTP handles requests for dataset templates:
+//
+//   GET    /                  list templates
+//   GET    /<schema>?org=     view template for schema (and organisation)
+//   PUT    /<schema>?org=     store template in the database (admin)
+//   DELETE /<schema>?org=     remove template from the database (admin)
+//   POST   /reload            reload templates from directory and database (admin)
+func (api *TemplateApi) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	session, err := api.sessions.SessionFromRequest(r)
+	if err != nil {
+		jsonError(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	user := session.User
+
+	head := ShiftUrlWithTrailing(r)
+	if head == "" {
+		if checkMethod(w, r, http.MethodGet) {
+			api.listTemplates(w, r)
+		}
+		return
+	}
+
+	if head == "reload" {
+		if !checkMethod(w, r, http.MethodPost) {
+			return
+		}
+		if !api.admins.Has(user) {
+			jsonError(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		api.reloadTemplates(w, r, user)
+		return
+	}
+
+	schema := TrimSlash(head)
+	if !isValidSchemaName(schema) || r.URL.Path != "" {
+		jsonError(w, errInvalidSchemaName.Error(), http.StatusBadRequest)
+		return
+	}
+	org := r.URL.Query().Get("org")
+
+	switch r.Method {
+	case http.MethodGet:
+		api.getTemplate(w, r, schema, org)
+		return
+	case http.MethodOptions:
+		apiWriteOptions(w, "GET, PUT, DELETE, OPTIONS")
+		return
+	case http.MethodPut, http.MethodDelete:
+	default:
+		jsonError(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !api.admins.Has(user) {
+		jsonError(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	if r.Method == http.MethodPut {
+		api.setTemplate(w, r, user, schema, org)
+	} else {
+		api.deleteTemplate(w, r, user, schema, org)
+	}
+}
+
+// listTemplates lists the templates in use.
+func (api *TemplateApi) listTemplates(w http.ResponseWriter, r *http.Request) {
+	out, err := json.Marshal(metax.CurrentTemplates().List())
+	if err != nil {
+		jsonError(w, "can't serialise templates", http.StatusInternalServerError)
+		return
+	}
+
+	apiWriteHeaders(w)
+	w.Write(out)
+}
+
+// getTemplate returns the template used for new datasets of a schema by users of the given organisation.
+func (api *TemplateApi) getTemplate(w http.ResponseWriter, r *http.Request, schema string, org string) {
+	template, ok := metax.CurrentTemplates().Get(schema, org)
+	if !ok {
+		jsonError(w, metax.ErrUnknownSchema.Error(), http.StatusNotFound)
+		return
+	}
+
+	out, err := json.Marshal(template)
+	if err != nil {
+		jsonError(w, "can't serialise template", http.StatusInternalServerError)
+		return
+	}
+
+	apiWriteHeaders(w)
+	w.Write(out)
+}
+
+// setTemplate stores a template in the database and reloads the templates.
+func (api *TemplateApi) setTemplate(w http.ResponseWriter, r *http.Request, user *models.User, schema string, org string) {
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		jsonError(w, http.StatusText(http.StatusUnsupportedMediaType), http.StatusUnsupportedMediaType)
+		return
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, MaxTemplateSize))
+	if err != nil {
+		jsonError(w, "can't read request body", http.StatusBadRequest)
+		return
+	}
+
+	// check before storing so a broken template can't break reloading
+	if err := metax.NewTemplates().Add(schema, org, body); err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if dbError(w, api.db.SetTemplate(schema, org, body, user.Uid)) {
+		return
+	}
+
+	api.logger.Info().Str("schema", schema).Str("org", org).Str("uid", user.Uid.String()).Msg("stored template")
+	api.reloadTemplates(w, r, user)
+}
+
+// deleteTemplate removes a template from the database and reloads the templates.
+func (api *TemplateApi) deleteTemplate(w http.ResponseWriter, r *http.Request, user *models.User, schema string, org string) {
+	if dbError(w, api.db.DeleteTemplate(schema, org)) {
+		return
+	}
+
+	api.logger.Info().Str("schema", schema).Str("org", org).Str("uid", user.Uid.String()).Msg("deleted template")
+	api.reloadTemplates(w, r, user)
+}
+
+// reloadTemplates rebuilds the templates in use and returns the number of templates loaded besides the built-in ones.
+func (api *TemplateApi) reloadTemplates(w http.ResponseWriter, r *http.Request, user *models.User) {
+	n, err := api.reload()
+	if err != nil {
+		api.logger.Error().Err(err).Msg("can't reload templates")
+		jsonError(w, "can't reload templates: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	api.logger.Info().Int("loaded", n).Str("uid", user.Uid.String()).Msg("reloaded templates")
+
+	apiWriteHeaders(w)
+	enc := gojay.BorrowEncoder(w)
+	defer enc.Release()
+
+	enc.AppendByte('{')
+	enc.AddIntKey("status", http.StatusOK)
+	enc.AddStringKey("msg", "reloaded")
+	enc.AddIntKey("loaded", n)
+	enc.AppendByte('}')
+	enc.Write()
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/NatLibFi/qvain-api/internal/psql"
+	"github.com/NatLibFi/qvain-api/pkg/models"
+
+	"github.com/wvh/uuid"
+)
+
+const (
+	// DefaultTransferDays is the number of days a dataset transfer stays open if the sender doesn't specify otherwise.
+	DefaultTransferDays = 14
+
+	// MaxTransferDays is the maximum number of days a dataset transfer can stay open.
+	MaxTransferDays = 90
+)
+
+var (
+	errMissingIdentity     = errors.New("missing recipient identity")
+	errInvalidTransferDays = errors.New("invalid number of days")
+)
+
+// Transfer handles requests for handing over a dataset to another user:
+//
+//   GET    transfer          view pending transfer (owner or recipient)
+//   POST   transfer          offer dataset to another user (owner)
+//   DELETE transfer          cancel pending transfer (owner)
+//   POST   transfer/accept   accept transfer and become owner (recipient)
+//   POST   transfer/decline  decline transfer (recipient)
+func (api *DatasetApi) Transfer(w http.ResponseWriter, r *http.Request, user *models.User, id uuid.UUID) {
+	op := ShiftUrlWithTrailing(r)
+
+	switch op {
+	case "":
+		switch r.Method {
+		case http.MethodGet:
+			api.getTransfer(w, r, user.Uid, id)
+		case http.MethodPost:
+			api.createTransfer(w, r, user.Uid, id)
+		case http.MethodDelete:
+			api.decideTransfer(w, r, id, api.db.CancelTransfer(id, user.Uid))
+		case http.MethodOptions:
+			apiWriteOptions(w, "GET, POST, DELETE, OPTIONS")
+		default:
+			jsonError(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		}
+	case "accept":
+		if checkMethod(w, r, http.MethodPost) {
+			api.decideTransfer(w, r, id, api.db.AcceptTransfer(id, user.Uid))
+		}
+	case "decline":
+		if checkMethod(w, r, http.MethodPost) {
+			api.decideTransfer(w, r, id, api.db.DeclineTransfer(id, user.Uid))
+		}
+	default:
+		jsonError(w, "invalid transfer operation", http.StatusNotFound)
+	}
+}
+
+// ListTransfers lists the pending transfers offered to the user.
+func (api *DatasetApi) ListTransfers(w http.ResponseWriter, r *http.Request, user *models.User) {
+	jsondata, err := api.db.ViewIncomingTransfers(user.Uid, api.identity)
+	if err != nil {
+		api.logger.Error().Err(err).Str("uid", user.Uid.String()).Msg("error listing transfers")
+		dbError(w, err)
+		return
+	}
+
+	apiWriteHeaders(w)
+	w.Write(jsondata)
+}
+
+func (api *DatasetApi) getTransfer(w http.ResponseWriter, r *http.Request, uid uuid.UUID, id uuid.UUID) {
+	jsondata, err := api.db.ViewTransfer(id, uid, api.identity)
+	if err != nil {
+		dbError(w, err)
+		return
+	}
+
+	apiWriteHeaders(w)
+	w.Write(jsondata)
+}
+
+// transferRequest is the body of a request to create a transfer.
+type transferRequest struct {
+	// Identity is the external identity of the recipient.
+	Identity string `json:"identity"`
+
+	// Days is the number of days the recipient has to accept the transfer.
+	Days int `json:"days"`
+}
+
+// parseTransferRequest decodes and checks a transfer request, filling in defaults.
+func parseTransferRequest(r *http.Request) (*transferRequest, error) {
+	req := new(transferRequest)
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		return nil, psql.ErrInvalidJson
+	}
+
+	if req.Identity == "" {
+		return nil, errMissingIdentity
+	}
+
+	if req.Days == 0 {
+		req.Days = DefaultTransferDays
+	}
+	if req.Days < 1 || req.Days > MaxTransferDays {
+		return nil, errInvalidTransferDays
+	}
+
+	return req, nil
+}
+
+func (api *DatasetApi) createTransfer(w http.ResponseWriter, r *http.Request, owner uuid.UUID, id uuid.UUID) {
+	if r.Body == nil || r.Body == http.NoBody {
+		jsonError(w, "empty body", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	req, err := parseTransferRequest(r)
+	if err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	recipient, err := api.db.GetUidForIdentity(api.identity, req.Identity)
+	if err == psql.ErrNotFound {
+		jsonError(w, "unknown user", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		dbError(w, err)
+		return
+	}
+
+	expires := time.Now().Add(time.Duration(req.Days) * 24 * time.Hour)
+	err = api.db.CreateTransfer(id, owner, recipient, expires)
+	if err != nil {
+		dbError(w, err)
+		return
+	}
+
+	api.logger.Info().Str("dataset", id.String()).Str("owner", owner.String()).Str("recipient", recipient.String()).Time("expires", expires).Msg("created transfer")
+
+	jsondata, err := api.db.ViewTransfer(id, owner, api.identity)
+	if err != nil {
+		dbError(w, err)
+		return
+	}
+
+	apiWriteHeaders(w)
+	w.WriteHeader(http.StatusCreated)
+	w.Write(jsondata)
+}
+
+// decideTransfer writes the response for cancelling, accepting or declining a transfer.
+func (api *DatasetApi) decideTransfer(w http.ResponseWriter, r *http.Request, id uuid.UUID, err error) {
+	if err != nil {
+		dbError(w, err)
+		return
+	}
+
+	api.logger.Info().Str("dataset", id.String()).Str("op", r.Method+" "+r.RequestURI).Msg("transfer decided")
+
+	apiWriteHeaders(w)
+	w.WriteHeader(http.StatusNoContent)
+}
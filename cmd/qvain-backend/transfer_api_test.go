@@ -0,0 +1,39 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/NatLibFi/qvain-api/internal/psql"
+)
+
+func TestParseTransferRequest(t *testing.T) {
+	var tests = []struct {
+		body string
+		days int
+		err  error
+	}{
+		{body: `{"identity":"someone@fairdataid"}`, days: DefaultTransferDays},
+		{body: `{"identity":"someone@fairdataid","days":1}`, days: 1},
+		{body: `{"identity":"someone@fairdataid","days":90}`, days: 90},
+		{body: `{"identity":"someone@fairdataid","days":91}`, err: errInvalidTransferDays},
+		{body: `{"identity":"someone@fairdataid","days":-1}`, err: errInvalidTransferDays},
+		{body: `{"days":7}`, err: errMissingIdentity},
+		{body: `{"identity":`, err: psql.ErrInvalidJson},
+	}
+
+	for _, test := range tests {
+		t.Run(test.body, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/api/datasets/x/transfer", strings.NewReader(test.body))
+
+			req, err := parseTransferRequest(r)
+			if err != test.err {
+				t.Fatalf("error: expected %v, got %v", test.err, err)
+			}
+			if err == nil && req.Days != test.days {
+				t.Errorf("days: expected %d, got %d", test.days, req.Days)
+			}
+		})
+	}
+}
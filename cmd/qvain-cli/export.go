@@ -3,16 +3,20 @@ package main
 import (
 	"flag"
 	"fmt"
+	"strings"
 
 	//"github.com/NatLibFi/qvain-api/models"
 	"github.com/NatLibFi/qvain-api/internal/psql"
+	"github.com/NatLibFi/qvain-api/pkg/export"
 	"github.com/wvh/uuid"
 )
 
 func runExportDataset(psql *psql.DB, args []string) error {
 	flags := flag.NewFlagSet("export", flag.ExitOnError)
 
-	flags.Usage = usageFor(flags, "export <id>")
+	format := flags.String("format", "qvain", "export format: "+strings.Join(export.Formats(), ", "))
+
+	flags.Usage = usageFor(flags, "export [flags] <id>")
 	if err := flags.Parse(args); err != nil {
 		return err
 	}
@@ -28,7 +32,23 @@ func runExportDataset(psql *psql.DB, args []string) error {
 		return fmt.Errorf("error: invalid id: %s", err)
 	}
 
-	blob, err := psql.ExportAsJson(id)
+	exporter, err := export.Lookup(*format)
+	if err != nil {
+		flags.Usage()
+		return fmt.Errorf("error: %s", err)
+	}
+
+	raw, err := psql.ExportAsJson(id)
+	if err != nil {
+		return err
+	}
+
+	record, err := export.ParseRecord(raw)
+	if err != nil {
+		return err
+	}
+
+	blob, err := exporter.Export(record)
 	if err != nil {
 		return err
 	}
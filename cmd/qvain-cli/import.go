@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/NatLibFi/qvain-api/internal/psql"
+	"github.com/NatLibFi/qvain-api/internal/shared"
+	"github.com/NatLibFi/qvain-api/pkg/metax"
+	"github.com/wvh/uuid/flag"
+)
+
+func runImport(psql *psql.DB, args []string) error {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	var (
+		creator  uuidflag.Uuid
+		schema   string
+		identity string
+		org      string
+	)
+	flags.Var(&creator, "creator", "creator `uuid`")
+	flags.StringVar(&schema, "schema", metax.SchemaIda, "schema for Metax records with unknown data catalog")
+	flags.StringVar(&identity, "identity", "", "external user identity to set as metadata provider")
+	flags.StringVar(&org, "org", "", "organisation to set as metadata provider")
+
+	flags.Usage = usageFor(flags, "import [flags] <ndjson or json file, or - for stdin>")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if flags.NArg() < 1 {
+		flags.Usage()
+		return fmt.Errorf("error: missing some required arguments")
+	}
+
+	if !creator.IsSet() {
+		return fmt.Errorf("error: flag `creator` must be set")
+	}
+
+	var in io.Reader = os.Stdin
+	if flags.Arg(0) != "-" {
+		file, err := os.Open(flags.Arg(0))
+		if err != nil {
+			return fmt.Errorf("error: can't read import file: %s", err)
+		}
+		defer file.Close()
+		in = file
+	}
+
+	report, err := shared.Import(psql, in, creator.Get(), map[string]string{"identity": identity, "org": org}, schema)
+	if err != nil {
+		return err
+	}
+
+	out, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s\n", out)
+
+	if report.Failed > 0 {
+		return fmt.Errorf("imported %d datasets, %d failed", report.Imported, report.Failed)
+	}
+	return nil
+}
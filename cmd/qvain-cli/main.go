@@ -64,7 +64,9 @@ func usageFor(flags *flag.FlagSet, short string) func() {
 func usage() {
 	fmt.Fprintln(os.Stderr, "")
 	fmt.Fprintln(os.Stderr, "  add         add record")
-	fmt.Fprintln(os.Stderr, "  export      export record [json]")
+	fmt.Fprintln(os.Stderr, "  export      export record [qvain, datacite, oai_dc, schemaorg]")
+	fmt.Fprintln(os.Stderr, "  import      import records from ndjson or json array [json]")
+	fmt.Fprintln(os.Stderr, "  purge       delete datasets from trash")
 	fmt.Fprintln(os.Stderr, "")
 	fmt.Fprintln(os.Stderr, "  api:")
 	fmt.Fprintln(os.Stderr, "  view        view datasets by owner [json]")
@@ -93,6 +95,10 @@ func main() {
 		run = runViewDatasetsByOwner
 	case "export":
 		run = runExportDataset
+	case "import":
+		run = runImport
+	case "purge":
+		run = runPurgeTrash
 	case "version":
 		if len(version.CommitTag) > 0 {
 			fmt.Fprintln(os.Stderr, "qvain-cli", version.CommitTag)
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/NatLibFi/qvain-api/internal/psql"
+	"github.com/NatLibFi/qvain-api/internal/shared"
+)
+
+func runPurgeTrash(psql *psql.DB, args []string) error {
+	// default to the backend's retention, so a manual purge doesn't delete what the backend would still keep
+	defaultRetention, err := shared.TrashRetention()
+	if err != nil {
+		return fmt.Errorf("error: invalid APP_TRASH_RETENTION: %s", err)
+	}
+
+	flags := flag.NewFlagSet("purge", flag.ExitOnError)
+	var retention time.Duration
+	flags.DurationVar(&retention, "retention", defaultRetention, "delete datasets that have been in the trash for longer than this `duration`; APP_TRASH_RETENTION sets the default")
+
+	flags.Usage = usageFor(flags, "purge [flags]")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if retention < 0 {
+		return fmt.Errorf("error: flag `retention` can't be negative")
+	}
+
+	purged, err := psql.PurgeTrash(retention)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "purged %d datasets from trash\n", purged)
+
+	return nil
+}
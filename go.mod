@@ -1,5 +1,7 @@
 module github.com/NatLibFi/qvain-api
 
+go 1.16
+
 require (
 	github.com/AndreasBriese/bbloom v0.0.0-20180913140656-343706a395b7 // indirect
 	github.com/buger/jsonparser v0.0.0-20181115193947-bf1c66bbce23
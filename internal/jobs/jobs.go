@@ -0,0 +1,258 @@
+// Package jobs runs background work from a queue in the database.
+//
+// Jobs are claimed with `SELECT ... FOR UPDATE SKIP LOCKED`, so any number of workers in any number of processes can share the queue.
+// Failed jobs are retried with exponential backoff until they run out of attempts and become dead;
+// jobs that were running when a process stopped are picked up again when their lease expires.
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/NatLibFi/qvain-api/internal/psql"
+	"github.com/rs/zerolog"
+	"github.com/wvh/uuid"
+)
+
+const (
+	// DefaultMaxAttempts is the number of times a job is tried before it becomes dead.
+	DefaultMaxAttempts = 5
+
+	// DefaultLease is how long a job may run before another worker may claim it again; it is also the job's context deadline.
+	DefaultLease = 5 * time.Minute
+
+	// PollInterval is the time an idle worker waits before looking for new jobs.
+	PollInterval = 2 * time.Second
+
+	// BaseBackoff and MaxBackoff bound the delay before a failed job is retried.
+	BaseBackoff = 10 * time.Second
+	MaxBackoff  = time.Hour
+)
+
+var (
+	// ErrUnknownKind means no handler has been registered for a job kind.
+	ErrUnknownKind = errors.New("unknown job kind")
+
+	// ErrDead is returned by Wait for jobs that failed permanently.
+	ErrDead = errors.New("job failed")
+)
+
+// Handler runs a job. The result, if not nil, is stored with the job as JSON, also if the job failed.
+type Handler func(ctx context.Context, job *psql.Job) (interface{}, error)
+
+// permanentError marks an error that retrying won't fix.
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+// Permanent wraps an error returned by a handler so the job becomes dead right away instead of being retried.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// IsPermanent tells if an error was wrapped with Permanent.
+func IsPermanent(err error) bool {
+	_, ok := err.(*permanentError)
+	return ok
+}
+
+// Backoff returns the delay before retrying a job that failed for the given (1-based) attempt:
+// BaseBackoff doubled for each earlier attempt up to MaxBackoff, plus up to 10% jitter.
+func Backoff(attempt int) time.Duration {
+	delay := MaxBackoff
+	if attempt < 1 {
+		attempt = 1
+	}
+	if attempt <= 20 {
+		if d := BaseBackoff << uint(attempt-1); d < MaxBackoff {
+			delay = d
+		}
+	}
+	return delay + time.Duration(rand.Int63n(int64(delay/10)+1))
+}
+
+// Queue enqueues jobs and runs them with the registered handlers.
+type Queue struct {
+	db     *psql.DB
+	logger zerolog.Logger
+
+	mu       sync.RWMutex
+	handlers map[string]Handler
+}
+
+// NewQueue sets up a job queue using the given database.
+func NewQueue(db *psql.DB, logger zerolog.Logger) *Queue {
+	return &Queue{
+		db:       db,
+		logger:   logger,
+		handlers: make(map[string]Handler),
+	}
+}
+
+// Register sets the handler for a job kind. Handlers should be registered before starting workers.
+func (q *Queue) Register(kind string, handler Handler) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.handlers[kind] = handler
+}
+
+// kinds returns the job kinds with a registered handler.
+func (q *Queue) kinds() []string {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	kinds := make([]string, 0, len(q.handlers))
+	for kind := range q.handlers {
+		kinds = append(kinds, kind)
+	}
+	return kinds
+}
+
+// handler returns the handler for a job kind.
+func (q *Queue) handler(kind string) (Handler, bool) {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	handler, ok := q.handlers[kind]
+	return handler, ok
+}
+
+// Enqueue adds a job that runs as soon as a worker is free; see EnqueueAt.
+func (q *Queue) Enqueue(kind string, key string, uid *uuid.UUID, payload interface{}) (int64, error) {
+	return q.EnqueueAt(kind, key, uid, payload, time.Now())
+}
+
+// EnqueueAt adds a job that runs at the given time and returns its id.
+// If key is not empty and an unfinished job with the same key exists, the id of that job is returned instead.
+func (q *Queue) EnqueueAt(kind string, key string, uid *uuid.UUID, payload interface{}, at time.Time) (int64, error) {
+	if _, ok := q.handler(kind); !ok {
+		return 0, ErrUnknownKind
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return 0, err
+	}
+
+	return q.db.EnqueueJob(kind, key, uid, data, at, DefaultMaxAttempts)
+}
+
+// Wait polls a job until it is done or dead, or the context ends. It returns ErrDead for dead jobs.
+func (q *Queue) Wait(ctx context.Context, id int64) (*psql.Job, error) {
+	ticker := time.NewTicker(200 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		job, err := q.db.GetJob(id)
+		if err != nil {
+			return nil, err
+		}
+		switch job.State {
+		case psql.JobDone:
+			return job, nil
+		case psql.JobDead:
+			return job, ErrDead
+		}
+
+		select {
+		case <-ctx.Done():
+			return job, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Start spawns the given number of workers that run jobs until the context ends.
+// NOTE: This function returns immediately.
+func (q *Queue) Start(ctx context.Context, workers int) {
+	q.logger.Info().Int("workers", workers).Strs("kinds", q.kinds()).Msg("starting job workers")
+	for i := 0; i < workers; i++ {
+		go q.work(ctx)
+	}
+}
+
+// work runs jobs until the context ends, waiting PollInterval whenever the queue is empty.
+func (q *Queue) work(ctx context.Context) {
+	for {
+		ran, err := q.RunOne(ctx)
+		if err != nil {
+			q.logger.Error().Err(err).Msg("can't claim job")
+		}
+		if ran && err == nil {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(PollInterval):
+		}
+	}
+}
+
+// RunOne claims and runs one job that is due, if any, and tells if it did.
+// The error is about the queue, not about the job; job errors are stored with the job.
+func (q *Queue) RunOne(ctx context.Context) (bool, error) {
+	job, err := q.db.ClaimJob(q.kinds(), DefaultLease)
+	if err == psql.ErrNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	logger := q.logger.With().Int64("job", job.Id).Str("kind", job.Kind).Int("attempt", job.Attempts).Logger()
+
+	result, err := q.run(ctx, job)
+
+	var data []byte
+	if result != nil {
+		var merr error
+		if data, merr = json.Marshal(result); merr != nil {
+			err, data = Permanent(fmt.Errorf("can't serialise result: %s", merr)), nil
+		}
+	}
+	if err == nil {
+		logger.Debug().Msg("job done")
+		return true, q.db.CompleteJob(job.Id, data)
+	}
+
+	dead := IsPermanent(err) || job.Attempts >= job.MaxAttempts
+	retryAt := time.Now().Add(Backoff(job.Attempts))
+	if dead {
+		logger.Warn().Err(err).Msg("job failed, giving up")
+	} else {
+		logger.Info().Err(err).Time("retry", retryAt).Msg("job failed, will retry")
+	}
+	return true, q.db.FailJob(job.Id, err.Error(), data, retryAt, dead)
+}
+
+// run calls the handler for a job, turning panics into errors.
+func (q *Queue) run(ctx context.Context, job *psql.Job) (result interface{}, err error) {
+	handler, ok := q.handler(job.Kind)
+	if !ok {
+		return nil, Permanent(ErrUnknownKind)
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			result, err = nil, fmt.Errorf("panic: %v", r)
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(ctx, DefaultLease)
+	defer cancel()
+
+	return handler(ctx, job)
+}
@@ -0,0 +1,47 @@
+package jobs
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	var tests = []struct {
+		attempt int
+		min     time.Duration
+	}{
+		{attempt: 0, min: BaseBackoff},
+		{attempt: 1, min: BaseBackoff},
+		{attempt: 2, min: 2 * BaseBackoff},
+		{attempt: 3, min: 4 * BaseBackoff},
+		{attempt: 20, min: MaxBackoff},
+		{attempt: 100, min: MaxBackoff},
+	}
+
+	for _, test := range tests {
+		for i := 0; i < 10; i++ {
+			delay := Backoff(test.attempt)
+			if delay < test.min || delay > test.min+test.min/10 {
+				t.Errorf("attempt %d: expected delay between %v and %v, got %v", test.attempt, test.min, test.min+test.min/10, delay)
+			}
+		}
+	}
+}
+
+func TestPermanent(t *testing.T) {
+	err := errors.New("refused")
+
+	if IsPermanent(err) {
+		t.Error("plain error should not be permanent")
+	}
+	if !IsPermanent(Permanent(err)) {
+		t.Error("wrapped error should be permanent")
+	}
+	if Permanent(err).Error() != err.Error() {
+		t.Errorf("expected message %q, got %q", err.Error(), Permanent(err).Error())
+	}
+	if Permanent(nil) != nil {
+		t.Error("expected nil for nil error")
+	}
+}
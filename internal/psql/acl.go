@@ -0,0 +1,221 @@
+package psql
+
+import (
+	"encoding/json"
+
+	"github.com/wvh/uuid"
+)
+
+// Role is the level of access a user has to a dataset. Higher roles include the rights of lower ones.
+type Role int
+
+// Dataset roles; see table `dataset_acl`.
+const (
+	// RoleNone means no access.
+	RoleNone Role = iota
+
+	// RoleViewer can read the dataset.
+	RoleViewer
+
+	// RoleEditor can also change the dataset.
+	RoleEditor
+
+	// RoleOwner can also publish and delete the dataset, and manage its collaborators.
+	// The user in the dataset's owner field always has this role.
+	RoleOwner
+)
+
+var roleNames = [...]string{"", "viewer", "editor", "owner"}
+
+// String returns the name of the role as stored in the database.
+func (role Role) String() string {
+	if role < RoleNone || role > RoleOwner {
+		return ""
+	}
+	return roleNames[role]
+}
+
+// ParseRole returns the role with the given name, or ErrInvalidRole.
+func ParseRole(name string) (Role, error) {
+	for i, roleName := range roleNames {
+		if i > 0 && roleName == name {
+			return Role(i), nil
+		}
+	}
+	return RoleNone, ErrInvalidRole
+}
+
+// getRole returns the role a user has for a dataset; the dataset is looked up in the trash bin if trashed is set.
+// Members of the IDA project owning a dataset are editors, unless the dataset's ACL gives them more rights.
+func (tx *Tx) getRole(id uuid.UUID, uid uuid.UUID, trashed bool) (Role, error) {
+	var (
+		isOwner  bool
+		isMember bool
+		name     *string
+	)
+
+	err := tx.QueryRow(`
+		SELECT coalesce(owner = $2, false),
+			coalesce(project IN (SELECT project FROM project_members WHERE uid = $2), false),
+			(SELECT role FROM dataset_acl WHERE dataset = $1 AND uid = $2)
+		FROM datasets
+		WHERE id = $1 AND (deleted IS NOT NULL) = $3
+	`, id.Array(), uid.Array(), trashed).Scan(&isOwner, &isMember, &name)
+	if err != nil {
+		return RoleNone, handleError(err)
+	}
+
+	if isOwner {
+		return RoleOwner, nil
+	}
+
+	role := RoleNone
+	if name != nil {
+		role, err = ParseRole(*name)
+		if err != nil {
+			return RoleNone, err
+		}
+	}
+
+	if isMember && role < RoleEditor {
+		role = RoleEditor
+	}
+
+	return role, nil
+}
+
+// CheckAccess returns an error if the user doesn't have at least the given role for the dataset.
+// It returns ErrNotFound for datasets that don't exist or are in the trash bin, ErrNotOwner if the user has no access at all,
+// and ErrNoAccess if the user's role is too low.
+func (tx *Tx) CheckAccess(id uuid.UUID, uid uuid.UUID, role Role) error {
+	return tx.checkRole(id, uid, role, false)
+}
+
+func (tx *Tx) checkRole(id uuid.UUID, uid uuid.UUID, role Role, trashed bool) error {
+	has, err := tx.getRole(id, uid, trashed)
+	if err != nil {
+		return err
+	}
+
+	if has == RoleNone {
+		return ErrNotOwner
+	}
+
+	if has < role {
+		return ErrNoAccess
+	}
+
+	return nil
+}
+
+// CheckAccess calls tx.CheckAccess to check if the dataset exists and the user has at least the given role.
+func (db *DB) CheckAccess(id uuid.UUID, uid uuid.UUID, role Role) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	return tx.CheckAccess(id, uid, role)
+}
+
+// ViewCollaborators returns a (JSON) array with the users that have access to a dataset, starting with the owner.
+// Any user with access to the dataset can see the list.
+func (db *DB) ViewCollaborators(id uuid.UUID, uid uuid.UUID, svc string) (json.RawMessage, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return apiEmptyList, err
+	}
+	defer tx.Rollback()
+
+	err = tx.CheckAccess(id, uid, RoleViewer)
+	if err != nil {
+		return apiEmptyList, err
+	}
+
+	var result json.RawMessage
+	err = tx.QueryRow(`
+		SELECT json_agg(result ORDER BY "primary" DESC, added) "collaborators"
+		FROM (
+			SELECT (SELECT extids->$2 FROM identities WHERE uid = owner) AS identity, 'owner' AS role, created AS added, true AS "primary"
+			FROM datasets WHERE id = $1
+			UNION ALL
+			SELECT (SELECT extids->$2 FROM identities WHERE identities.uid = dataset_acl.uid), role, added, false
+			FROM dataset_acl WHERE dataset = $1
+		) result
+	`, id.Array(), svc).Scan(&result)
+	if err != nil {
+		return apiEmptyList, handleError(err)
+	}
+
+	return result, nil
+}
+
+// SetCollaborator gives a user a role for a dataset, or changes the role if the user is a collaborator already.
+// Only owners can manage collaborators; the dataset's primary owner can't be given another role.
+func (db *DB) SetCollaborator(id uuid.UUID, owner uuid.UUID, collaborator uuid.UUID, role Role) error {
+	if role <= RoleNone || role > RoleOwner {
+		return ErrInvalidRole
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.CheckAccess(id, owner, RoleOwner)
+	if err != nil {
+		return err
+	}
+
+	var isPrimary bool
+	err = tx.QueryRow("SELECT owner = $2 FROM datasets WHERE id = $1", id.Array(), collaborator.Array()).Scan(&isPrimary)
+	if err != nil {
+		return handleError(err)
+	}
+	if isPrimary {
+		return ErrIsOwner
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO dataset_acl(dataset, uid, role, added_by) VALUES($1, $2, $3, $4)
+		ON CONFLICT (dataset, uid) DO UPDATE SET role = excluded.role, added_by = excluded.added_by
+	`, id.Array(), collaborator.Array(), role.String(), owner.Array())
+	if err != nil {
+		return handleError(err)
+	}
+
+	return tx.Commit()
+}
+
+// RemoveCollaborator takes away a user's access to a dataset.
+// Owners can remove any collaborator; other collaborators can only remove themselves.
+func (db *DB) RemoveCollaborator(id uuid.UUID, uid uuid.UUID, collaborator uuid.UUID) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if uid == collaborator {
+		err = tx.CheckAccess(id, uid, RoleViewer)
+	} else {
+		err = tx.CheckAccess(id, uid, RoleOwner)
+	}
+	if err != nil {
+		return err
+	}
+
+	ct, err := tx.Exec("DELETE FROM dataset_acl WHERE dataset = $1 AND uid = $2", id.Array(), collaborator.Array())
+	if err != nil {
+		return handleError(err)
+	}
+
+	if ct.RowsAffected() != 1 {
+		// the primary owner isn't in the acl table
+		return ErrNotFound
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,40 @@
+package psql
+
+import (
+	"testing"
+)
+
+func TestParseRole(t *testing.T) {
+	var tests = []struct {
+		name string
+		role Role
+		err  error
+	}{
+		{name: "viewer", role: RoleViewer},
+		{name: "editor", role: RoleEditor},
+		{name: "owner", role: RoleOwner},
+		{name: "", err: ErrInvalidRole},
+		{name: "Owner", err: ErrInvalidRole},
+		{name: "admin", err: ErrInvalidRole},
+	}
+
+	for _, test := range tests {
+		role, err := ParseRole(test.name)
+		if err != test.err {
+			t.Errorf("%q: expected error %v, got %v", test.name, test.err, err)
+			continue
+		}
+		if role != test.role {
+			t.Errorf("%q: expected role %v, got %v", test.name, test.role, role)
+		}
+		if err == nil && role.String() != test.name {
+			t.Errorf("%q: round trip gave %q", test.name, role.String())
+		}
+	}
+}
+
+func TestRoleOrder(t *testing.T) {
+	if !(RoleNone < RoleViewer && RoleViewer < RoleEditor && RoleEditor < RoleOwner) {
+		t.Error("roles should be ordered from least to most access")
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"time"
 
 	"github.com/NatLibFi/qvain-api/pkg/models"
+	"github.com/jackc/pgx"
 	"github.com/wvh/uuid"
 )
 
@@ -37,15 +38,119 @@ func (b *BatchManager) Create(dataset *models.Dataset) error {
 
 func (b *BatchManager) CreateWithMetadata(dataset *models.Dataset) error {
 	dataset.Synced = b.at
-	return b.tx.createWithMetadata(dataset)
+	return b.tx.createWithMetadata(dataset, b.triggerUid)
 }
 
 func (b *BatchManager) Update(id uuid.UUID, blob []byte) error {
-	return b.tx.updateByService(id, blob)
+	return b.tx.updateByService(id, blob, b.triggerUid)
 }
 
-func (b *BatchManager) Upsert(data *models.Dataset) error {
-	return ErrNotImplemented
+// Upsert creates a dataset from an external service, or updates it following the rules of Sync if it exists already.
+// An existing dataset is found by id or, if the incoming record lost its Qvain id, by the `identifier` in the blob,
+// so repeated or interrupted syncs converge on the same record instead of creating duplicates.
+// Datasets in the trash bin are not touched and reported as SyncTrashed.
+// New datasets without an id get a new one; the id of the dataset is set to the stored record.
+//
+// A failed upsert is rolled back on its own, so the batch can go on with the next dataset;
+// if that isn't possible, ErrBatchAborted is returned and the batch can only be rolled back.
+func (b *BatchManager) Upsert(dataset *models.Dataset, remoteModified time.Time) (SyncResult, error) {
+	if _, err := b.tx.Exec("SAVEPOINT upsert"); err != nil {
+		return SyncUpdated, ErrBatchAborted
+	}
+
+	result, err := b.upsert(dataset, remoteModified)
+	if err != nil {
+		if _, rerr := b.tx.Exec("ROLLBACK TO SAVEPOINT upsert"); rerr != nil {
+			return result, ErrBatchAborted
+		}
+		return result, err
+	}
+
+	if _, err := b.tx.Exec("RELEASE SAVEPOINT upsert"); err != nil {
+		return result, ErrBatchAborted
+	}
+	return result, nil
+}
+
+func (b *BatchManager) upsert(dataset *models.Dataset, remoteModified time.Time) (SyncResult, error) {
+	var (
+		existing uuid.UUID
+		trashed  bool
+	)
+	err := b.tx.QueryRow(`
+		SELECT id, deleted IS NOT NULL FROM datasets
+		WHERE id = $1 OR (family = $2 AND blob->>'identifier' = $3::jsonb->>'identifier')
+		ORDER BY id = $1 DESC
+		LIMIT 1
+	`, dataset.Id.Array(), dataset.Family(), string(dataset.Blob())).Scan(existing.Array(), &trashed)
+	if err == nil {
+		dataset.Id = existing
+		if trashed {
+			return SyncTrashed, nil
+		}
+		return b.tx.sync(existing, dataset.Blob(), remoteModified, b.triggerUid)
+	}
+	if err != pgx.ErrNoRows {
+		return SyncUpdated, handleError(err)
+	}
+
+	if dataset.Id == (uuid.UUID{}) {
+		dataset.Id, err = uuid.NewUUID()
+		if err != nil {
+			return SyncCreated, err
+		}
+	}
+	dataset.Synced = b.at
+
+	if err = b.tx.createWithMetadata(dataset, b.triggerUid); err != nil {
+		return SyncCreated, handleError(err)
+	}
+	return SyncCreated, nil
+}
+
+// MarkUpstream records the state of the user's datasets in an external service after a sync.
+//
+// Datasets with one of the deprecated identifiers are marked deprecated, the other seen ones are cleared.
+// If full is true, the seen identifiers are all the user has upstream under the metadata provider identity extid,
+// and the user's other published datasets provided by that identity are marked as removed upstream;
+// datasets published under another identity, for instance before a transfer, are not listed by such a sync and are left alone.
+// It returns the number of datasets newly marked as removed.
+func (b *BatchManager) MarkUpstream(extid string, seen []string, deprecated []string, full bool) (int, error) {
+	if b.triggerUid == nil {
+		return 0, nil
+	}
+	if seen == nil {
+		seen = []string{}
+	}
+	if deprecated == nil {
+		deprecated = []string{}
+	}
+
+	_, err := b.tx.Exec(`
+		UPDATE datasets SET
+			removed_upstream = NULL,
+			deprecated = CASE WHEN blob->>'identifier' = ANY($2) THEN coalesce(deprecated, $3) END
+		WHERE blob->>'identifier' = ANY($1) AND (removed_upstream IS NOT NULL OR deprecated IS NOT NULL OR blob->>'identifier' = ANY($2))
+	`, seen, deprecated, b.at)
+	if err != nil {
+		return 0, handleError(err)
+	}
+
+	if !full {
+		return 0, nil
+	}
+
+	tag, err := b.tx.Exec(`
+		UPDATE datasets SET removed_upstream = $3
+		WHERE owner = $1 AND blob->>'metadata_provider_user' = $4
+			AND blob->>'identifier' IS NOT NULL AND NOT (blob->>'identifier' = ANY($2))
+			AND removed_upstream IS NULL AND deleted IS NULL
+	`, b.triggerUid.Array(), seen, b.at, extid)
+	if err != nil {
+		return 0, handleError(err)
+	}
+
+	return int(tag.RowsAffected()), nil
 }
 
 func (b *BatchManager) writeStamp() error {
@@ -79,10 +184,61 @@ func (db *DB) GetLastSync(uid uuid.UUID) (time.Time, error) {
 
 func (tx *Tx) getLastSync(uid uuid.UUID) (time.Time, error) {
 	var last time.Time
-	err := tx.QueryRow("SELECT ts FROM lastsync WHERE uid = $1", uid.Array()).Scan(&last)
+	err := tx.QueryRow("SELECT ts FROM lastsync WHERE uid = $1 AND ts IS NOT NULL", uid.Array()).Scan(&last)
 	if err != nil {
 		return time.Time{}, handleError(err)
 	}
 
 	return last, nil
 }
+
+// SetSyncResult records the outcome of the last sync attempt for a user.
+// It doesn't change the time of the last successful sync, which is written when a batch is committed.
+func (db *DB) SetSyncResult(uid uuid.UUID, success bool, msg string, duration time.Duration) error {
+	_, err := db.pool.Exec(`
+		INSERT INTO lastsync(uid, success, msg, duration) VALUES($1, $2, $3, make_interval(secs => $4::double precision))
+		ON CONFLICT (uid) DO UPDATE SET success = EXCLUDED.success, msg = EXCLUDED.msg, duration = EXCLUDED.duration
+	`, uid.Array(), success, msg, duration.Seconds())
+	return handleError(err)
+}
+
+// SyncUser is a user whose datasets can be synced from an external service.
+type SyncUser struct {
+	Uid      uuid.UUID
+	Identity string
+
+	// Last is the time of the last successful sync, or the zero time if there was none.
+	Last time.Time
+}
+
+// SyncCandidates returns the login users with an identity for the given service who haven't been synced successfully since the given time,
+// least recently synced first.
+func (db *DB) SyncCandidates(svc string, before time.Time) ([]SyncUser, error) {
+	rows, err := db.pool.Query(`
+		SELECT i.uid, i.extids->>$1, l.ts
+		FROM identities i LEFT JOIN lastsync l ON l.uid = i.uid
+		WHERE i.login AND i.extids->>$1 IS NOT NULL AND (l.ts IS NULL OR l.ts < $2)
+		ORDER BY l.ts NULLS FIRST
+	`, svc, before)
+	if err != nil {
+		return nil, handleError(err)
+	}
+	defer rows.Close()
+
+	var users []SyncUser
+	for rows.Next() {
+		var (
+			user SyncUser
+			last *time.Time
+		)
+		if err := rows.Scan(user.Uid.Array(), &user.Identity, &last); err != nil {
+			return nil, handleError(err)
+		}
+		if last != nil {
+			user.Last = *last
+		}
+		users = append(users, user)
+	}
+
+	return users, handleError(rows.Err())
+}
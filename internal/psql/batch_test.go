@@ -0,0 +1,234 @@
+package psql
+
+import (
+	"testing"
+	"time"
+
+	"github.com/NatLibFi/qvain-api/pkg/models"
+	"github.com/wvh/uuid"
+)
+
+// TestUpsert checks that syncing the same Metax record again, with or without its Qvain id, updates one dataset instead of creating duplicates.
+func TestUpsert(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode")
+	}
+
+	db, err := NewPoolServiceFromEnv()
+	if err != nil {
+		t.Fatal("psql:", err)
+	}
+
+	owner := uuid.MustNewUUID()
+	identifier := "urn:nbn:fi:att:" + uuid.MustNewUUID().String()
+
+	// upsert syncs a record with the given Qvain id, or none if id is nil, in its own batch.
+	upsert := func(t *testing.T, id *uuid.UUID, title string) (uuid.UUID, SyncResult) {
+		t.Helper()
+
+		dataset := &models.Dataset{Creator: owner, Owner: owner, Created: time.Now(), Published: true}
+		if id != nil {
+			dataset.Id = *id
+		}
+		dataset.SetData(2, "metax-ida", []byte(`{"identifier":"`+identifier+`","research_dataset":{"title":{"en":"`+title+`"}}}`))
+
+		batch, err := db.NewBatchForUser(owner)
+		if err != nil {
+			t.Fatal("db.NewBatchForUser():", err)
+		}
+		defer batch.Rollback()
+
+		result, err := batch.Upsert(dataset, time.Now())
+		if err != nil {
+			t.Fatal("batch.Upsert():", err)
+		}
+		if err := batch.Commit(); err != nil {
+			t.Fatal("batch.Commit():", err)
+		}
+		return dataset.Id, result
+	}
+
+	var id uuid.UUID
+	t.Run("create", func(t *testing.T) {
+		var result SyncResult
+		id, result = upsert(t, nil, "first")
+		if result != SyncCreated {
+			t.Fatalf("expected result %v, got %v", SyncCreated, result)
+		}
+		if id == (uuid.UUID{}) {
+			t.Fatal("expected new dataset to get an id")
+		}
+	})
+	defer db.Delete(id, nil)
+
+	t.Run("repeat", func(t *testing.T) {
+		got, result := upsert(t, &id, "second")
+		if result != SyncUpdated {
+			t.Errorf("expected result %v, got %v", SyncUpdated, result)
+		}
+		if got != id {
+			t.Errorf("expected id %s, got %s", id, got)
+		}
+	})
+
+	t.Run("lost record id", func(t *testing.T) {
+		got, result := upsert(t, nil, "third")
+		if result != SyncUpdated {
+			t.Errorf("expected result %v, got %v", SyncUpdated, result)
+		}
+		if got != id {
+			t.Errorf("expected match by identifier %s, got %s", id, got)
+		}
+
+		other := uuid.MustNewUUID()
+		got, result = upsert(t, &other, "fourth")
+		if result != SyncUpdated {
+			t.Errorf("expected result %v, got %v", SyncUpdated, result)
+		}
+		if got != id {
+			t.Errorf("expected match by identifier %s, got %s", id, got)
+		}
+	})
+
+	t.Run("trashed", func(t *testing.T) {
+		if err := db.Trash(id, owner); err != nil {
+			t.Fatal("db.Trash():", err)
+		}
+
+		got, result := upsert(t, &id, "fifth")
+		if result != SyncTrashed {
+			t.Errorf("expected result %v, got %v", SyncTrashed, result)
+		}
+		if got != id {
+			t.Errorf("expected id %s, got %s", id, got)
+		}
+	})
+
+	var title string
+	err = db.pool.QueryRow(`SELECT blob->'research_dataset'->'title'->>'en' FROM datasets WHERE id = $1`, id.Array()).Scan(&title)
+	if err != nil {
+		t.Fatal("select:", err)
+	}
+	if title != "fourth" {
+		t.Errorf("expected title %q, got %q", "fourth", title)
+	}
+
+	var count int
+	err = db.pool.QueryRow(`SELECT count(*) FROM datasets WHERE blob->>'identifier' = $1`, identifier).Scan(&count)
+	if err != nil {
+		t.Fatal("select:", err)
+	}
+	if count != 1 {
+		t.Errorf("expected one dataset for identifier, got %d", count)
+	}
+}
+
+// TestMarkUpstreamOtherProvider checks that a full sync only marks datasets provided by the synced identity as removed upstream.
+func TestMarkUpstreamOtherProvider(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode")
+	}
+
+	db, err := NewPoolServiceFromEnv()
+	if err != nil {
+		t.Fatal("psql:", err)
+	}
+
+	owner := uuid.MustNewUUID()
+
+	create := func(provider string) uuid.UUID {
+		dataset, err := models.NewDataset(owner)
+		if err != nil {
+			t.Fatal("models.NewDataset():", err)
+		}
+		dataset.SetData(2, "metax-ida", []byte(`{"identifier":"urn:nbn:fi:att:`+dataset.Id.String()+`","metadata_provider_user":"`+provider+`"}`))
+		if err := db.Create(dataset); err != nil {
+			t.Fatal("db.Create():", err)
+		}
+		return dataset.Id
+	}
+
+	mine, previous := create("me@example.com"), create("previous-owner@example.com")
+	defer db.Delete(mine, nil)
+	defer db.Delete(previous, nil)
+
+	batch, err := db.NewBatchForUser(owner)
+	if err != nil {
+		t.Fatal("db.NewBatchForUser():", err)
+	}
+	defer batch.Rollback()
+
+	removed, err := batch.MarkUpstream("me@example.com", nil, nil, true)
+	if err != nil {
+		t.Fatal("batch.MarkUpstream():", err)
+	}
+	if err := batch.Commit(); err != nil {
+		t.Fatal("batch.Commit():", err)
+	}
+	if removed != 1 {
+		t.Errorf("expected 1 dataset marked removed, got %d", removed)
+	}
+
+	for id, expected := range map[uuid.UUID]bool{mine: true, previous: false} {
+		var marked bool
+		err = db.pool.QueryRow(`SELECT removed_upstream IS NOT NULL FROM datasets WHERE id = $1`, id.Array()).Scan(&marked)
+		if err != nil {
+			t.Fatal("select:", err)
+		}
+		if marked != expected {
+			t.Errorf("dataset %s: expected removed upstream %v, got %v", id, expected, marked)
+		}
+	}
+}
+
+// TestUpsertAfterFailure checks that a dataset failing to store doesn't abort the rest of the batch.
+func TestUpsertAfterFailure(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode")
+	}
+
+	db, err := NewPoolServiceFromEnv()
+	if err != nil {
+		t.Fatal("psql:", err)
+	}
+
+	owner := uuid.MustNewUUID()
+	identifier := "urn:nbn:fi:att:" + uuid.MustNewUUID().String()
+
+	// a dataset of another family with the same identifier makes the upsert clash with the unique index
+	other, err := models.NewDataset(owner)
+	if err != nil {
+		t.Fatal("models.NewDataset():", err)
+	}
+	other.SetData(1, "open test dataset", []byte(`{"identifier":"`+identifier+`"}`))
+	if err := db.Create(other); err != nil {
+		t.Fatal("db.Create():", err)
+	}
+	defer db.Delete(other.Id, nil)
+
+	batch, err := db.NewBatchForUser(owner)
+	if err != nil {
+		t.Fatal("db.NewBatchForUser():", err)
+	}
+	defer batch.Rollback()
+
+	clash := &models.Dataset{Creator: owner, Owner: owner, Created: time.Now()}
+	clash.SetData(2, "metax-ida", []byte(`{"identifier":"`+identifier+`"}`))
+	if _, err := batch.Upsert(clash, time.Now()); err != ErrExists {
+		t.Fatalf("expected %v, got %v", ErrExists, err)
+	}
+
+	good := &models.Dataset{Creator: owner, Owner: owner, Created: time.Now()}
+	good.SetData(2, "metax-ida", []byte(`{"identifier":"urn:nbn:fi:att:`+uuid.MustNewUUID().String()+`"}`))
+	if _, err := batch.Upsert(good, time.Now()); err != nil {
+		t.Fatal("batch.Upsert() after failure:", err)
+	}
+	if err := batch.Commit(); err != nil {
+		t.Fatal("batch.Commit():", err)
+	}
+	defer db.Delete(good.Id, nil)
+
+	if _, err := db.Get(good.Id); err != nil {
+		t.Error("expected dataset stored after failed one:", err)
+	}
+}
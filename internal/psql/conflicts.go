@@ -0,0 +1,214 @@
+package psql
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/wvh/uuid"
+)
+
+// SyncResult tells what happened to a dataset during sync.
+type SyncResult int
+
+// Sync results returned by BatchManager.Sync and BatchManager.Upsert.
+const (
+	// SyncUpdated means the dataset was replaced by the incoming record.
+	SyncUpdated SyncResult = iota
+
+	// SyncSkipped means the dataset was edited locally and Metax has no newer version, so the local copy was kept.
+	SyncSkipped
+
+	// SyncConflict means both the local copy and the record in Metax changed; the local copy was kept and the incoming record stored as conflict.
+	SyncConflict
+
+	// SyncCreated means the incoming record was not known locally and was stored as new dataset.
+	SyncCreated
+
+	// SyncTrashed means the matching local dataset is in the trash bin; it was left untouched.
+	SyncTrashed
+)
+
+// Conflict resolutions; see table `sync_conflicts`.
+const (
+	ResolveMine   = "mine"
+	ResolveTheirs = "theirs"
+	ResolveMerge  = "merge"
+)
+
+// Conflict is an unresolved difference between a dataset and its incoming record from Metax.
+type Conflict struct {
+	Id             int64
+	Dataset        uuid.UUID
+	Detected       time.Time
+	RemoteModified *time.Time
+
+	// Mine and Theirs are the `research_dataset` of the local copy and of the incoming record.
+	Mine   json.RawMessage
+	Theirs json.RawMessage
+}
+
+// Sync updates a dataset with a record from Metax unless it has been edited locally since the last sync.
+// If the record in Metax is newer than the last sync as well, the record is stored as conflict for the user to resolve.
+func (b *BatchManager) Sync(id uuid.UUID, blob []byte, remoteModified time.Time) (SyncResult, error) {
+	return b.tx.sync(id, blob, remoteModified, b.triggerUid)
+}
+
+func (tx *Tx) sync(id uuid.UUID, blob []byte, remoteModified time.Time, by *uuid.UUID) (SyncResult, error) {
+	var (
+		edited bool
+		synced *time.Time
+		seq    int
+	)
+	err := tx.QueryRow(`SELECT coalesce(modified > coalesce(synced, created), false), synced, seq FROM datasets WHERE id = $1 FOR UPDATE`, id.Array()).Scan(&edited, &synced, &seq)
+	if err != nil {
+		return SyncUpdated, handleError(err)
+	}
+
+	if !edited {
+		return SyncUpdated, tx.updateByService(id, blob, by)
+	}
+
+	// edited locally; only a newer version in Metax is a conflict
+	if remoteModified.IsZero() || (synced != nil && !remoteModified.After(*synced)) {
+		return SyncSkipped, nil
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO sync_conflicts(dataset, blob, remote_modified, local_seq) VALUES($1, $2, $3, $4)
+		ON CONFLICT (dataset) WHERE resolved IS NULL
+		DO UPDATE SET blob = EXCLUDED.blob, remote_modified = EXCLUDED.remote_modified, local_seq = EXCLUDED.local_seq, detected = now()
+	`, id.Array(), blob, remoteModified, seq)
+	if err != nil {
+		return SyncConflict, handleError(err)
+	}
+
+	return SyncConflict, nil
+}
+
+// ViewConflict returns the unresolved sync conflict for a dataset, or ErrNotFound if there is none.
+func (db *DB) ViewConflict(id uuid.UUID, uid uuid.UUID) (*Conflict, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	err = tx.CheckAccess(id, uid, RoleViewer)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		conflict     = &Conflict{Dataset: id}
+		mine, theirs []byte
+	)
+	err = tx.QueryRow(`
+		SELECT c.id, c.detected, c.remote_modified, coalesce(d.blob->'research_dataset', 'null'), coalesce(c.blob->'research_dataset', 'null')
+		FROM sync_conflicts c JOIN datasets d ON d.id = c.dataset
+		WHERE c.dataset = $1 AND c.resolved IS NULL
+	`, id.Array()).Scan(&conflict.Id, &conflict.Detected, &conflict.RemoteModified, &mine, &theirs)
+	if err != nil {
+		return nil, handleError(err)
+	}
+	conflict.Mine, conflict.Theirs = mine, theirs
+
+	return conflict, nil
+}
+
+// ViewConflicts returns a (JSON) array with the datasets of a user that have unresolved sync conflicts.
+func (db *DB) ViewConflicts(uid uuid.UUID) (json.RawMessage, error) {
+	var conflicts json.RawMessage
+
+	err := db.pool.QueryRow(`
+		SELECT coalesce(json_agg(result ORDER BY detected DESC), '[]') "conflicts"
+		FROM (
+			SELECT c.dataset AS id, c.detected, c.remote_modified,
+				d.blob#>'{identifier}' identifier,
+				d.blob#>'{research_dataset,title}' title
+			FROM sync_conflicts c JOIN datasets d ON d.id = c.dataset
+			WHERE c.resolved IS NULL AND d.deleted IS NULL AND (
+				d.owner = $1 OR
+				d.id IN (SELECT dataset FROM dataset_acl WHERE uid = $1) OR
+				d.project IN (SELECT project FROM project_members WHERE uid = $1)
+			)
+		) result
+	`, uid.Array()).Scan(&conflicts)
+	if err != nil {
+		return nil, handleError(err)
+	}
+
+	return conflicts, nil
+}
+
+// ResolveConflict resolves the sync conflict of a dataset and returns the dataset's new sequence number.
+//
+// With ResolveMine the local copy is kept as edited, so it can be published over the record in Metax;
+// with ResolveTheirs the local copy is replaced by the incoming record;
+// with ResolveMerge the local copy becomes the incoming record with `research_dataset` replaced by the given merged value.
+func (db *DB) ResolveConflict(id uuid.UUID, uid uuid.UUID, resolution string, merged json.RawMessage) (int, error) {
+	switch resolution {
+	case ResolveMine, ResolveTheirs:
+	case ResolveMerge:
+		if len(merged) == 0 || !json.Valid(merged) {
+			return 0, ErrInvalidJson
+		}
+	default:
+		return 0, ErrInvalidResolution
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	err = tx.CheckAccess(id, uid, RoleEditor)
+	if err != nil {
+		return 0, err
+	}
+
+	var (
+		cid  int64
+		blob []byte
+	)
+	err = tx.QueryRow("SELECT id, blob FROM sync_conflicts WHERE dataset = $1 AND resolved IS NULL FOR UPDATE", id.Array()).Scan(&cid, &blob)
+	if err != nil {
+		return 0, handleError(err)
+	}
+
+	switch resolution {
+	case ResolveMine:
+		// the incoming record has been seen; keep the local copy newer than it so the next sync leaves it alone
+		_, err = tx.Exec("UPDATE datasets SET synced = now(), modified = clock_timestamp() WHERE id = $1", id.Array())
+	case ResolveTheirs:
+		err = tx.updateByService(id, blob, &uid)
+	case ResolveMerge:
+		_, err = tx.Exec(`
+			UPDATE datasets SET blob = $2::jsonb || jsonb_build_object('research_dataset', $3::jsonb),
+				synced = now(), modified = clock_timestamp(), seq = seq + 1
+			WHERE id = $1
+		`, id.Array(), blob, string(merged))
+		if err == nil {
+			err = tx.writeRevision(id, &uid, RevisionSourceUser)
+		}
+		if err == nil {
+			err = tx.validate(id)
+		}
+	}
+	if err != nil {
+		return 0, handleError(err)
+	}
+
+	_, err = tx.Exec("UPDATE sync_conflicts SET resolved = now(), resolution = $2, resolved_by = $3 WHERE id = $1", cid, resolution, uid.Array())
+	if err != nil {
+		return 0, handleError(err)
+	}
+
+	var seq int
+	err = tx.QueryRow("SELECT seq FROM datasets WHERE id = $1", id.Array()).Scan(&seq)
+	if err != nil {
+		return 0, handleError(err)
+	}
+
+	return seq, tx.Commit()
+}
@@ -2,10 +2,11 @@ package psql
 
 import (
 	//"errors"
+	"encoding/json"
 
+	"github.com/NatLibFi/qvain-api/pkg/mergepatch"
 	"github.com/NatLibFi/qvain-api/pkg/models"
 	"github.com/wvh/uuid"
-	"log"
 	"time"
 )
 
@@ -38,7 +39,7 @@ func (db *DB) BatchStore(datasets []*models.Dataset) error {
 		err = tx.Create(dataset)
 		if err != nil {
 			tx.Rollback()
-			return err
+			return handleError(err)
 		}
 	}
 
@@ -65,14 +66,20 @@ func (tx *Tx) Create(dataset *models.Dataset) error {
 		return err
 	}
 
-	return nil
+	err = tx.validate(dataset.Id)
+	if err != nil {
+		return err
+	}
+
+	return tx.writeRevision(dataset.Id, &dataset.Creator, RevisionSourceUser)
 }
 
 // createWithMetadata inserts a new dataset into the database, but also populates other fields.
 // Use this when the new dataset already has some metadata fields set, such as when it origates from other services.
 //
 // This method does not set Modified, as that field is reserved for user edits.
-func (tx *Tx) createWithMetadata(dataset *models.Dataset) error {
+// The user that triggered the creation, if any, is recorded in the dataset's first revision.
+func (tx *Tx) createWithMetadata(dataset *models.Dataset, by *uuid.UUID) error {
 	_, err := tx.Exec("INSERT INTO datasets(id, creator, owner, created, synced, published, valid, family, schema, blob) VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)",
 		dataset.Id.Array(),
 		dataset.Creator.Array(),
@@ -89,7 +96,7 @@ func (tx *Tx) createWithMetadata(dataset *models.Dataset) error {
 		return err
 	}
 
-	return nil
+	return tx.writeRevision(dataset.Id, by, RevisionSourceSync)
 }
 
 // StoreNewVersion inserts a new version of an existing dataset, copying most fields.
@@ -109,7 +116,7 @@ func (tx *Tx) StoreNewVersion(basedOn uuid.UUID, id uuid.UUID, created time.Time
 		return ErrNotFound
 	}
 
-	return nil
+	return tx.writeRevision(id, nil, RevisionSourcePublish)
 }
 
 // WithTransaction abstracts some of the database logic by wrapping Tx methods.
@@ -156,7 +163,7 @@ func (db *DB) Update(id uuid.UUID, blob []byte) error {
 	}
 	defer tx.Rollback()
 
-	err = tx.update(id, blob)
+	_, err = tx.update(id, blob, nil)
 	if err != nil {
 		return handleError(err)
 	}
@@ -172,12 +179,12 @@ func (db *DB) UpdateWithOwner(id uuid.UUID, blob []byte, owner uuid.UUID) error
 	}
 	defer tx.Rollback()
 
-	err = tx.CheckOwner(id, owner)
+	err = tx.CheckAccess(id, owner, RoleEditor)
 	if err != nil {
 		return err
 	}
 
-	err = tx.update(id, blob)
+	_, err = tx.update(id, blob, &owner)
 	if err != nil {
 		return handleError(err)
 	}
@@ -185,22 +192,24 @@ func (db *DB) UpdateWithOwner(id uuid.UUID, blob []byte, owner uuid.UUID) error
 	return tx.Commit()
 }
 
-// internal update, user triggered
-func (tx *Tx) update(id uuid.UUID, blob []byte) error {
-	ct, err := tx.Exec("UPDATE datasets SET modified = now(), seq = seq + 1, blob = $2 WHERE id = $1", id.Array(), blob)
+// internal update, user triggered; returns the new sequence number
+func (tx *Tx) update(id uuid.UUID, blob []byte, by *uuid.UUID) (int, error) {
+	var seq int
+	err := tx.QueryRow("UPDATE datasets SET modified = now(), seq = seq + 1, blob = $2 WHERE id = $1 RETURNING seq", id.Array(), blob).Scan(&seq)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	if ct.RowsAffected() != 1 {
-		return ErrNotFound
+	err = tx.validate(id)
+	if err != nil {
+		return 0, err
 	}
 
-	return nil
+	return seq, tx.writeRevision(id, by, RevisionSourceUser)
 }
 
 // internal update, service triggered
-func (tx *Tx) updateByService(id uuid.UUID, blob []byte) error {
+func (tx *Tx) updateByService(id uuid.UUID, blob []byte, by *uuid.UUID) error {
 	ct, err := tx.Exec("UPDATE datasets SET synced = now(), seq = seq + 1, blob = $2 WHERE id = $1", id.Array(), blob)
 	if err != nil {
 		return err
@@ -210,7 +219,7 @@ func (tx *Tx) updateByService(id uuid.UUID, blob []byte) error {
 		return ErrNotFound
 	}
 
-	return nil
+	return tx.writeRevision(id, by, RevisionSourceSync)
 }
 
 func (db *DB) Patch(id uuid.UUID, blob []byte) error {
@@ -220,7 +229,7 @@ func (db *DB) Patch(id uuid.UUID, blob []byte) error {
 	}
 	defer tx.Rollback()
 
-	err = tx.patch(id, blob)
+	_, err = tx.patch(id, blob, nil)
 	if err != nil {
 		return handleError(err)
 	}
@@ -236,12 +245,12 @@ func (db *DB) PatchWithOwner(id uuid.UUID, blob []byte, owner uuid.UUID) error {
 	}
 	defer tx.Rollback()
 
-	err = tx.CheckOwner(id, owner)
+	err = tx.CheckAccess(id, owner, RoleEditor)
 	if err != nil {
 		return err
 	}
 
-	err = tx.patch(id, blob)
+	_, err = tx.patch(id, blob, &owner)
 	if err != nil {
 		return handleError(err)
 	}
@@ -249,19 +258,118 @@ func (db *DB) PatchWithOwner(id uuid.UUID, blob []byte, owner uuid.UUID) error {
 	return tx.Commit()
 }
 
-func (tx *Tx) patch(id uuid.UUID, blob []byte) error {
-	ct, err := tx.Exec("UPDATE datasets SET modified = now(), seq = seq + 1, blob = blob || $2 WHERE id = $1", id.Array(), blob)
+// internal shallow patch, user triggered; returns the new sequence number
+func (tx *Tx) patch(id uuid.UUID, blob []byte, by *uuid.UUID) (int, error) {
+	var seq int
+	err := tx.QueryRow("UPDATE datasets SET modified = now(), seq = seq + 1, blob = blob || $2 WHERE id = $1 RETURNING seq", id.Array(), blob).Scan(&seq)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	if ct.RowsAffected() != 1 {
-		return ErrNotFound
+	err = tx.validate(id)
+	if err != nil {
+		return 0, err
+	}
+
+	return seq, tx.writeRevision(id, by, RevisionSourceUser)
+}
+
+// checkSeq locks the dataset row and returns ErrSeqMismatch if its sequence number differs from the expected one.
+// A nil seq means the caller doesn't care about concurrent modifications.
+func (tx *Tx) checkSeq(id uuid.UUID, seq *int) error {
+	if seq == nil {
+		return nil
+	}
+
+	var current int
+	err := tx.QueryRow("SELECT seq FROM datasets WHERE id = $1 FOR UPDATE", id.Array()).Scan(&current)
+	if err != nil {
+		return handleError(err)
+	}
+
+	if current != *seq {
+		return ErrSeqMismatch
+	}
+
+	return nil
+}
+
+// MergePatchWithOwner applies a JSON Merge Patch (RFC 7396) to a dataset with ownership checks.
+// The patch is merged into the whole blob, so every top-level key in the patch must be a public path for the dataset's family.
+// If seq is not nil, the patch is only applied if the dataset's sequence number matches.
+// It returns the new sequence number.
+func (db *DB) MergePatchWithOwner(id uuid.UUID, patch []byte, owner uuid.UUID, seq *int) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	err = tx.CheckAccess(id, owner, RoleEditor)
+	if err != nil {
+		return 0, err
+	}
+
+	famId, err := tx.getFamily(id)
+	if err != nil {
+		return 0, err
+	}
+
+	family, err := models.LookupFamily(famId)
+	if err != nil {
+		return 0, err
+	}
+
+	err = checkPatchPaths(family, patch)
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.checkSeq(id, seq)
+	if err != nil {
+		return 0, err
+	}
+
+	newSeq, err := tx.mergePatch(id, patch, &owner)
+	if err != nil {
+		return 0, handleError(err)
+	}
+
+	return newSeq, tx.Commit()
+}
+
+// checkPatchPaths returns an error if the patch is not a JSON object or touches keys that can't be changed through the API.
+func checkPatchPaths(family *models.SchemaFamily, patch []byte) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(patch, &fields); err != nil || fields == nil {
+		return ErrInvalidJson
+	}
+
+	for key := range fields {
+		if !family.IsPathPublic(key) {
+			return ErrNotPublic
+		}
 	}
 
 	return nil
 }
 
+// mergePatch locks the dataset row, merges the patch into the current blob and stores the result as a user edit.
+func (tx *Tx) mergePatch(id uuid.UUID, patch []byte, by *uuid.UUID) (int, error) {
+	var blob []byte
+	err := tx.QueryRow("SELECT blob FROM datasets WHERE id = $1 FOR UPDATE", id.Array()).Scan(&blob)
+	if err != nil {
+		return 0, err
+	}
+
+	patched, err := mergepatch.Apply(blob, patch)
+	if err != nil {
+		return 0, ErrInvalidJson
+	}
+
+	return tx.update(id, patched, by)
+}
+
 func (db *DB) SmartGetWithOwner(id uuid.UUID, owner uuid.UUID) (*models.Dataset, error) {
 	tx, err := db.Begin()
 	if err != nil {
@@ -269,7 +377,7 @@ func (db *DB) SmartGetWithOwner(id uuid.UUID, owner uuid.UUID) (*models.Dataset,
 	}
 	defer tx.Rollback()
 
-	err = tx.CheckOwner(id, owner)
+	err = tx.CheckAccess(id, owner, RoleViewer)
 	if err != nil {
 		return nil, err
 	}
@@ -290,43 +398,53 @@ func (db *DB) SmartGetWithOwner(id uuid.UUID, owner uuid.UUID) (*models.Dataset,
 	return tx.get(id, "")
 }
 
-func (db *DB) SmartUpdateWithOwner(id uuid.UUID, blob []byte, owner uuid.UUID) error {
+// SmartUpdateWithOwner updates or patches a dataset depending on its family, with ownership checks.
+// If seq is not nil, the update only happens if the dataset's sequence number matches.
+// It returns the new sequence number.
+func (db *DB) SmartUpdateWithOwner(id uuid.UUID, blob []byte, owner uuid.UUID, seq *int) (int, error) {
 	tx, err := db.Begin()
 	if err != nil {
-		return err
+		return 0, err
 	}
 	defer tx.Rollback()
 
-	err = tx.CheckOwner(id, owner)
+	err = tx.CheckAccess(id, owner, RoleEditor)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
 	famId, err := tx.getFamily(id)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
 	family, err := models.LookupFamily(famId)
 	if err != nil {
-		return err
+		return 0, err
+	}
+
+	err = tx.checkSeq(id, seq)
+	if err != nil {
+		return 0, err
 	}
 
+	var newSeq int
 	if family.IsPartial() {
-		err = tx.patch(id, blob)
+		newSeq, err = tx.patch(id, blob, &owner)
 	} else {
-		err = tx.update(id, blob)
+		newSeq, err = tx.update(id, blob, &owner)
 	}
 	if err != nil {
-		return handleError(err)
+		return 0, handleError(err)
 	}
 
-	return tx.Commit()
+	return newSeq, tx.Commit()
 }
 
 // StorePublished saves a published dataset to the database and marks it as published.
+// The user who published the dataset, if known, is recorded in the revision history.
 // TODO: handle empty blob
-func (db *DB) StorePublished(id uuid.UUID, blob []byte) error {
+func (db *DB) StorePublished(id uuid.UUID, blob []byte, by *uuid.UUID) error {
 	tx, err := db.Begin()
 	if err != nil {
 		return err
@@ -342,9 +460,15 @@ func (db *DB) StorePublished(id uuid.UUID, blob []byte) error {
 		return ErrNotFound
 	}
 
+	err = tx.writeRevision(id, by, RevisionSourcePublish)
+	if err != nil {
+		return handleError(err)
+	}
+
 	return tx.Commit()
 }
 
+// Clone stores a copy of a dataset with a new id and the given blob as a new unpublished dataset.
 func (db *DB) Clone(id uuid.UUID, newid uuid.UUID, blob []byte) error {
 	tx, err := db.Begin()
 	if err != nil {
@@ -352,10 +476,47 @@ func (db *DB) Clone(id uuid.UUID, newid uuid.UUID, blob []byte) error {
 	}
 	defer tx.Rollback()
 
+	err = tx.clone(id, newid, blob, nil)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// CloneWithOwner stores a copy of a dataset with a new id and the given blob as a new unpublished dataset if the user has access to it.
+// The user becomes the creator and owner of the copy.
+func (db *DB) CloneWithOwner(id uuid.UUID, newid uuid.UUID, blob []byte, owner uuid.UUID) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.CheckAccess(id, owner, RoleViewer)
+	if err != nil {
+		return err
+	}
+
+	err = tx.clone(id, newid, blob, &owner)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// clone inserts a copy of a dataset; if creator is nil, the creator and owner of the original are kept.
+func (tx *Tx) clone(id uuid.UUID, newid uuid.UUID, blob []byte, creator *uuid.UUID) error {
+	var by interface{}
+	if creator != nil {
+		by = creator.Array()
+	}
+
 	ct, err := tx.Exec(`
-		INSERT INTO datasets(id, creator, owner, created, modified, synced, published, valid, family, schema, blob)
-		(SELECT $2, creator, owner, created, modified, synced, published, valid, family, schema, $3 WHERE id = $1)`,
-		id, newid, blob)
+		INSERT INTO datasets(id, creator, owner, family, schema, blob)
+		SELECT $2, coalesce($4, creator), coalesce($4, owner), family, schema, $3 FROM datasets WHERE id = $1 AND deleted IS NULL`,
+		id.Array(), newid.Array(), blob, by)
 	if err != nil {
 		return handleError(err)
 	}
@@ -364,7 +525,12 @@ func (db *DB) Clone(id uuid.UUID, newid uuid.UUID, blob []byte) error {
 		return ErrNotFound
 	}
 
-	return tx.Commit()
+	err = tx.validate(newid)
+	if err != nil {
+		return err
+	}
+
+	return tx.writeRevision(newid, creator, RevisionSourceUser)
 }
 
 func (tx *Tx) getFamily(id uuid.UUID) (int, error) {
@@ -377,19 +543,10 @@ func (tx *Tx) getFamily(id uuid.UUID) (int, error) {
 	return fam, nil
 }
 
-// CheckOwner returns an error if the record is not owned by the given user.
+// CheckOwner returns an error if the given user is not an owner of the record, either directly or through the dataset's ACL.
+// Datasets in the trash bin are considered not found.
 func (tx *Tx) CheckOwner(id uuid.UUID, owner uuid.UUID) error {
-	var isOwner bool
-	err := tx.QueryRow("SELECT (owner = $2) FROM datasets WHERE id = $1", id.Array(), owner.Array()).Scan(&isOwner)
-	if err != nil {
-		return handleError(err)
-	}
-
-	if !isOwner {
-		return ErrNotOwner
-	}
-
-	return nil
+	return tx.CheckAccess(id, owner, RoleOwner)
 }
 
 // CheckOwner calls tx.CheckOwner to check if the record exists and is owner by the given user.
@@ -483,13 +640,18 @@ func (db *DB) Get(id uuid.UUID) (*models.Dataset, error) {
 
 // GetWithOwner retrieves a dataset from the database if the owner matches.
 func (db *DB) GetWithOwner(id uuid.UUID, owner uuid.UUID) (*models.Dataset, error) {
+	return db.GetWithRole(id, owner, RoleOwner)
+}
+
+// GetWithRole retrieves a dataset from the database if the user has at least the given role.
+func (db *DB) GetWithRole(id uuid.UUID, uid uuid.UUID, role Role) (*models.Dataset, error) {
 	tx, err := db.Begin()
 	if err != nil {
 		return nil, err
 	}
 	defer tx.Rollback()
 
-	err = tx.CheckOwner(id, owner)
+	err = tx.CheckAccess(id, uid, role)
 	if err != nil {
 		return nil, err
 	}
@@ -525,6 +687,7 @@ func (tx *Tx) get(id uuid.UUID, key string) (*models.Dataset, error) {
 }
 
 // Delete removes one dataset from the database if the owner matches.
+// This is a hard delete; see Trash for moving a dataset to the trash bin.
 func (db *DB) Delete(id uuid.UUID, owner *uuid.UUID) error {
 	tx, err := db.Begin()
 	if err != nil {
@@ -586,7 +749,7 @@ func (db *DB) ListAllForUid(uid uuid.UUID) ([]*models.Dataset, error) {
 	return list, nil
 }
 
-// ChangeOwnerTo updates a dataset's owner.
+// ChangeOwnerTo updates a dataset's owner without any checks; users should use the transfer functions instead.
 func (db *DB) ChangeOwnerTo(id uuid.UUID, uid uuid.UUID) error {
 	tx, err := db.pool.Begin()
 	if err != nil {
@@ -594,11 +757,14 @@ func (db *DB) ChangeOwnerTo(id uuid.UUID, uid uuid.UUID) error {
 	}
 	defer tx.Rollback()
 
-	tag, err := tx.Exec("UPDATE datasets SET owner = $1 WHERE id = $2", uid.Array(), id.Array())
+	ct, err := tx.Exec("UPDATE datasets SET owner = $1 WHERE id = $2", uid.Array(), id.Array())
 	if err != nil {
 		return handleError(err)
 	}
-	log.Println("tag:", tag)
+
+	if ct.RowsAffected() != 1 {
+		return ErrNotFound
+	}
 
 	return tx.Commit()
 }
@@ -24,11 +24,22 @@ func (e *DatabaseError) Error() string {
 
 // Errors exported by the database layer.
 var (
-	ErrExists         = NewError("exists")
-	ErrNotFound       = NewError("not found")
-	ErrNotOwner       = NewError("not owner")
-	ErrInvalidJson    = NewError("invalid json")
-	ErrNotImplemented = NewError("not implemented")
+	ErrExists            = NewError("exists")
+	ErrNotFound          = NewError("not found")
+	ErrNotOwner          = NewError("not owner")
+	ErrInvalidJson       = NewError("invalid json")
+	ErrNotImplemented    = NewError("not implemented")
+	ErrNotPublic         = NewError("path not public")
+	ErrSeqMismatch       = NewError("sequence mismatch")
+	ErrInvalidSort       = NewError("invalid sort order")
+	ErrInvalidCursor     = NewError("invalid cursor")
+	ErrInvalidLanguage   = NewError("invalid language")
+	ErrSelfTransfer      = NewError("can't transfer to self")
+	ErrNoAccess          = NewError("insufficient role")
+	ErrInvalidRole       = NewError("invalid role")
+	ErrIsOwner           = NewError("user is owner")
+	ErrInvalidResolution = NewError("invalid conflict resolution")
+	ErrBatchAborted      = NewError("batch transaction aborted")
 )
 
 // Errors from the underlying database connection.
@@ -0,0 +1,114 @@
+package psql
+
+import (
+	"encoding/base64"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wvh/uuid"
+)
+
+// MaxListLimit is the maximum number of datasets that can be requested in one page.
+const MaxListLimit = 500
+
+// sortColumns maps sort fields accepted by the API to database columns; only (non-null) timestamp columns are supported.
+var sortColumns = map[string]string{
+	"modified": "modified",
+	"created":  "created",
+}
+
+// DatasetFilter holds the filtering, sorting and paging options for dataset listings.
+// The zero value lists all datasets not in the trash bin, most recently modified first.
+type DatasetFilter struct {
+	// Limit is the maximum number of results; 0 means no limit.
+	Limit int
+
+	// Sort is the field to sort on, optionally prefixed with `-` for descending order; defaults to `-modified`.
+	Sort string
+
+	// Cursor is the position after which to continue listing, as returned with the previous page.
+	Cursor *Cursor
+
+	// Published, if set, restricts the listing to (un)published datasets.
+	Published *bool
+
+	// Schema, if set, restricts the listing to datasets with the given schema.
+	Schema string
+
+	// Query, if set, restricts the listing to datasets with a title containing the given text.
+	Query string
+
+	// Trashed lists the datasets in the trash bin instead of the live ones.
+	Trashed bool
+
+	// Project, if set, lists the datasets owned by the given IDA project instead of the user's own and shared datasets.
+	// The caller must check that the user is a member of the project.
+	Project string
+}
+
+// sortOrder returns the sort field and direction, or ErrInvalidSort.
+func (filter *DatasetFilter) sortOrder() (field string, desc bool, err error) {
+	sort := filter.Sort
+	if sort == "" {
+		sort = "-modified"
+	}
+
+	if strings.HasPrefix(sort, "-") {
+		sort, desc = sort[1:], true
+	}
+
+	if _, ok := sortColumns[sort]; !ok {
+		return "", false, ErrInvalidSort
+	}
+
+	return sort, desc, nil
+}
+
+// Cursor marks a position in a sorted dataset listing using the value of the sort field and the dataset id as tie-breaker.
+type Cursor struct {
+	Sort  string
+	Value time.Time
+	Id    uuid.UUID
+}
+
+// String encodes the cursor as an opaque URL-safe string.
+func (cursor *Cursor) String() string {
+	raw := cursor.Sort + "|" + strconv.FormatInt(cursor.Value.UnixNano()/1000, 10) + "|" + cursor.Id.String()
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// ParseCursor decodes a cursor string made by Cursor.String.
+func ParseCursor(s string) (*Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	parts := strings.Split(string(raw), "|")
+	if len(parts) != 3 {
+		return nil, ErrInvalidCursor
+	}
+
+	if _, ok := sortColumns[parts[0]]; !ok {
+		return nil, ErrInvalidCursor
+	}
+
+	micros, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	id, err := uuid.FromString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	// Postgresql timestamps have microsecond precision
+	return &Cursor{Sort: parts[0], Value: time.Unix(0, micros*1000), Id: id}, nil
+}
+
+// escapeLike escapes the wildcard characters in a string meant to be used in a LIKE pattern.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
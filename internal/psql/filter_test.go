@@ -0,0 +1,55 @@
+package psql
+
+import (
+	"testing"
+	"time"
+
+	"github.com/wvh/uuid"
+)
+
+func TestCursor(t *testing.T) {
+	id := uuid.MustNewUUID()
+	cursor := &Cursor{Sort: "created", Value: time.Date(2019, 3, 4, 5, 6, 7, 123456000, time.UTC), Id: id}
+
+	parsed, err := ParseCursor(cursor.String())
+	if err != nil {
+		t.Fatal("ParseCursor():", err)
+	}
+	if parsed.Sort != cursor.Sort || !parsed.Value.Equal(cursor.Value) || parsed.Id != cursor.Id {
+		t.Errorf("expected %+v, got %+v", cursor, parsed)
+	}
+
+	for _, invalid := range []string{"", "!!!", "Zm9v", (&Cursor{Sort: "title", Id: id}).String()} {
+		if _, err := ParseCursor(invalid); err != ErrInvalidCursor {
+			t.Errorf("ParseCursor(%q): expected %v, got %v", invalid, ErrInvalidCursor, err)
+		}
+	}
+}
+
+func TestSortOrder(t *testing.T) {
+	tests := []struct {
+		sort  string
+		field string
+		desc  bool
+		err   error
+	}{
+		{sort: "", field: "modified", desc: true},
+		{sort: "modified", field: "modified", desc: false},
+		{sort: "-created", field: "created", desc: true},
+		{sort: "title", err: ErrInvalidSort},
+		{sort: "--modified", err: ErrInvalidSort},
+	}
+
+	for _, test := range tests {
+		field, desc, err := (&DatasetFilter{Sort: test.sort}).sortOrder()
+		if err != test.err || field != test.field || desc != test.desc {
+			t.Errorf("sort %q: expected (%q, %v, %v), got (%q, %v, %v)", test.sort, test.field, test.desc, test.err, field, desc, err)
+		}
+	}
+}
+
+func TestEscapeLike(t *testing.T) {
+	if escaped := escapeLike(`100%_a\b`); escaped != `100\%\_a\\b` {
+		t.Errorf("unexpected escape: %s", escaped)
+	}
+}
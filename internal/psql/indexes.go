@@ -6,7 +6,7 @@ import (
 
 func (db *DB) LookupByQvainId(id uuid.UUID) (bool, error) {
 	var exists bool
-	err := db.pool.QueryRow(`SELECT true FROM datasets WHERE id = $1 LIMIT 1`, id.Array()).Scan(&exists)
+	err := db.pool.QueryRow(`SELECT true FROM datasets WHERE id = $1 AND deleted IS NULL LIMIT 1`, id.Array()).Scan(&exists)
 	return exists, handleError(err)
 }
 
@@ -14,7 +14,7 @@ func (db *DB) LookupByQvainId(id uuid.UUID) (bool, error) {
 func (db *DB) LookupByFairdataIdentifier(fdid string) (uuid.UUID, error) {
 	var id uuid.UUID
 	//err := db.pool.QueryRow(`SELECT id FROM datasets WHERE family = 2 AND blob @> '{"identifier": $1}'`, `"` + fdid + `"`).Scan(&id)
-	err := db.pool.QueryRow(`SELECT id FROM datasets WHERE family = 2 AND blob @> jsonb_build_object('identifier', $1::text)`, fdid).Scan(id.Array())
+	err := db.pool.QueryRow(`SELECT id FROM datasets WHERE family = 2 AND blob->>'identifier' = $1 AND deleted IS NULL`, fdid).Scan(id.Array())
 	if err != nil {
 		return id, handleError(err)
 	}
@@ -0,0 +1,201 @@
+package psql
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/jackc/pgx"
+	"github.com/wvh/uuid"
+)
+
+// Job states; see table `jobs`.
+const (
+	JobPending = "pending"
+	JobRunning = "running"
+	JobDone    = "done"
+	JobDead    = "dead"
+)
+
+// Job is a unit of background work stored in the database.
+type Job struct {
+	Id          int64           `json:"id"`
+	Kind        string          `json:"kind"`
+	Key         *string         `json:"key,omitempty"`
+	Uid         *uuid.UUID      `json:"uid,omitempty"`
+	Payload     json.RawMessage `json:"payload"`
+	State       string          `json:"state"`
+	Attempts    int             `json:"attempts"`
+	MaxAttempts int             `json:"max_attempts"`
+	RunAt       time.Time       `json:"run_at"`
+	Created     time.Time       `json:"created"`
+	Updated     time.Time       `json:"updated"`
+	Result      json.RawMessage `json:"result,omitempty"`
+	Error       *string         `json:"error,omitempty"`
+}
+
+// jobColumns are the columns scanned by scanJob, in order.
+const jobColumns = `id, kind, key, uid, payload, state, attempts, max_attempts, run_at, created, updated, result, error`
+
+// scanJob reads a row with jobColumns into a Job.
+func scanJob(row *pgx.Row) (*Job, error) {
+	var (
+		job             Job
+		uid             *[16]byte
+		payload, result []byte
+	)
+	err := row.Scan(&job.Id, &job.Kind, &job.Key, &uid, &payload, &job.State, &job.Attempts, &job.MaxAttempts, &job.RunAt, &job.Created, &job.Updated, &result, &job.Error)
+	if err != nil {
+		return nil, handleError(err)
+	}
+	if uid != nil {
+		job.Uid = &uuid.UUID{}
+		*job.Uid.Array() = *uid
+	}
+	job.Payload, job.Result = payload, result
+
+	return &job, nil
+}
+
+// EnqueueJob stores a new pending job to run at the given time and returns its id.
+// If key is not empty and a pending or running job with the same key exists, no job is added and the id of the existing job is returned.
+func (db *DB) EnqueueJob(kind string, key string, uid *uuid.UUID, payload []byte, runAt time.Time, maxAttempts int) (int64, error) {
+	var (
+		id     int64
+		keyArg interface{}
+		uidArg interface{}
+	)
+	if key != "" {
+		keyArg = key
+	}
+	if uid != nil {
+		uidArg = uid.Array()
+	}
+
+	err := db.pool.QueryRow(`
+		WITH inserted AS (
+			INSERT INTO jobs(kind, key, uid, payload, run_at, max_attempts) VALUES($1, $2, $3, $4, $5, $6)
+			ON CONFLICT (key) WHERE state IN ('pending', 'running') DO NOTHING
+			RETURNING id
+		)
+		SELECT id FROM inserted
+		UNION ALL
+		SELECT id FROM jobs WHERE key = $2 AND state IN ('pending', 'running')
+		LIMIT 1
+	`, kind, keyArg, uidArg, payload, runAt, maxAttempts).Scan(&id)
+	if err != nil {
+		return 0, handleError(err)
+	}
+
+	return id, nil
+}
+
+// ClaimJob marks the next job of one of the given kinds that is due as running and returns it, or ErrNotFound if there is none.
+// Running jobs whose lease has expired, for instance because the process running them stopped, are claimed again,
+// unless that was their last attempt or they were creating a dataset in Metax; see reapJobs.
+func (db *DB) ClaimJob(kinds []string, lease time.Duration) (*Job, error) {
+	if err := db.reapJobs(kinds); err != nil {
+		return nil, err
+	}
+
+	return scanJob(db.pool.QueryRow(`
+		UPDATE jobs SET state = 'running', attempts = attempts + 1, locked_until = now() + make_interval(secs => $2::double precision), updated = now()
+		WHERE id = (
+			SELECT id FROM jobs
+			WHERE kind = ANY($1) AND run_at <= now() AND (
+				state = 'pending' OR
+				(state = 'running' AND locked_until < now() AND attempts < max_attempts)
+			)
+			ORDER BY run_at
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING `+jobColumns, kinds, lease.Seconds()))
+}
+
+// reapJobs marks running jobs of the given kinds whose lease has expired as dead if claiming them again isn't safe:
+// jobs that were on their last attempt, and publish jobs for a dataset without Metax identifier,
+// as the lost attempt may have created the dataset in Metax already and a sync should link it before it is published again.
+func (db *DB) reapJobs(kinds []string) error {
+	_, err := db.pool.Exec(`
+		UPDATE jobs SET state = 'dead', locked_until = NULL, updated = now(),
+			error = CASE WHEN attempts >= max_attempts
+				THEN 'lease expired on the last attempt'
+				ELSE 'lease expired while creating the dataset in Metax; sync before publishing again'
+			END
+		WHERE id IN (
+			SELECT jobs.id FROM jobs
+			LEFT JOIN datasets ON jobs.kind = 'publish' AND datasets.id = (jobs.payload->>'id')::uuid
+			WHERE jobs.kind = ANY($1) AND jobs.state = 'running' AND jobs.locked_until < now() AND (
+				jobs.attempts >= jobs.max_attempts OR
+				(jobs.kind = 'publish' AND datasets.blob->>'identifier' IS NULL)
+			)
+			FOR UPDATE OF jobs SKIP LOCKED
+		)
+	`, kinds)
+	return handleError(err)
+}
+
+// CompleteJob marks a running job as done with the given result.
+func (db *DB) CompleteJob(id int64, result []byte) error {
+	_, err := db.pool.Exec(`UPDATE jobs SET state = 'done', result = $2, error = NULL, locked_until = NULL, updated = now() WHERE id = $1`, id, result)
+	return handleError(err)
+}
+
+// FailJob records the error and partial result, if any, of a running job. The job is retried at the given time, or becomes dead if dead is true.
+func (db *DB) FailJob(id int64, msg string, result []byte, retryAt time.Time, dead bool) error {
+	state := JobPending
+	if dead {
+		state = JobDead
+	}
+
+	_, err := db.pool.Exec(`UPDATE jobs SET state = $2, error = $3, result = $4, run_at = $5, locked_until = NULL, updated = now() WHERE id = $1`, id, state, msg, result, retryAt)
+	return handleError(err)
+}
+
+// RetryJob makes a dead job pending again with a fresh set of attempts.
+func (db *DB) RetryJob(id int64) error {
+	tag, err := db.pool.Exec(`UPDATE jobs SET state = 'pending', attempts = 0, run_at = now(), updated = now() WHERE id = $1 AND state = 'dead'`, id)
+	if err != nil {
+		return handleError(err)
+	}
+	if tag.RowsAffected() != 1 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// GetJob returns a job by id.
+func (db *DB) GetJob(id int64) (*Job, error) {
+	return scanJob(db.pool.QueryRow(`SELECT `+jobColumns+` FROM jobs WHERE id = $1`, id))
+}
+
+// ViewJobs returns a (JSON) array with the latest jobs, optionally of a given state and kind, newest first.
+func (db *DB) ViewJobs(state string, kind string, limit int) (json.RawMessage, error) {
+	var jobs json.RawMessage
+
+	err := db.pool.QueryRow(`
+		SELECT coalesce(json_agg(job ORDER BY job.id DESC), '[]') "jobs"
+		FROM (
+			SELECT `+jobColumns+`
+			FROM jobs
+			WHERE ($1 = '' OR state = $1) AND ($2 = '' OR kind = $2)
+			ORDER BY id DESC
+			LIMIT $3
+		) job
+	`, state, kind, limit).Scan(&jobs)
+	if err != nil {
+		return nil, handleError(err)
+	}
+
+	return jobs, nil
+}
+
+// PurgeJobs deletes finished jobs, but not dead ones, last updated before the given retention period.
+func (db *DB) PurgeJobs(retention time.Duration) (int64, error) {
+	tag, err := db.pool.Exec(`DELETE FROM jobs WHERE state = 'done' AND updated < $1`, time.Now().Add(-retention))
+	if err != nil {
+		return 0, handleError(err)
+	}
+
+	return tag.RowsAffected(), nil
+}
@@ -0,0 +1,120 @@
+package psql
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/NatLibFi/qvain-api/pkg/models"
+	"github.com/wvh/uuid"
+)
+
+// checkPath returns an error if the key path is empty or not public for the dataset's family.
+func (tx *Tx) checkPath(id uuid.UUID, path []string) error {
+	if len(path) == 0 {
+		return ErrNotPublic
+	}
+
+	famId, err := tx.getFamily(id)
+	if err != nil {
+		return err
+	}
+
+	family, err := models.LookupFamily(famId)
+	if err != nil {
+		return err
+	}
+
+	if !family.IsPathPublic(strings.Join(path, "/")) {
+		return ErrNotPublic
+	}
+
+	return nil
+}
+
+// ViewPathWithOwner returns the JSON sub-tree of a dataset at the given key path and the dataset's sequence number.
+// Array elements can be selected by index. The path must be public for the dataset's family.
+func (db *DB) ViewPathWithOwner(id uuid.UUID, owner uuid.UUID, path []string) (json.RawMessage, int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, 0, err
+	}
+	defer tx.Rollback()
+
+	err = tx.CheckAccess(id, owner, RoleViewer)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	err = tx.checkPath(id, path)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var (
+		seq   int
+		value json.RawMessage
+	)
+	err = tx.QueryRow(`SELECT seq, blob#>$2 FROM datasets WHERE id = $1`, id.Array(), path).Scan(&seq, &value)
+	if err != nil {
+		return nil, 0, handleError(err)
+	}
+
+	if value == nil {
+		return nil, 0, ErrNotFound
+	}
+
+	return value, seq, nil
+}
+
+// UpdatePathWithOwner replaces the JSON sub-tree of a dataset at the given key path and returns the new sequence number.
+// The last key is created if it doesn't exist, but its parent must exist. The path must be public for the dataset's family.
+// If seq is not nil, the update only happens if the dataset's sequence number matches.
+func (db *DB) UpdatePathWithOwner(id uuid.UUID, owner uuid.UUID, path []string, value []byte, seq *int) (int, error) {
+	if !json.Valid(value) {
+		return 0, ErrInvalidJson
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	err = tx.CheckAccess(id, owner, RoleEditor)
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.checkPath(id, path)
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.checkSeq(id, seq)
+	if err != nil {
+		return 0, err
+	}
+
+	var (
+		hasParent bool
+		blob      []byte
+	)
+	err = tx.QueryRow(`
+		SELECT blob#>$2 IS NOT NULL, jsonb_set(blob, $3, $4::jsonb, true)
+		FROM datasets WHERE id = $1 FOR UPDATE
+	`, id.Array(), path[:len(path)-1], path, string(value)).Scan(&hasParent, &blob)
+	if err != nil {
+		return 0, handleError(err)
+	}
+
+	if !hasParent {
+		return 0, ErrNotFound
+	}
+
+	newSeq, err := tx.update(id, blob, &owner)
+	if err != nil {
+		return 0, handleError(err)
+	}
+
+	return newSeq, tx.Commit()
+}
@@ -0,0 +1,93 @@
+package psql
+
+import (
+	"github.com/NatLibFi/qvain-api/pkg/models"
+	"github.com/wvh/uuid"
+)
+
+// SetProjects replaces the IDA project memberships of a user, typically with the projects from the user's login token.
+func (db *DB) SetProjects(uid uuid.UUID, projects []string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if projects == nil {
+		projects = []string{}
+	}
+
+	_, err = tx.Exec(`DELETE FROM project_members WHERE uid = $1 AND project <> ALL($2)`, uid.Array(), projects)
+	if err != nil {
+		return handleError(err)
+	}
+
+	for _, project := range projects {
+		_, err = tx.Exec(`
+			INSERT INTO project_members(uid, project) VALUES($1, $2)
+			ON CONFLICT (uid, project) DO UPDATE SET updated = now()
+		`, uid.Array(), project)
+		if err != nil {
+			return handleError(err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// CreateInProject creates a new dataset owned by the given IDA project.
+// The caller must check that the creator is a member of the project.
+func (db *DB) CreateInProject(dataset *models.Dataset, project string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.Create(dataset)
+	if err != nil {
+		return handleError(err)
+	}
+
+	err = tx.setProject(dataset.Id, &project)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// SetProject hands a dataset over to an IDA project, or takes it away from its project if project is nil.
+// Only owners can change a dataset's project; the caller must check that the user is a member of the new project.
+func (db *DB) SetProject(id uuid.UUID, owner uuid.UUID, project *string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.CheckOwner(id, owner)
+	if err != nil {
+		return err
+	}
+
+	err = tx.setProject(id, project)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (tx *Tx) setProject(id uuid.UUID, project *string) error {
+	ct, err := tx.Exec(`UPDATE datasets SET project = $2 WHERE id = $1`, id.Array(), project)
+	if err != nil {
+		return handleError(err)
+	}
+
+	if ct.RowsAffected() != 1 {
+		return ErrNotFound
+	}
+
+	return nil
+}
@@ -16,8 +16,9 @@ const DefaultPoolAcquireTimeout = 10 * time.Second
 type DB struct {
 	config *pgx.ConnConfig
 	//poolConfig *pgx.ConnPoolConfig
-	pool   *pgx.ConnPool
-	logger zerolog.Logger
+	pool      *pgx.ConnPool
+	logger    zerolog.Logger
+	validator Validator
 }
 
 // NewService returns a database handle configured with the given connection string.
@@ -75,6 +76,12 @@ func (psql *DB) SetLogger(logger zerolog.Logger) {
 	psql.logger = logger
 }
 
+// SetValidator assigns the validator used to check datasets when users create or change them.
+// It is not safe to call this function after initialisation.
+func (psql *DB) SetValidator(validator Validator) {
+	psql.validator = validator
+}
+
 // Connect returns a single database conn or an error.
 func (psql *DB) Connect() (*pgx.Conn, error) {
 	return pgx.Connect(*psql.config)
@@ -101,6 +108,8 @@ func (psql *DB) InitPool() (err error) {
 
 type Tx struct {
 	*pgx.Tx
+	validator Validator
+	logger    zerolog.Logger
 }
 
 func (psql *DB) Begin() (*Tx, error) {
@@ -108,7 +117,7 @@ func (psql *DB) Begin() (*Tx, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &Tx{tx}, nil
+	return &Tx{Tx: tx, validator: psql.validator, logger: psql.logger}, nil
 }
 
 func (psql *DB) Version() (string, error) {
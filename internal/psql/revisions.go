@@ -0,0 +1,183 @@
+package psql
+
+import (
+	"encoding/json"
+
+	"github.com/NatLibFi/qvain-api/pkg/models"
+	"github.com/wvh/uuid"
+)
+
+// Revision sources, stored with each revision to indicate what caused the change.
+const (
+	RevisionSourceUser    = "user"
+	RevisionSourceSync    = "sync"
+	RevisionSourcePublish = "publish"
+)
+
+// writeRevision copies the current state of a dataset into the revision table.
+// It should be called in the same transaction right after the dataset's blob and sequence number have changed.
+func (tx *Tx) writeRevision(id uuid.UUID, by *uuid.UUID, source string) error {
+	var uid interface{}
+	if by != nil {
+		uid = by.Array()
+	}
+
+	_, err := tx.Exec(`
+		INSERT INTO dataset_revisions(id, seq, uid, source, blob)
+		SELECT id, seq, $2, $3, blob FROM datasets WHERE id = $1
+	`, id.Array(), uid, source)
+	return err
+}
+
+// ViewRevisions returns a (JSON) array with the revisions of a given dataset, newest first, without the dataset blobs.
+func (db *DB) ViewRevisions(id uuid.UUID, owner uuid.UUID, svc string) (json.RawMessage, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return apiEmptyList, err
+	}
+	defer tx.Rollback()
+
+	err = tx.CheckAccess(id, owner, RoleViewer)
+	if err != nil {
+		return apiEmptyList, err
+	}
+
+	var result json.RawMessage
+	err = tx.QueryRow(`
+		SELECT coalesce(json_agg(result ORDER BY seq DESC), '[]') "revisions"
+		FROM (
+			SELECT seq, created, source,
+				(SELECT extids->$2 FROM identities WHERE uid = dataset_revisions.uid) AS "user"
+			FROM dataset_revisions
+			WHERE id = $1
+		) result
+	`, id.Array(), svc).Scan(&result)
+	if err != nil {
+		return apiEmptyList, handleError(err)
+	}
+
+	return result, nil
+}
+
+// ViewRevisionWithOwner returns the API view of a single revision of a dataset if the owner matches.
+// Like ViewDatasetWithOwner, it only shows the public part of partial datasets.
+func (db *DB) ViewRevisionWithOwner(id uuid.UUID, owner uuid.UUID, seq int, svc string) (json.RawMessage, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	err = tx.CheckAccess(id, owner, RoleViewer)
+	if err != nil {
+		return nil, err
+	}
+
+	famId, err := tx.getFamily(id)
+	if err != nil {
+		return nil, err
+	}
+
+	family, err := models.LookupFamily(famId)
+	if err != nil {
+		return nil, err
+	}
+
+	path := []string{}
+	if family.IsPartial() {
+		path = []string{family.Key()}
+	}
+
+	var record json.RawMessage
+	err = tx.QueryRow(`
+		SELECT row_to_json(result) "record"
+		FROM (
+			SELECT id, seq, created, source, blob#>$3 AS dataset,
+				(SELECT extids->$4 FROM identities WHERE uid = dataset_revisions.uid) AS "user"
+			FROM dataset_revisions
+			WHERE id = $1 AND seq = $2
+		) result
+	`, id.Array(), seq, path, svc).Scan(&record)
+	if err != nil {
+		return nil, handleError(err)
+	}
+
+	return record, nil
+}
+
+// RestoreRevisionWithOwner replaces the public paths of a dataset with those from an earlier revision, with ownership checks.
+// The restore is itself a user edit, so it gets a new sequence number and revision; it returns the new sequence number.
+func (db *DB) RestoreRevisionWithOwner(id uuid.UUID, owner uuid.UUID, seq int) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	err = tx.CheckAccess(id, owner, RoleEditor)
+	if err != nil {
+		return 0, err
+	}
+
+	famId, err := tx.getFamily(id)
+	if err != nil {
+		return 0, err
+	}
+
+	family, err := models.LookupFamily(famId)
+	if err != nil {
+		return 0, err
+	}
+
+	var current, old []byte
+	err = tx.QueryRow("SELECT blob FROM datasets WHERE id = $1 FOR UPDATE", id.Array()).Scan(&current)
+	if err != nil {
+		return 0, handleError(err)
+	}
+
+	err = tx.QueryRow("SELECT blob FROM dataset_revisions WHERE id = $1 AND seq = $2", id.Array(), seq).Scan(&old)
+	if err != nil {
+		return 0, handleError(err)
+	}
+
+	restored, err := restorePublicPaths(family, current, old)
+	if err != nil {
+		return 0, err
+	}
+
+	newSeq, err := tx.update(id, restored, &owner)
+	if err != nil {
+		return 0, handleError(err)
+	}
+
+	return newSeq, tx.Commit()
+}
+
+// restorePublicPaths takes the top-level keys that are public for the given family from an old blob and puts them in the current one.
+// Private keys, such as identifiers set by external services, are kept as they are.
+func restorePublicPaths(family *models.SchemaFamily, current []byte, old []byte) ([]byte, error) {
+	var currentFields, oldFields map[string]json.RawMessage
+
+	if err := json.Unmarshal(current, &currentFields); err != nil {
+		return nil, ErrInvalidJson
+	}
+	if err := json.Unmarshal(old, &oldFields); err != nil {
+		return nil, ErrInvalidJson
+	}
+	if currentFields == nil {
+		currentFields = make(map[string]json.RawMessage)
+	}
+
+	for key := range currentFields {
+		if _, exists := oldFields[key]; !exists && family.IsPathPublic(key) {
+			delete(currentFields, key)
+		}
+	}
+	for key, value := range oldFields {
+		if family.IsPathPublic(key) {
+			currentFields[key] = value
+		}
+	}
+
+	return json.Marshal(currentFields)
+}
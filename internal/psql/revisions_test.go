@@ -0,0 +1,67 @@
+package psql
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/NatLibFi/qvain-api/pkg/metax"
+	"github.com/NatLibFi/qvain-api/pkg/models"
+)
+
+func TestRestorePublicPaths(t *testing.T) {
+	tests := []struct {
+		name     string
+		family   int
+		current  string
+		old      string
+		expected string
+	}{
+		{
+			name:     "metax keeps private keys",
+			family:   metax.MetaxDatasetFamily,
+			current:  `{"identifier":"urn:x","research_dataset":{"title":{"en":"new"}},"contracts":[1]}`,
+			old:      `{"research_dataset":{"title":{"en":"old"}}}`,
+			expected: `{"identifier":"urn:x","research_dataset":{"title":{"en":"old"}}}`,
+		},
+		{
+			name:     "metax ignores old private keys",
+			family:   metax.MetaxDatasetFamily,
+			current:  `{"identifier":"urn:new","research_dataset":{}}`,
+			old:      `{"identifier":"urn:old","research_dataset":{"title":{"en":"old"}},"contracts":[1]}`,
+			expected: `{"identifier":"urn:new","research_dataset":{"title":{"en":"old"}},"contracts":[1]}`,
+		},
+		{
+			name:     "open dataset restores everything",
+			family:   1,
+			current:  `{"title":"new","extra":true}`,
+			old:      `{"title":"old"}`,
+			expected: `{"title":"old"}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			family, err := models.LookupFamily(test.family)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			restored, err := restorePublicPaths(family, []byte(test.current), []byte(test.old))
+			if err != nil {
+				t.Fatal("restorePublicPaths():", err)
+			}
+
+			var got, expected interface{}
+			if err := json.Unmarshal(restored, &got); err != nil {
+				t.Fatal(err)
+			}
+			if err := json.Unmarshal([]byte(test.expected), &expected); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, expected) {
+				t.Errorf("expected %s, got %s", test.expected, restored)
+			}
+		})
+	}
+}
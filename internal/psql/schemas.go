@@ -0,0 +1,103 @@
+package psql
+
+import (
+	"encoding/json"
+
+	"github.com/wvh/uuid"
+)
+
+// SchemaVersion is an uploaded version of a JSON Schema; see table `schemas`. Ui is nil if there are no UI hints.
+type SchemaVersion struct {
+	Name    string
+	Version int
+	Schema  json.RawMessage
+	Ui      json.RawMessage
+}
+
+// AddSchema stores a new version of the JSON Schema for a dataset schema name and returns its version number.
+// The new version is not used until it is activated. Concurrent uploads for the same name can fail with ErrExists.
+func (db *DB) AddSchema(name string, schema json.RawMessage, ui json.RawMessage, creator uuid.UUID) (int, error) {
+	var hints interface{}
+	if len(ui) > 0 {
+		hints = string(ui)
+	}
+
+	var version int
+	err := db.pool.QueryRow(`
+		INSERT INTO schemas(name, version, schema, ui, creator)
+		SELECT $1, coalesce(max(version), 0) + 1, $2::jsonb, $3::jsonb, $4 FROM schemas WHERE name = $1
+		RETURNING version
+	`, name, string(schema), hints, creator.Array()).Scan(&version)
+	if err != nil {
+		return 0, handleError(err)
+	}
+
+	return version, nil
+}
+
+// ActivateSchema makes the given version the active one for a dataset schema name and returns it.
+func (db *DB) ActivateSchema(name string, version int) (*SchemaVersion, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// the unique index allows only one active version, so deactivate first
+	_, err = tx.Exec("UPDATE schemas SET active = false WHERE name = $1 AND active AND version <> $2", name, version)
+	if err != nil {
+		return nil, handleError(err)
+	}
+
+	var schema, ui []byte
+	err = tx.QueryRow("UPDATE schemas SET active = true WHERE name = $1 AND version = $2 RETURNING schema, ui", name, version).Scan(&schema, &ui)
+	if err != nil {
+		return nil, handleError(err)
+	}
+
+	return &SchemaVersion{Name: name, Version: version, Schema: schema, Ui: ui}, tx.Commit()
+}
+
+// ActiveSchemas returns the active version of each dataset schema in the database.
+func (db *DB) ActiveSchemas() ([]SchemaVersion, error) {
+	rows, err := db.pool.Query("SELECT name, version, schema, ui FROM schemas WHERE active ORDER BY name")
+	if err != nil {
+		return nil, handleError(err)
+	}
+	defer rows.Close()
+
+	var schemas []SchemaVersion
+	for rows.Next() {
+		var (
+			sv         SchemaVersion
+			schema, ui []byte
+		)
+		if err := rows.Scan(&sv.Name, &sv.Version, &schema, &ui); err != nil {
+			return nil, handleError(err)
+		}
+		sv.Schema, sv.Ui = schema, ui
+		schemas = append(schemas, sv)
+	}
+
+	return schemas, handleError(rows.Err())
+}
+
+// ViewSchemaVersions returns a (JSON) array with the uploaded versions of a dataset schema, newest first, without their contents.
+func (db *DB) ViewSchemaVersions(name string, svc string) (json.RawMessage, error) {
+	var versions json.RawMessage
+
+	err := db.pool.QueryRow(`
+		SELECT coalesce(json_agg(result ORDER BY version DESC), '[]') "versions"
+		FROM (
+			SELECT name, version, active, created, ui IS NOT NULL AS has_ui,
+				(SELECT extids->$2 FROM identities WHERE uid = creator) AS creator
+			FROM schemas
+			WHERE name = $1
+		) result
+	`, name, svc).Scan(&versions)
+	if err != nil {
+		return nil, handleError(err)
+	}
+
+	return versions, nil
+}
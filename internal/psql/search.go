@@ -0,0 +1,75 @@
+package psql
+
+import (
+	"encoding/json"
+
+	"github.com/wvh/uuid"
+)
+
+// MaxSearchLimit is the maximum number of search results that can be requested at once.
+const MaxSearchLimit = 100
+
+// searchConfigs maps the language codes used in multilingual dataset fields to Postgresql text search configurations.
+var searchConfigs = map[string]string{
+	"fi": "finnish",
+	"sv": "swedish",
+	"en": "english",
+}
+
+// SearchLanguageIsValid checks if the given language code has a text search configuration; the empty string means all languages.
+func SearchLanguageIsValid(lang string) bool {
+	if lang == "" {
+		return true
+	}
+	_, ok := searchConfigs[lang]
+	return ok
+}
+
+// SearchDatasetsByOwner does a full-text search over the titles, descriptions and keywords of a user's datasets.
+// It returns a JSON array of matches ordered by rank, each with highlighted snippets of the title and description.
+// The query uses web search syntax; if a language is given, only that language's stemming rules are used to parse it.
+func (db *DB) SearchDatasetsByOwner(owner uuid.UUID, query string, lang string, limit int, offset int) (json.RawMessage, error) {
+	if !SearchLanguageIsValid(lang) {
+		return apiEmptyList, ErrInvalidLanguage
+	}
+	if limit < 1 || limit > MaxSearchLimit {
+		limit = MaxSearchLimit
+	}
+
+	var result json.RawMessage
+	err := db.pool.QueryRow(`
+		WITH query AS (
+			SELECT CASE $2::text
+				WHEN '' THEN websearch_to_tsquery('finnish', $1)
+					|| websearch_to_tsquery('swedish', $1)
+					|| websearch_to_tsquery('english', $1)
+					|| websearch_to_tsquery('simple', $1)
+				ELSE websearch_to_tsquery(search_config($2), $1)
+					|| websearch_to_tsquery('simple', $1)
+				END AS q
+		)
+		SELECT coalesce(json_agg(result ORDER BY rank DESC, modified DESC), '[]') "search"
+		FROM (
+			SELECT id, created, modified, published,
+				blob#>'{identifier}' identifier,
+				blob#>'{research_dataset,title}' title,
+				blob#>'{research_dataset,description}' description,
+				ts_rank_cd(search, query.q) rank,
+				json_build_object(
+					'title', (SELECT jsonb_object_agg(key, ts_headline(search_config(key), value, query.q))
+						FROM jsonb_each_text(blob#>'{research_dataset,title}')),
+					'description', (SELECT jsonb_object_agg(key, ts_headline(search_config(key), value, query.q, 'MaxFragments=2'))
+						FROM jsonb_each_text(blob#>'{research_dataset,description}'))
+				) highlight
+			FROM datasets, query
+			WHERE owner = $3 AND deleted IS NULL AND search @@ query.q
+			ORDER BY rank DESC, modified DESC
+			LIMIT $4 OFFSET $5
+		) result
+	`, query, lang, owner.Array(), limit, offset).Scan(&result)
+	if err != nil {
+		return apiEmptyList, handleError(err)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,66 @@
+package psql
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/tidwall/gjson"
+	"github.com/wvh/uuid"
+)
+
+func TestSearchLanguageIsValid(t *testing.T) {
+	for _, lang := range []string{"", "fi", "sv", "en"} {
+		if !SearchLanguageIsValid(lang) {
+			t.Errorf("expected %q to be valid", lang)
+		}
+	}
+	for _, lang := range []string{"de", "FI", "finnish", "und"} {
+		if SearchLanguageIsValid(lang) {
+			t.Errorf("expected %q to be invalid", lang)
+		}
+	}
+}
+
+func TestSearchDatasetsByOwner(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode")
+	}
+
+	owner, err := uuid.NewUUID()
+	if err != nil {
+		t.Fatal("uuid:", err)
+	}
+
+	db, err := NewPoolServiceFromEnv()
+	if err != nil {
+		t.Fatal("psql:", err)
+	}
+
+	id := createDatasetFromFile(t, db, "unpublished.json", owner)
+	defer db.Delete(id, &owner)
+
+	var title map[string]string
+	if err := json.Unmarshal([]byte(gjson.GetBytes(readFile(t, "unpublished.json"), "research_dataset.title").Raw), &title); err != nil {
+		t.Fatal("title:", err)
+	}
+
+	for lang, text := range title {
+		var results []struct {
+			Id uuid.UUID `json:"id"`
+		}
+
+		res, err := db.SearchDatasetsByOwner(owner, text, "", 10, 0)
+		if err != nil {
+			t.Fatal("SearchDatasetsByOwner():", err)
+		}
+		if err := json.Unmarshal(res, &results); err != nil {
+			t.Fatal("json:", err)
+		}
+		if len(results) != 1 || results[0].Id != id {
+			t.Errorf("title (%s): expected to find dataset %s, got %s", lang, id, res)
+		}
+	}
+	if _, err := db.SearchDatasetsByOwner(owner, "data", "de", 10, 0); err != ErrInvalidLanguage {
+		t.Errorf("expected %v, got %v", ErrInvalidLanguage, err)
+	}
+}
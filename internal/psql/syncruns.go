@@ -0,0 +1,149 @@
+package psql
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/wvh/uuid"
+)
+
+// SyncRun is the record of one sync of a user's datasets from an external service.
+type SyncRun struct {
+	Id      string
+	Uid     uuid.UUID
+	Trigger string
+
+	// Since is the time from which changes were requested; zero for a full sync.
+	Since time.Time
+
+	Total      int
+	Read       int
+	Written    int
+	Skipped    int
+	Conflicts  int
+	Deprecated int
+	Removed    int
+
+	// Skips has the reasons for (the first) skipped records.
+	Skips []SyncSkip
+}
+
+// SyncSkip tells why an incoming record was not stored.
+type SyncSkip struct {
+	// Id is the Qvain id of the dataset, if known.
+	Id string `json:"id,omitempty"`
+
+	// Identifier is the identifier of the record in the external service, if known.
+	Identifier string `json:"identifier,omitempty"`
+
+	Reason string `json:"reason"`
+}
+
+// StartSyncRun records the start of a sync run.
+func (db *DB) StartSyncRun(run *SyncRun) error {
+	var since interface{}
+	if !run.Since.IsZero() {
+		since = run.Since
+	}
+
+	_, err := db.pool.Exec(`INSERT INTO sync_runs(id, uid, trigger, since) VALUES($1, $2, $3, $4)`, run.Id, run.Uid.Array(), run.Trigger, since)
+	return handleError(err)
+}
+
+// UpdateSyncRun records the counts of a sync run that is still running, so its progress can be followed.
+func (db *DB) UpdateSyncRun(run *SyncRun) error {
+	_, err := db.pool.Exec(`
+		UPDATE sync_runs SET total = $2, read = $3, written = $4, skipped = $5, conflicts = $6
+		WHERE id = $1 AND finished IS NULL
+	`, run.Id, run.Total, run.Read, run.Written, run.Skipped, run.Conflicts)
+	return handleError(err)
+}
+
+// FinishSyncRun records the counts and the error, if any, of a finished sync run.
+func (db *DB) FinishSyncRun(run *SyncRun, runErr error) error {
+	skips := run.Skips
+	if skips == nil {
+		skips = []SyncSkip{}
+	}
+	skipsJson, err := json.Marshal(skips)
+	if err != nil {
+		return err
+	}
+
+	var msg interface{}
+	if runErr != nil {
+		msg = runErr.Error()
+	}
+
+	_, err = db.pool.Exec(`
+		UPDATE sync_runs SET finished = now(), error = $2,
+			total = $3, read = $4, written = $5, skipped = $6, conflicts = $7, deprecated = $8, removed = $9, skips = $10
+		WHERE id = $1
+	`, run.Id, msg, run.Total, run.Read, run.Written, run.Skipped, run.Conflicts, run.Deprecated, run.Removed, skipsJson)
+	return handleError(err)
+}
+
+// ViewSyncRuns returns a (JSON) array with the latest sync runs of a user, newest first, without the skip reasons.
+func (db *DB) ViewSyncRuns(uid uuid.UUID, limit int) (json.RawMessage, error) {
+	var runs json.RawMessage
+
+	err := db.pool.QueryRow(`
+		SELECT coalesce(json_agg(result ORDER BY started DESC), '[]') "runs"
+		FROM (
+			SELECT id, trigger, `+syncRunStatus+` status, started, finished, since,
+				total, read, written, skipped, conflicts, deprecated, removed, error
+			FROM sync_runs
+			WHERE uid = $1
+			ORDER BY started DESC
+			LIMIT $2
+		) result
+	`, uid.Array(), limit).Scan(&runs)
+	if err != nil {
+		return nil, handleError(err)
+	}
+
+	return runs, nil
+}
+
+// LastSyncRun returns the id of the user's most recent sync run, or ErrNotFound if there is none.
+func (db *DB) LastSyncRun(uid uuid.UUID) (string, error) {
+	var id string
+	err := db.pool.QueryRow(`SELECT id FROM sync_runs WHERE uid = $1 ORDER BY started DESC LIMIT 1`, uid.Array()).Scan(&id)
+	if err != nil {
+		return "", handleError(err)
+	}
+	return id, nil
+}
+
+// ViewSyncRun returns a (JSON) object with a sync run of a user, including the skip reasons.
+func (db *DB) ViewSyncRun(uid uuid.UUID, id string) (json.RawMessage, error) {
+	var run json.RawMessage
+
+	err := db.pool.QueryRow(`
+		SELECT row_to_json(result) "run"
+		FROM (
+			SELECT id, trigger, `+syncRunStatus+` status, started, finished, since,
+				total, read, written, skipped, conflicts, deprecated, removed, error, skips
+			FROM sync_runs
+			WHERE id = $1 AND uid = $2
+		) result
+	`, id, uid.Array()).Scan(&run)
+	if err != nil {
+		return nil, handleError(err)
+	}
+
+	return run, nil
+}
+
+// PurgeSyncRuns deletes sync runs older than the given retention period.
+func (db *DB) PurgeSyncRuns(retention time.Duration) (int64, error) {
+	tag, err := db.pool.Exec(`DELETE FROM sync_runs WHERE started < $1`, time.Now().Add(-retention))
+	if err != nil {
+		return 0, handleError(err)
+	}
+
+	return tag.RowsAffected(), nil
+}
+
+// syncRunStatus is the SQL expression for the status of a sync run: running, success or failed.
+const syncRunStatus = `CASE WHEN finished IS NULL THEN 'running' WHEN error IS NULL THEN 'success' ELSE 'failed' END`
@@ -0,0 +1,71 @@
+package psql
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/wvh/uuid"
+)
+
+// DatasetTemplate is a template for new datasets stored in the database; see table `templates`.
+type DatasetTemplate struct {
+	Schema   string
+	Org      string
+	Template json.RawMessage
+}
+
+// Templates returns all dataset templates in the database.
+func (db *DB) Templates() ([]DatasetTemplate, error) {
+	rows, err := db.pool.Query("SELECT schema, org, template FROM templates ORDER BY schema, org")
+	if err != nil {
+		return nil, handleError(err)
+	}
+	defer rows.Close()
+
+	var templates []DatasetTemplate
+	for rows.Next() {
+		var (
+			t        DatasetTemplate
+			template []byte
+		)
+		if err := rows.Scan(&t.Schema, &t.Org, &template); err != nil {
+			return nil, handleError(err)
+		}
+		t.Template = template
+		templates = append(templates, t)
+	}
+
+	return templates, handleError(rows.Err())
+}
+
+// TemplatesStamp returns the number of dataset templates in the database and the time of the latest change;
+// any change to the templates changes at least one of them.
+func (db *DB) TemplatesStamp() (int, time.Time, error) {
+	var (
+		count   int
+		updated time.Time
+	)
+	err := db.pool.QueryRow("SELECT count(*), coalesce(max(updated), 'epoch') FROM templates").Scan(&count, &updated)
+	return count, updated, handleError(err)
+}
+
+// SetTemplate stores the template for a schema and organisation, replacing any existing one.
+func (db *DB) SetTemplate(schema string, org string, template json.RawMessage, uid uuid.UUID) error {
+	_, err := db.pool.Exec(`
+		INSERT INTO templates(schema, org, template, updated_by) VALUES($1, $2, $3::jsonb, $4)
+		ON CONFLICT (schema, org) DO UPDATE SET template = EXCLUDED.template, updated = now(), updated_by = EXCLUDED.updated_by
+	`, schema, org, string(template), uid.Array())
+	return handleError(err)
+}
+
+// DeleteTemplate removes the template for a schema and organisation from the database.
+func (db *DB) DeleteTemplate(schema string, org string) error {
+	tag, err := db.pool.Exec("DELETE FROM templates WHERE schema = $1 AND org = $2", schema, org)
+	if err != nil {
+		return handleError(err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,196 @@
+package psql
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/wvh/uuid"
+)
+
+// Transfer statuses; see table `dataset_transfers`.
+const (
+	TransferPending   = "pending"
+	TransferAccepted  = "accepted"
+	TransferDeclined  = "declined"
+	TransferCancelled = "cancelled"
+	TransferExpired   = "expired"
+)
+
+// CreateTransfer offers the ownership of a dataset to another user until the given expiry time.
+// Only the dataset's owner can do this, not collaborators with the owner role, who get ErrNotOwner.
+// There can only be one pending transfer per dataset; creating a second one returns ErrExists.
+func (db *DB) CreateTransfer(id uuid.UUID, owner uuid.UUID, recipient uuid.UUID, expires time.Time) error {
+	if recipient == owner {
+		return ErrSelfTransfer
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.CheckOwner(id, owner)
+	if err != nil {
+		return err
+	}
+
+	// accepting moves the dataset away from the sender, so the sender must be the owner
+	var primary bool
+	err = tx.QueryRow(`SELECT owner = $2 FROM datasets WHERE id = $1`, id.Array(), owner.Array()).Scan(&primary)
+	if err != nil {
+		return handleError(err)
+	}
+	if !primary {
+		return ErrNotOwner
+	}
+
+	// clear the way for a new transfer
+	_, err = tx.Exec(`UPDATE dataset_transfers SET status = $2 WHERE dataset = $1 AND status = $3 AND expires <= now()`, id.Array(), TransferExpired, TransferPending)
+	if err != nil {
+		return handleError(err)
+	}
+
+	_, err = tx.Exec(`INSERT INTO dataset_transfers(dataset, sender, recipient, expires) VALUES($1, $2, $3, $4)`,
+		id.Array(), owner.Array(), recipient.Array(), expires)
+	if err != nil {
+		return handleError(err)
+	}
+
+	return tx.Commit()
+}
+
+// ViewTransfer returns the pending transfer for a dataset; only the owner and the recipient can see it.
+func (db *DB) ViewTransfer(id uuid.UUID, uid uuid.UUID, svc string) (json.RawMessage, error) {
+	var transfer json.RawMessage
+
+	err := db.pool.QueryRow(`
+		SELECT row_to_json(result) "transfer"
+		FROM (
+			SELECT dataset, created, expires, status,
+				(SELECT extids->$3 FROM identities WHERE uid = sender) AS sender,
+				(SELECT extids->$3 FROM identities WHERE uid = recipient) AS recipient
+			FROM dataset_transfers
+			WHERE dataset = $1 AND status = 'pending' AND expires > now() AND (sender = $2 OR recipient = $2)
+		) result
+	`, id.Array(), uid.Array(), svc).Scan(&transfer)
+	if err != nil {
+		return nil, handleError(err)
+	}
+
+	return transfer, nil
+}
+
+// ViewIncomingTransfers returns a (JSON) array with the pending transfers offered to a user.
+func (db *DB) ViewIncomingTransfers(recipient uuid.UUID, svc string) (json.RawMessage, error) {
+	var transfers json.RawMessage
+
+	err := db.pool.QueryRow(`
+		SELECT coalesce(json_agg(result ORDER BY created DESC), '[]') "transfers"
+		FROM (
+			SELECT t.dataset, t.created, t.expires, t.status,
+				(SELECT extids->$2 FROM identities WHERE uid = t.sender) AS sender,
+				d.blob#>'{research_dataset,title}' title
+			FROM dataset_transfers t
+			JOIN datasets d ON d.id = t.dataset
+			WHERE t.recipient = $1 AND t.status = 'pending' AND t.expires > now() AND d.deleted IS NULL
+		) result
+	`, recipient.Array(), svc).Scan(&transfers)
+	if err != nil {
+		return apiEmptyList, handleError(err)
+	}
+
+	return transfers, nil
+}
+
+// CancelTransfer withdraws the pending transfer of a dataset.
+func (db *DB) CancelTransfer(id uuid.UUID, owner uuid.UUID) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.CheckOwner(id, owner)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.decideTransfer(id, nil, TransferCancelled)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// AcceptTransfer makes the recipient of a pending transfer the owner of the dataset.
+func (db *DB) AcceptTransfer(id uuid.UUID, recipient uuid.UUID) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	sender, err := tx.decideTransfer(id, &recipient, TransferAccepted)
+	if err != nil {
+		return err
+	}
+
+	// the dataset could have been deleted or changed hands since the transfer was created
+	ct, err := tx.Exec(`UPDATE datasets SET owner = $3 WHERE id = $1 AND owner = $2 AND deleted IS NULL`, id.Array(), sender.Array(), recipient.Array())
+	if err != nil {
+		return handleError(err)
+	}
+	if ct.RowsAffected() != 1 {
+		return ErrNotFound
+	}
+
+	// the new owner doesn't need a separate role
+	_, err = tx.Exec(`DELETE FROM dataset_acl WHERE dataset = $1 AND uid = $2`, id.Array(), recipient.Array())
+	if err != nil {
+		return handleError(err)
+	}
+
+	return tx.Commit()
+}
+
+// DeclineTransfer refuses a pending transfer.
+func (db *DB) DeclineTransfer(id uuid.UUID, recipient uuid.UUID) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.decideTransfer(id, &recipient, TransferDeclined)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// decideTransfer sets the final status of the pending transfer of a dataset and returns the sender.
+// If recipient is not nil, the transfer must be addressed to that user.
+func (tx *Tx) decideTransfer(id uuid.UUID, recipient *uuid.UUID, status string) (uuid.UUID, error) {
+	var (
+		sender uuid.UUID
+		to     interface{}
+	)
+
+	if recipient != nil {
+		to = recipient.Array()
+	}
+
+	err := tx.QueryRow(`
+		UPDATE dataset_transfers SET status = $3, decided = now()
+		WHERE dataset = $1 AND status = 'pending' AND expires > now() AND ($2::uuid IS NULL OR recipient = $2)
+		RETURNING sender
+	`, id.Array(), to, status).Scan(sender.Array())
+	if err != nil {
+		return sender, handleError(err)
+	}
+
+	return sender, nil
+}
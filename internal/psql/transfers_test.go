@@ -0,0 +1,63 @@
+package psql
+
+import (
+	"testing"
+	"time"
+
+	"github.com/NatLibFi/qvain-api/pkg/models"
+	"github.com/wvh/uuid"
+)
+
+// TestTransferByCollaborator checks that only the dataset's owner can offer it to another user, and that such a transfer can be accepted.
+func TestTransferByCollaborator(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode")
+	}
+
+	db, err := NewPoolServiceFromEnv()
+	if err != nil {
+		t.Fatal("psql:", err)
+	}
+
+	owner, collaborator, recipient := uuid.MustNewUUID(), uuid.MustNewUUID(), uuid.MustNewUUID()
+
+	dataset, err := models.NewDataset(owner)
+	if err != nil {
+		t.Fatal("models.NewDataset():", err)
+	}
+	dataset.SetData(1, "open test dataset", []byte(`{"title":"transfer test"}`))
+	if err := db.Create(dataset); err != nil {
+		t.Fatal("db.Create():", err)
+	}
+	defer db.Delete(dataset.Id, nil)
+
+	if err := db.SetCollaborator(dataset.Id, owner, collaborator, RoleOwner); err != nil {
+		t.Fatal("db.SetCollaborator():", err)
+	}
+
+	expires := time.Now().Add(time.Hour)
+
+	t.Run("collaborator", func(t *testing.T) {
+		err := db.CreateTransfer(dataset.Id, collaborator, recipient, expires)
+		if err != ErrNotOwner {
+			t.Fatalf("expected %v, got %v", ErrNotOwner, err)
+		}
+	})
+
+	t.Run("owner", func(t *testing.T) {
+		if err := db.CreateTransfer(dataset.Id, owner, recipient, expires); err != nil {
+			t.Fatal("db.CreateTransfer():", err)
+		}
+		if err := db.AcceptTransfer(dataset.Id, recipient); err != nil {
+			t.Fatal("db.AcceptTransfer():", err)
+		}
+
+		got, err := db.Get(dataset.Id)
+		if err != nil {
+			t.Fatal("db.Get():", err)
+		}
+		if got.Owner != recipient {
+			t.Errorf("expected owner %s, got %s", recipient, got.Owner)
+		}
+	})
+}
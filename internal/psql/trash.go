@@ -0,0 +1,71 @@
+package psql
+
+import (
+	"time"
+
+	"github.com/wvh/uuid"
+)
+
+// Trash moves a dataset to the trash bin if the owner matches.
+// Trashed datasets are hidden from views and lookups until they are restored or purged.
+func (db *DB) Trash(id uuid.UUID, owner uuid.UUID) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.CheckOwner(id, owner)
+	if err != nil {
+		return err
+	}
+
+	ct, err := tx.Exec("UPDATE datasets SET deleted = now() WHERE id = $1 AND deleted IS NULL", id.Array())
+	if err != nil {
+		return handleError(err)
+	}
+
+	if ct.RowsAffected() != 1 {
+		return ErrNotFound
+	}
+
+	return tx.Commit()
+}
+
+// RestoreFromTrash takes a dataset out of the trash bin if the owner matches.
+func (db *DB) RestoreFromTrash(id uuid.UUID, owner uuid.UUID) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.checkRole(id, owner, RoleOwner, true)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("UPDATE datasets SET deleted = NULL WHERE id = $1", id.Array())
+	if err != nil {
+		return handleError(err)
+	}
+
+	return tx.Commit()
+}
+
+// PurgeTrash permanently deletes datasets that have been in the trash bin for longer than the given retention period.
+// It returns the number of deleted datasets.
+func (db *DB) PurgeTrash(retention time.Duration) (int64, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	ct, err := tx.Exec("DELETE FROM datasets WHERE deleted < $1", time.Now().Add(-retention))
+	if err != nil {
+		return 0, handleError(err)
+	}
+
+	return ct.RowsAffected(), tx.Commit()
+}
@@ -0,0 +1,93 @@
+package psql
+
+import (
+	"encoding/json"
+
+	"github.com/wvh/uuid"
+)
+
+// Validator checks a dataset blob against the rules for its schema name.
+// It returns whether the blob is valid, a JSON array with the problems found and the version of the schema used.
+type Validator interface {
+	Validate(schema string, blob []byte) (bool, json.RawMessage, int, error)
+}
+
+// validate runs the configured validator on a dataset and stores the result in the `valid`, `validation` and `schema_version` columns.
+// It should be called in the same transaction right after a user has changed the dataset's blob.
+// If validation can't run, for instance because the validator doesn't know the schema, the dataset is marked invalid
+// with a single error telling why.
+func (tx *Tx) validate(id uuid.UUID) error {
+	if tx.validator == nil {
+		return nil
+	}
+
+	var (
+		schema *string
+		blob   []byte
+	)
+	err := tx.QueryRow("SELECT schema, blob FROM datasets WHERE id = $1", id.Array()).Scan(&schema, &blob)
+	if err != nil {
+		return err
+	}
+
+	var (
+		valid   bool
+		report  json.RawMessage
+		version int
+		ver     interface{}
+	)
+	if schema == nil {
+		report = validationNotRun("dataset has no schema")
+	} else {
+		valid, report, version, err = tx.validator.Validate(*schema, blob)
+		if err != nil {
+			tx.logger.Warn().Err(err).Str("id", id.String()).Str("schema", *schema).Msg("can't validate dataset")
+			valid, report = false, validationNotRun(err.Error())
+		} else {
+			ver = version
+		}
+	}
+
+	var errs interface{}
+	if report != nil {
+		errs = string(report)
+	}
+
+	_, err = tx.Exec("UPDATE datasets SET valid = $2, validation = $3::jsonb, schema_version = $4, validated = now() WHERE id = $1", id.Array(), valid, errs, ver)
+	return err
+}
+
+// validationNotRun returns a validation report with a single error saying why the dataset couldn't be validated.
+func validationNotRun(reason string) json.RawMessage {
+	report, _ := json.Marshal([]struct {
+		Pointer string `json:"pointer"`
+		Keyword string `json:"keyword"`
+		Message string `json:"message"`
+	}{{Pointer: "", Keyword: "schema", Message: "validation could not run: " + reason}})
+	return report
+}
+
+// ViewValidation returns the stored validation errors of a dataset as JSON array and whether it is valid.
+func (db *DB) ViewValidation(id uuid.UUID, uid uuid.UUID) (bool, json.RawMessage, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return false, nil, err
+	}
+	defer tx.Rollback()
+
+	err = tx.CheckAccess(id, uid, RoleViewer)
+	if err != nil {
+		return false, nil, err
+	}
+
+	var (
+		valid  bool
+		report json.RawMessage
+	)
+	err = tx.QueryRow("SELECT coalesce(valid, false), coalesce(validation, '[]') FROM datasets WHERE id = $1", id.Array()).Scan(&valid, &report)
+	if err != nil {
+		return false, nil, handleError(err)
+	}
+
+	return valid, report, nil
+}
@@ -0,0 +1,23 @@
+package psql
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestValidationNotRun(t *testing.T) {
+	var report []struct {
+		Pointer string `json:"pointer"`
+		Keyword string `json:"keyword"`
+		Message string `json:"message"`
+	}
+	if err := json.Unmarshal(validationNotRun("unknown schema"), &report); err != nil {
+		t.Fatal("unmarshal:", err)
+	}
+	if len(report) != 1 {
+		t.Fatalf("expected one error, got %d", len(report))
+	}
+	if report[0].Keyword != "schema" || report[0].Message != "validation could not run: unknown schema" {
+		t.Errorf("unexpected error: %+v", report[0])
+	}
+}
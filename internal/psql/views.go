@@ -1,7 +1,11 @@
 package psql
 
 import (
+	"bytes"
 	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/NatLibFi/qvain-api/pkg/models"
 	"github.com/wvh/uuid"
@@ -10,88 +14,179 @@ import (
 // apiEmptyList ensures an array is returned even if there are no results.
 var apiEmptyList = json.RawMessage([]byte(`[]`))
 
-// ViewDatasetsByOwner builds a JSON array with the datasets for a given owner.
+// ViewDatasetsByOwner builds a JSON array with the datasets for a given owner, excluding those in the trash bin.
 func (db *DB) ViewDatasetsByOwner(owner uuid.UUID) (json.RawMessage, error) {
-	var result json.RawMessage
+	result, _, err := db.ViewDatasetsByOwnerWithFilter(owner, &DatasetFilter{})
+	return result, err
+}
+
+// ViewDatasetsByOwnerWithFilter builds a JSON array with the datasets for a given owner matching the filter.
+// Datasets shared with the user through the dataset ACL are included; the role field tells them apart.
+// If the filter has a limit and there are more results, it also returns the cursor for the next page.
+func (db *DB) ViewDatasetsByOwnerWithFilter(owner uuid.UUID, filter *DatasetFilter) (json.RawMessage, *Cursor, error) {
+	sort, desc, err := filter.sortOrder()
+	if err != nil {
+		return apiEmptyList, nil, err
+	}
+	column := sortColumns[sort]
+
+	var (
+		where strings.Builder
+		args  = []interface{}{owner.Array(), filter.Trashed}
+	)
+
+	addArg := func(arg interface{}) string {
+		args = append(args, arg)
+		return "$" + strconv.Itoa(len(args))
+	}
 
-	// note that if there are no results, json_agg will return NULL;
-	// we could also catch NULLs by wrapping json_agg with coalesce: coalesce(json_agg(result), '[]')
-	err := db.pool.QueryRow(`
-		SELECT json_agg(result) "by_owner"
+	if filter.Project != "" {
+		where.WriteString("project = " + addArg(filter.Project) + " AND (deleted IS NOT NULL) = $2")
+	} else {
+		where.WriteString("(owner = $1 OR id IN (SELECT dataset FROM dataset_acl WHERE uid = $1)) AND (deleted IS NOT NULL) = $2")
+	}
+	if filter.Published != nil {
+		where.WriteString(" AND published = " + addArg(*filter.Published))
+	}
+	if filter.Schema != "" {
+		where.WriteString(" AND schema = " + addArg(filter.Schema))
+	}
+	if filter.Query != "" {
+		where.WriteString(" AND EXISTS (SELECT 1 FROM jsonb_each_text(blob#>'{research_dataset,title}') t WHERE t.value ILIKE '%' || " + addArg(escapeLike(filter.Query)) + " || '%')")
+	}
+
+	direction, comparison := "ASC", ">"
+	if desc {
+		direction, comparison = "DESC", "<"
+	}
+
+	if filter.Cursor != nil {
+		if filter.Cursor.Sort != sort {
+			return apiEmptyList, nil, ErrInvalidCursor
+		}
+		where.WriteString(" AND (" + column + ", id) " + comparison + " (" + addArg(filter.Cursor.Value) + ", " + addArg(filter.Cursor.Id.Array()) + ")")
+	}
+
+	limit := ""
+	if filter.Limit > 0 {
+		// get one extra row to know if there is a next page
+		limit = " LIMIT " + addArg(filter.Limit+1)
+	}
+
+	rows, err := db.pool.Query(`
+		SELECT result.`+column+`, result.id, row_to_json(result) "by_owner"
 		FROM (
-			SELECT id, owner, created, modified, seq, published,
+			SELECT id, owner, project, created, modified, deleted, seq, published, valid, removed_upstream, deprecated,
 				blob#>'{identifier}' identifier,
 				blob#>'{research_dataset,title}' title,
 				blob#>'{research_dataset,description}' description,
 				blob#>'{preservation_state}' preservation_state,
 				blob#>'{previous_dataset_version,identifier}' previous,
 				blob#>'{next_dataset_version,identifier}' "next",
-				jsonb_array_length(coalesce(blob#>'{dataset_version_set}', '[]')) versions
+				jsonb_array_length(coalesce(blob#>'{dataset_version_set}', '[]')) versions,
+				EXISTS(SELECT 1 FROM sync_conflicts c WHERE c.dataset = datasets.id AND c.resolved IS NULL) conflict,
+				CASE WHEN owner = $1 THEN 'owner' ELSE coalesce(
+					(SELECT role FROM dataset_acl WHERE dataset = id AND uid = $1 AND role <> 'viewer'),
+					CASE WHEN project IN (SELECT project FROM project_members WHERE uid = $1) THEN 'editor' END,
+					(SELECT role FROM dataset_acl WHERE dataset = id AND uid = $1)
+				) END "role"
 			FROM datasets
-			WHERE owner = $1
+			WHERE `+where.String()+`
 		) result
-	`, owner.Array()).Scan(&result)
+		ORDER BY result.`+column+` `+direction+`, result.id `+direction+limit, args...)
 	if err != nil {
-		return apiEmptyList, handleError(err)
+		return apiEmptyList, nil, handleError(err)
 	}
+	defer rows.Close()
 
-	// this shouldn't happen, result should be a literal null or array; return an error?
-	if len(result) < 1 {
-		return apiEmptyList, nil
+	var (
+		buf   bytes.Buffer
+		count int
+		next  *Cursor
+	)
+
+	buf.WriteByte('[')
+	for rows.Next() {
+		var (
+			value  time.Time
+			id     uuid.UUID
+			record json.RawMessage
+		)
+
+		if err := rows.Scan(&value, id.Array(), &record); err != nil {
+			return apiEmptyList, nil, handleError(err)
+		}
+
+		count++
+		if filter.Limit > 0 && count > filter.Limit {
+			// the extra row; the cursor points at the last row that was returned
+			break
+		}
+
+		if count > 1 {
+			buf.WriteByte(',')
+		}
+		buf.Write(record)
+		next = &Cursor{Sort: sort, Value: value, Id: id}
+	}
+	if rows.Err() != nil {
+		return apiEmptyList, nil, handleError(rows.Err())
 	}
+	buf.WriteByte(']')
 
-	// catch null when there are no results (or use coalesce in SQL);
-	// comment this out if NULL is preferred over empty list
-	if result[0] != '[' {
-		return apiEmptyList, nil
+	if filter.Limit == 0 || count <= filter.Limit {
+		next = nil
 	}
 
-	return result, nil
+	return buf.Bytes(), next, nil
 }
 
 // ViewVersions returns a (JSON) array with existing versions for a given dataset and owner.
 func (db *DB) ViewVersions(owner uuid.UUID, dataset uuid.UUID) (json.RawMessage, error) {
-	var (
-		isOwner   bool
-		jsonArray json.RawMessage
-	)
+	tx, err := db.Begin()
+	if err != nil {
+		return apiEmptyList, err
+	}
+	defer tx.Rollback()
 
-	err := db.pool.QueryRow(
-		`SELECT owner = $1 "is_owner", CASE WHEN owner = $1 AND jsonb_array_length(blob->'dataset_version_set') > 0 THEN blob->'dataset_version_set' ELSE '[]'::jsonb END versions FROM datasets WHERE id = $2`,
-		owner.Array(),
-		dataset.Array(),
-	).Scan(&isOwner, &jsonArray)
+	err = tx.CheckAccess(dataset, owner, RoleViewer)
 	if err != nil {
-		return apiEmptyList, handleError(err)
+		return apiEmptyList, err
 	}
 
-	if !isOwner {
-		return apiEmptyList, ErrNotOwner
+	var jsonArray json.RawMessage
+	err = tx.QueryRow(
+		`SELECT CASE WHEN jsonb_array_length(blob->'dataset_version_set') > 0 THEN blob->'dataset_version_set' ELSE '[]'::jsonb END versions FROM datasets WHERE id = $1`,
+		dataset.Array(),
+	).Scan(&jsonArray)
+	if err != nil {
+		return apiEmptyList, handleError(err)
 	}
 
 	return jsonArray, nil
 }
 
-func (db *DB) ViewDatasetWithOwner(id uuid.UUID, owner uuid.UUID, svc string) (json.RawMessage, error) {
+// ViewDatasetWithOwner returns the API view of a dataset and its sequence number if the owner matches.
+func (db *DB) ViewDatasetWithOwner(id uuid.UUID, owner uuid.UUID, svc string) (json.RawMessage, int, error) {
 	tx, err := db.Begin()
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 	defer tx.Rollback()
 
-	err = tx.CheckOwner(id, owner)
+	err = tx.CheckAccess(id, owner, RoleViewer)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 
 	famId, err := tx.getFamily(id)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 
 	family, err := models.LookupFamily(famId)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 
 	if family.IsPartial() {
@@ -100,50 +195,125 @@ func (db *DB) ViewDatasetWithOwner(id uuid.UUID, owner uuid.UUID, svc string) (j
 	return tx.viewDataset(id, "", svc)
 }
 
-func (tx *Tx) viewDataset(id uuid.UUID, key string, svc string) (json.RawMessage, error) {
+func (tx *Tx) viewDataset(id uuid.UUID, key string, svc string) (json.RawMessage, int, error) {
 	var (
 		record json.RawMessage
+		seq    int
 		err    error
 	)
 
 	// annoyingly similar...
 	if key == "" {
 		err = tx.QueryRow(`
-		SELECT row_to_json(result) "record"
+		SELECT result.seq, row_to_json(result) "record"
 		FROM (
-			SELECT id, created, modified, seq, synced, published,
+			SELECT id, created, modified, seq, synced, published, valid, validation, schema_version,
 				family AS type, schema, blob AS dataset,
 				(SELECT extids->$2 FROM identities WHERE uid = creator) AS creator,
-				(SELECT extids->$2 FROM identities WHERE uid = owner) AS owner
+				(SELECT extids->$2 FROM identities WHERE uid = owner) AS owner,
+				(SELECT extids->$2 FROM identities WHERE uid = (SELECT uid FROM dataset_revisions r WHERE r.id = datasets.id ORDER BY seq DESC LIMIT 1)) AS modified_by,
+				project
 			FROM datasets
 			WHERE id = $1) result
-		`, id.Array(), svc).Scan(&record)
+		`, id.Array(), svc).Scan(&seq, &record)
 	} else {
 		err = tx.QueryRow(`
-		SELECT row_to_json(result) "record"
+		SELECT result.seq, row_to_json(result) "record"
 		FROM (
-			SELECT id, created, modified, seq, synced, published,
+			SELECT id, created, modified, seq, synced, published, valid, validation, schema_version,
 				family AS type, schema, blob#>$2 AS dataset,
 				(SELECT extids->$3 FROM identities WHERE uid = creator) AS creator,
-				(SELECT extids->$3 FROM identities WHERE uid = owner) AS owner
+				(SELECT extids->$3 FROM identities WHERE uid = owner) AS owner,
+				(SELECT extids->$3 FROM identities WHERE uid = (SELECT uid FROM dataset_revisions r WHERE r.id = datasets.id ORDER BY seq DESC LIMIT 1)) AS modified_by,
+				project
 			FROM datasets
 			WHERE id = $1) result
-		`, id.Array(), []string{key}, svc).Scan(&record)
+		`, id.Array(), []string{key}, svc).Scan(&seq, &record)
 	}
 	if err != nil {
-		return nil, handleError(err)
+		return nil, 0, handleError(err)
 	}
 
-	return record, nil
+	return record, seq, nil
 }
 
+// ExportAsJson returns the complete database record of a dataset as JSON object, private keys and internal fields included.
+// It is meant for admin tools; the API uses ExportAsJsonWithOwner.
 func (db *DB) ExportAsJson(id uuid.UUID) (json.RawMessage, error) {
 	var dataset json.RawMessage
 
-	err := db.pool.QueryRow(`SELECT row_to_json(datasets) FROM datasets WHERE id = $1`, id.Array()).Scan(&dataset)
+	err := db.pool.QueryRow(`SELECT to_jsonb(datasets) - 'search' FROM datasets WHERE id = $1`, id.Array()).Scan(&dataset)
 	if err != nil {
 		return nil, handleError(err)
 	}
 
 	return dataset, nil
 }
+
+// ExportAsJsonWithOwner returns the export view of a dataset as JSON object if the user may view it; see exportDataset.
+func (db *DB) ExportAsJsonWithOwner(id uuid.UUID, owner uuid.UUID, svc string) (json.RawMessage, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	err = tx.CheckAccess(id, owner, RoleViewer)
+	if err != nil {
+		return nil, err
+	}
+
+	return tx.exportDataset(id, svc)
+}
+
+// exportDataset returns the API view of a dataset with all public keys of its blob,
+// where the normal view only has the first one for partial datasets.
+func (tx *Tx) exportDataset(id uuid.UUID, svc string) (json.RawMessage, error) {
+	famId, err := tx.getFamily(id)
+	if err != nil {
+		return nil, err
+	}
+
+	family, err := models.LookupFamily(famId)
+	if err != nil {
+		return nil, err
+	}
+
+	record, _, err := tx.viewDataset(id, "", svc)
+	if err != nil {
+		return nil, err
+	}
+	if !family.IsPartial() {
+		return record, nil
+	}
+
+	return filterPublicKeys(record, family)
+}
+
+// filterPublicKeys removes the keys of a view's dataset that can't be shown via API.
+func filterPublicKeys(record json.RawMessage, family *models.SchemaFamily) (json.RawMessage, error) {
+	var (
+		view    map[string]json.RawMessage
+		dataset map[string]json.RawMessage
+	)
+	if err := json.Unmarshal(record, &view); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(view["dataset"], &dataset); err != nil {
+		return nil, err
+	}
+
+	for key := range dataset {
+		if !family.IsPathPublic(key) {
+			delete(dataset, key)
+		}
+	}
+
+	filtered, err := json.Marshal(dataset)
+	if err != nil {
+		return nil, err
+	}
+	view["dataset"] = filtered
+
+	return json.Marshal(view)
+}
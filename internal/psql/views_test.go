@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"io/ioutil"
 	"path/filepath"
+	"reflect"
 	"testing"
 
 	"github.com/NatLibFi/qvain-api/pkg/metax"
@@ -129,3 +130,25 @@ func TestViewDatasetsByOwner(t *testing.T) {
 		})
 	}
 }
+
+func TestFilterPublicKeys(t *testing.T) {
+	family, err := models.LookupFamily(metax.MetaxDatasetFamily)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	record := `{"id":"x","owner":"someone","dataset":{"identifier":"urn:x","editor":{"owner_id":"y"},"research_dataset":{"title":{"en":"t"}},"contracts":[1]}}`
+	filtered, err := filterPublicKeys([]byte(record), family)
+	if err != nil {
+		t.Fatal("filterPublicKeys():", err)
+	}
+
+	var got, expected interface{}
+	if err := json.Unmarshal(filtered, &got); err != nil {
+		t.Fatal(err)
+	}
+	json.Unmarshal([]byte(`{"id":"x","owner":"someone","dataset":{"research_dataset":{"title":{"en":"t"}},"contracts":[1]}}`), &expected)
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+}
@@ -2,6 +2,7 @@ package shared
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
@@ -12,31 +13,92 @@ import (
 	"github.com/wvh/uuid"
 )
 
+// DefaultRequestTimeout is the time limit for syncs outside of jobs, such as from the command line; jobs are bound by their lease.
 const DefaultRequestTimeout = 15 * time.Second
+
 const RetryInterval = 10 * time.Second
 
-func Fetch(api *metax.MetaxService, db *psql.DB, logger zerolog.Logger, uid uuid.UUID, extid string) error {
+// SyncProgressInterval is how often the counts of a running sync are recorded.
+const SyncProgressInterval = 2 * time.Second
+
+// MaxSyncSkips is the maximum number of skipped datasets recorded with their reason per sync run.
+const MaxSyncSkips = 100
+
+// Sync triggers, recorded with each sync run.
+const (
+	TriggerLogin    = "login"
+	TriggerUser     = "user"
+	TriggerSchedule = "schedule"
+	TriggerCli      = "cli"
+)
+
+// ErrTooSoon is returned by Fetch if the user's datasets were synced less than RetryInterval ago.
+var ErrTooSoon = errors.New("too soon")
+
+func Fetch(ctx context.Context, api *metax.MetaxService, db *psql.DB, logger zerolog.Logger, uid uuid.UUID, extid string, trigger string) error {
 	last, err := db.GetLastSync(uid)
 	if err != nil && err != psql.ErrNotFound {
 		//fmt.Printf("%T %+v\n", err, err)
 		return err
 	} else if time.Now().Sub(last) < RetryInterval {
-		return fmt.Errorf("too soon")
+		return ErrTooSoon
 	}
 
-	return fetch(api, db, logger, uid, extid, last)
+	return fetch(ctx, api, db, logger, uid, extid, last, trigger)
 }
 
-func FetchSince(api *metax.MetaxService, db *psql.DB, logger zerolog.Logger, uid uuid.UUID, extid string, since time.Time) error {
-	return fetch(api, db, logger, uid, extid, since)
+func FetchSince(ctx context.Context, api *metax.MetaxService, db *psql.DB, logger zerolog.Logger, uid uuid.UUID, extid string, since time.Time, trigger string) error {
+	return fetch(ctx, api, db, logger, uid, extid, since, trigger)
 }
 
-func FetchAll(api *metax.MetaxService, db *psql.DB, logger zerolog.Logger, uid uuid.UUID, extid string) error {
-	return fetch(api, db, logger, uid, extid, time.Time{})
+func FetchAll(ctx context.Context, api *metax.MetaxService, db *psql.DB, logger zerolog.Logger, uid uuid.UUID, extid string, trigger string) error {
+	return fetch(ctx, api, db, logger, uid, extid, time.Time{}, trigger)
 }
 
-func fetch(api *metax.MetaxService, db *psql.DB, logger zerolog.Logger, uid uuid.UUID, extid string, since time.Time) error {
+// fetch syncs the datasets of a user from Metax and records the outcome of the attempt.
+func fetch(ctx context.Context, api *metax.MetaxService, db *psql.DB, logger zerolog.Logger, uid uuid.UUID, extid string, since time.Time, trigger string) error {
+	run := &psql.SyncRun{Id: xid.New().String(), Uid: uid, Trigger: trigger, Since: since}
+	if err := db.StartSyncRun(run); err != nil {
+		logger.Warn().Err(err).Str("user", uid.String()).Msg("can't record sync run")
+	}
+
+	start := time.Now()
+	err := syncDatasets(ctx, api, db, logger, run, extid)
+
+	msg := summary(run)
+	if err != nil {
+		msg = err.Error()
+
+		// the batch was rolled back, so nothing counted as stored was
+		run.Written, run.Conflicts, run.Deprecated, run.Removed = 0, 0, 0, 0
+	}
+	if rerr := db.FinishSyncRun(run, err); rerr != nil {
+		logger.Warn().Err(rerr).Str("sync-id", run.Id).Msg("can't record sync run")
+	}
+	if rerr := db.SetSyncResult(uid, err == nil, msg, time.Since(start)); rerr != nil {
+		logger.Warn().Err(rerr).Str("user", uid.String()).Msg("can't record sync result")
+	}
+	return err
+}
+
+// summary describes the result of a successful sync run.
+func summary(run *psql.SyncRun) string {
+	return fmt.Sprintf("read %d of %d, written %d, skipped %d, conflicts %d, deprecated %d, removed %d",
+		run.Read, run.Total, run.Written, run.Skipped, run.Conflicts, run.Deprecated, run.Removed)
+}
+
+// skip counts an incoming record that was not stored, recording the reason for the first MaxSyncSkips records.
+func skip(run *psql.SyncRun, id string, identifier string, reason string) {
+	run.Skipped++
+	if len(run.Skips) < MaxSyncSkips {
+		run.Skips = append(run.Skips, psql.SyncSkip{Id: id, Identifier: identifier, Reason: reason})
+	}
+}
+
+// syncDatasets syncs the datasets of a user from Metax, counting the results in run.
+func syncDatasets(ctx context.Context, api *metax.MetaxService, db *psql.DB, logger zerolog.Logger, run *psql.SyncRun, extid string) error {
 	var params []metax.DatasetOption
+	uid, since := run.Uid, run.Since
 
 	// build query options
 	if extid == "" {
@@ -58,9 +120,6 @@ func fetch(api *metax.MetaxService, db *psql.DB, logger zerolog.Logger, uid uuid
 	}
 	defer batch.Rollback()
 
-	ctx, cancel := context.WithTimeout(context.Background(), DefaultRequestTimeout)
-	defer cancel()
-
 	// make API request
 	total, c, errc, err := api.ReadStreamChannel(ctx, params...)
 	if err != nil {
@@ -70,13 +129,29 @@ func fetch(api *metax.MetaxService, db *psql.DB, logger zerolog.Logger, uid uuid
 	//logger.Info().Str("user", uid.String()).Str("identity", extid).Int("count", total).Msg("starting sync with metax")
 
 	// create sub-logger to correlate possibly multiple log entries
-	syncLogger := logger.With().Str("sync-id", xid.New().String()).Logger()
-	syncLogger.Info().Str("user", uid.String()).Str("identity", extid).Int("total", total).Msg("starting sync")
+	syncLogger := logger.With().Str("sync-id", run.Id).Logger()
+	syncLogger.Info().Str("user", uid.String()).Str("identity", extid).Str("trigger", run.Trigger).Int("total", total).Msg("starting sync")
 
-	read := 0
-	written := 0
+	run.Total = total
 	success := false
 
+	// record progress now and then; the counts of stored datasets only hold if the batch is committed in the end
+	lastProgress := time.Now()
+	progress := func() {
+		if time.Since(lastProgress) < SyncProgressInterval {
+			return
+		}
+		lastProgress = time.Now()
+		if err := db.UpdateSyncRun(run); err != nil {
+			syncLogger.Debug().Err(err).Msg("can't record sync progress")
+		}
+	}
+	if err := db.UpdateSyncRun(run); err != nil {
+		syncLogger.Debug().Err(err).Msg("can't record sync progress")
+	}
+
+	var seen, deprecated []string
+
 	// loop until all read, error or timeout
 Done:
 	for {
@@ -87,58 +162,85 @@ Done:
 				break Done
 			}
 
-			read++
+			run.Read++
+			progress()
+
+			// remember what Metax has, to find datasets removed or deprecated upstream
+			identifier := metax.GetIdentifier(fdDataset.RawMessage)
+			if identifier != "" {
+				seen = append(seen, identifier)
+				if metax.IsDeprecated(fdDataset.RawMessage) {
+					deprecated = append(deprecated, identifier)
+				}
+			}
 
 			dataset, isNew, err := fdDataset.ToQvain()
 			if err != nil {
-				syncLogger.Debug().Err(err).Int("read", read).Msg("error parsing dataset, skipping")
+				syncLogger.Debug().Err(err).Int("read", run.Read).Msg("error parsing dataset, skipping")
+				skip(run, "", identifier, "invalid record: "+err.Error())
 				continue
 			}
 
-			if isNew {
-				// create new id
-				dataset.Id, err = uuid.NewUUID()
-				if err != nil {
-					return err
-				}
+			// only used if the dataset isn't known locally: inject current user for datasets created externally
+			dataset.Creator = uid
+			dataset.Owner = uid
 
-				// inject current user for datasets created externally
-				dataset.Creator = uid
-				dataset.Owner = uid
+			// it comes from upstream, so I guess it's "published" and "valid"
+			dataset.Published = true
+			dataset.SetValid(true)
 
-				// it comes from upstream, so I guess it's "published" and "valid"
-				dataset.Published = true
-				dataset.SetValid(true)
-
-				if err = batch.CreateWithMetadata(dataset); err != nil {
-					syncLogger.Debug().Err(err).Int("read", read).Str("id", dataset.Id.String()).Msg("can't store dataset")
-					continue
-				}
-			} else {
-				if err = batch.Update(dataset.Id, dataset.Blob()); err != nil {
-					syncLogger.Debug().Err(err).Int("read", read).Str("id", dataset.Id.String()).Msg("can't update dataset")
-					continue
-				}
+			// find by Qvain id or Metax identifier, so syncing again doesn't create duplicates
+			result, err := batch.Upsert(dataset, metax.GetDateModified(dataset.Blob()))
+			if err == psql.ErrBatchAborted {
+				syncLogger.Info().Err(err).Int("read", run.Read).Str("id", dataset.Id.String()).Msg("can't go on with sync")
+				return err
+			}
+			if err != nil {
+				syncLogger.Debug().Err(err).Int("read", run.Read).Str("id", dataset.Id.String()).Msg("can't store dataset")
+				skip(run, dataset.Id.String(), identifier, "can't store dataset: "+err.Error())
+				continue
 			}
-			syncLogger.Debug().Bool("new", isNew).Str("id", dataset.Id.String()).Msg("batched dataset")
-			written++
+			switch result {
+			case psql.SyncSkipped:
+				syncLogger.Debug().Str("id", dataset.Id.String()).Msg("dataset edited locally, skipping")
+				skip(run, dataset.Id.String(), identifier, "edited locally")
+				continue
+			case psql.SyncConflict:
+				syncLogger.Info().Str("id", dataset.Id.String()).Msg("dataset edited locally and in metax, stored conflict")
+				run.Conflicts++
+				skip(run, dataset.Id.String(), identifier, "edited locally and in Metax, see conflict")
+				continue
+			case psql.SyncTrashed:
+				syncLogger.Debug().Str("id", dataset.Id.String()).Msg("dataset in trash, skipping")
+				skip(run, dataset.Id.String(), identifier, "dataset is in the trash")
+				continue
+			}
+			syncLogger.Debug().Bool("new", result == psql.SyncCreated).Bool("linked", !isNew).Str("id", dataset.Id.String()).Msg("batched dataset")
+			run.Written++
 		case err := <-errc:
 			// error while streaming
 			syncLogger.Info().Err(err).Msg("api error")
 			return err
 		case <-ctx.Done():
-			// timeout
-			syncLogger.Info().Err(ctx.Err()).Msg("api timeout")
-			return err
+			// timeout or cancelled job
+			syncLogger.Info().Err(ctx.Err()).Msg("sync stopped")
+			return ctx.Err()
 		}
 	}
 	if success {
+		// only a sync without time limit sees all of the user's datasets
+		run.Deprecated = len(deprecated)
+		run.Removed, err = batch.MarkUpstream(extid, seen, deprecated, since.IsZero())
+		if err != nil {
+			syncLogger.Info().Err(err).Msg("can't mark datasets removed upstream")
+			return err
+		}
 		err = batch.Commit()
 	}
 	if err != nil {
 		return err
 	}
 
-	syncLogger.Info().Int("total", total).Int("written", written).Msg("successful sync")
+	syncLogger.Info().Int("total", total).Int("written", run.Written).Int("skipped", run.Skipped).Int("conflicts", run.Conflicts).Int("deprecated", run.Deprecated).Int("removed", run.Removed).Msg("successful sync")
 	return nil
 }
@@ -0,0 +1,212 @@
+package shared
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/NatLibFi/qvain-api/internal/psql"
+	"github.com/NatLibFi/qvain-api/pkg/metax"
+	"github.com/NatLibFi/qvain-api/pkg/models"
+	"github.com/tidwall/gjson"
+	"github.com/wvh/uuid"
+)
+
+// MaxImportRecords is the maximum number of datasets that can be imported at once.
+const MaxImportRecords = 1000
+
+var (
+	// ErrTooManyRecords means the import contains more than MaxImportRecords datasets.
+	ErrTooManyRecords = errors.New("too many records, maximum is " + strconv.Itoa(MaxImportRecords))
+
+	// ErrNothingToImport means the import didn't contain any datasets.
+	ErrNothingToImport = errors.New("nothing to import")
+
+	// ErrTruncatedImport means a JSON array of datasets wasn't closed.
+	ErrTruncatedImport = errors.New("unexpected end of input: array not closed")
+)
+
+// ImportResult is the outcome of importing a single dataset.
+type ImportResult struct {
+	// Line is the line number in newline-delimited input, or the position of the dataset in a JSON array.
+	Line  int        `json:"line"`
+	Id    *uuid.UUID `json:"id,omitempty"`
+	Error string     `json:"error,omitempty"`
+}
+
+// ImportReport lists the outcome of an import for each dataset in the input.
+type ImportReport struct {
+	Imported int            `json:"imported"`
+	Failed   int            `json:"failed"`
+	Results  []ImportResult `json:"results"`
+}
+
+// Import reads datasets from newline-delimited JSON or a JSON array and stores the valid ones as new datasets for the creator.
+//
+// Each dataset is either in the format of the web API, with `type`, `schema` and `dataset` keys, or a Metax record
+// as found in Metax dumps, of which only the `research_dataset` is kept; the schema of Metax records is derived from
+// their data catalog, or set to defaultSchema if the catalog is unknown.
+//
+// The datasets are stored in one transaction, so either all valid datasets are imported or none are;
+// an error is returned only if nothing could be stored, otherwise the report lists the datasets that were rejected.
+func Import(db *psql.DB, r io.Reader, creator uuid.UUID, inject map[string]string, defaultSchema string) (*ImportReport, error) {
+	var (
+		report   = &ImportReport{Results: []ImportResult{}}
+		datasets []*models.Dataset
+	)
+
+	err := readImport(r, func(line int, raw []byte) error {
+		if len(datasets)+report.Failed >= MaxImportRecords {
+			return ErrTooManyRecords
+		}
+
+		typed, err := importRecord(raw, creator, inject, defaultSchema)
+		if err != nil {
+			report.Failed++
+			report.Results = append(report.Results, ImportResult{Line: line, Error: err.Error()})
+			return nil
+		}
+
+		dataset := typed.Unwrap()
+		datasets = append(datasets, dataset)
+		report.Results = append(report.Results, ImportResult{Line: line, Id: &dataset.Id})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(report.Results) == 0 {
+		return nil, ErrNothingToImport
+	}
+
+	if len(datasets) > 0 {
+		if err := db.BatchStore(datasets); err != nil {
+			return nil, err
+		}
+	}
+	report.Imported = len(datasets)
+
+	return report, nil
+}
+
+// readImport calls fn for every dataset in the input, which is either a JSON array or newline-delimited JSON.
+// Blank lines are skipped; in newline-delimited input, fn gets lines with invalid JSON to report them.
+// An array can't be read past invalid JSON, so that fails the whole import, as do read errors.
+func readImport(r io.Reader, fn func(line int, raw []byte) error) error {
+	br := bufio.NewReader(r)
+
+	// peek at the first non-whitespace character to find out if this is an array
+	for {
+		c, err := br.Peek(1)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if c[0] != ' ' && c[0] != '\t' && c[0] != '\r' && c[0] != '\n' {
+			break
+		}
+		br.ReadByte()
+	}
+
+	if c, _ := br.Peek(1); c[0] == '[' {
+		return readArray(br, fn)
+	}
+	return readLines(br, fn)
+}
+
+// readArray reads the elements of a JSON array.
+func readArray(r io.Reader, fn func(int, []byte) error) error {
+	dec := json.NewDecoder(r)
+	if _, err := dec.Token(); err != nil {
+		return err
+	}
+
+	for i := 1; dec.More(); i++ {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return arrayError(i, err)
+		}
+		if err := fn(i, raw); err != nil {
+			return err
+		}
+	}
+
+	// a truncated array ends without closing bracket
+	if _, err := dec.Token(); err != nil {
+		return arrayError(0, err)
+	}
+
+	return nil
+}
+
+// arrayError describes a syntax error at the given position in an array, or passes read errors through.
+func arrayError(i int, err error) error {
+	if _, ok := err.(*json.SyntaxError); !ok && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return err
+	}
+	if i == 0 {
+		return ErrTruncatedImport
+	}
+	return fmt.Errorf("invalid JSON in dataset %d: %s", i, err)
+}
+
+// readLines reads newline-delimited JSON.
+func readLines(r *bufio.Reader, fn func(int, []byte) error) error {
+	for line := 1; ; line++ {
+		raw, err := r.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+
+		if trimmed := bytes.TrimSpace(raw); len(trimmed) > 0 {
+			if ferr := fn(line, trimmed); ferr != nil {
+				return ferr
+			}
+		}
+
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
+
+// importRecord creates a typed dataset from a web API dataset or a Metax record.
+func importRecord(raw []byte, creator uuid.UUID, inject map[string]string, defaultSchema string) (models.TypedDataset, error) {
+	if !json.Valid(raw) {
+		return nil, psql.ErrInvalidJson
+	}
+
+	parsed := gjson.ParseBytes(raw)
+	if !parsed.IsObject() {
+		return nil, psql.ErrInvalidJson
+	}
+
+	if research := parsed.Get("research_dataset"); research.Exists() && !parsed.Get("type").Exists() {
+		schema := metax.SchemaForCatalog(parsed.Get("data_catalog.identifier").String())
+		if schema == "" {
+			schema = metax.SchemaForCatalog(parsed.Get("data_catalog").String())
+		}
+		if schema == "" {
+			schema = defaultSchema
+		}
+
+		wrapped, err := json.Marshal(map[string]interface{}{
+			"type":    metax.MetaxDatasetFamily,
+			"schema":  schema,
+			"dataset": json.RawMessage(research.Raw),
+		})
+		if err != nil {
+			return nil, err
+		}
+		raw = wrapped
+	}
+
+	return models.CreateDatasetFromJson(creator, bytes.NewReader(raw), inject)
+}
@@ -0,0 +1,124 @@
+package shared
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/NatLibFi/qvain-api/pkg/metax"
+	"github.com/tidwall/gjson"
+)
+
+func TestReadImport(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		lines []int
+	}{
+		{name: "empty", input: "", lines: nil},
+		{name: "ndjson", input: "{\"a\":1}\n\n{\"a\":2}\r\n  {\"a\":3}", lines: []int{1, 3, 4}},
+		{name: "ndjson with invalid line", input: "{\"a\":1}\n{\"a\":\n{\"a\":3}\n", lines: []int{1, 2, 3}},
+		{name: "array", input: "  [{\"a\":1}, {\"a\":2}]", lines: []int{1, 2}},
+		{name: "empty array", input: "[]", lines: nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var lines []int
+			err := readImport(strings.NewReader(test.input), func(line int, raw []byte) error {
+				lines = append(lines, line)
+				return nil
+			})
+			if err != nil {
+				t.Fatal("readImport():", err)
+			}
+			if len(lines) != len(test.lines) {
+				t.Fatalf("expected lines %v, got %v", test.lines, lines)
+			}
+			for i := range lines {
+				if lines[i] != test.lines[i] {
+					t.Errorf("expected lines %v, got %v", test.lines, lines)
+				}
+			}
+		})
+	}
+}
+
+func TestReadImportBrokenArray(t *testing.T) {
+	for name, input := range map[string]string{
+		"syntax error": `[{"a":1}, {"a":}, {"a":3}]`,
+		"truncated":    `[{"a":1}, {"a":2`,
+		"not closed":   `[{"a":1}, {"a":2},`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			err := readImport(strings.NewReader(input), func(line int, raw []byte) error {
+				return nil
+			})
+			if err == nil {
+				t.Error("expected error for broken array")
+			}
+		})
+	}
+
+	t.Run("read error", func(t *testing.T) {
+		tooLarge := errors.New("http: request body too large")
+		r := io.MultiReader(strings.NewReader(`[{"a":1}, `), &errReader{tooLarge})
+		err := readImport(r, func(line int, raw []byte) error {
+			return nil
+		})
+		if err != tooLarge {
+			t.Errorf("expected %v, got %v", tooLarge, err)
+		}
+	})
+}
+
+// errReader fails every read with its error.
+type errReader struct {
+	err error
+}
+
+func (r *errReader) Read(p []byte) (int, error) {
+	return 0, r.err
+}
+
+func TestImportRecord(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		schema  string
+		invalid bool
+	}{
+		{name: "api format", raw: `{"type":2,"schema":"metax-att","dataset":{"title":{"en":"api"}}}`, schema: metax.SchemaAtt},
+		{name: "metax record", raw: `{"identifier":"x","data_catalog":{"identifier":"urn:nbn:fi:att:data-catalog-att"},"research_dataset":{"title":{"en":"dump"}}}`, schema: metax.SchemaAtt},
+		{name: "metax record with default schema", raw: `{"research_dataset":{"title":{"en":"dump"}}}`, schema: metax.SchemaIda},
+		{name: "missing type", raw: `{"schema":"metax-ida","dataset":{}}`, invalid: true},
+		{name: "existing id", raw: `{"id":"053bffbcc41edad4853bea91fc42ea18","type":2,"schema":"metax-ida","dataset":{}}`, invalid: true},
+		{name: "unknown schema", raw: `{"type":2,"schema":"nope","dataset":{}}`, invalid: true},
+		{name: "not an object", raw: `[1,2]`, invalid: true},
+		{name: "invalid json", raw: `{"type":`, invalid: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			typed, err := importRecord([]byte(test.raw), owner, nil, metax.SchemaIda)
+			if test.invalid {
+				if err == nil {
+					t.Error("expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal("importRecord():", err)
+			}
+
+			dataset := typed.Unwrap()
+			if dataset.Schema() != test.schema {
+				t.Errorf("expected schema %s, got %s", test.schema, dataset.Schema())
+			}
+			if !gjson.GetBytes(dataset.Blob(), "research_dataset.title.en").Exists() {
+				t.Errorf("research dataset missing from blob: %s", dataset.Blob())
+			}
+		})
+	}
+}
@@ -0,0 +1,147 @@
+package shared
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/NatLibFi/qvain-api/internal/jobs"
+	"github.com/NatLibFi/qvain-api/internal/psql"
+	"github.com/NatLibFi/qvain-api/pkg/metax"
+	"github.com/rs/zerolog"
+	"github.com/wvh/uuid"
+)
+
+// Job kinds run by the handlers in this package.
+const (
+	JobSync    = "sync"
+	JobPublish = "publish"
+)
+
+// SyncJob is the payload of a sync job.
+type SyncJob struct {
+	Uid      uuid.UUID `json:"uid"`
+	Identity string    `json:"identity"`
+	Trigger  string    `json:"trigger"`
+
+	// All requests all datasets; otherwise Since, if set, requests changes since that time and else since the last successful sync.
+	All   bool       `json:"all,omitempty"`
+	Since *time.Time `json:"since,omitempty"`
+}
+
+// PublishJob is the payload of a publish job; Owner is the user publishing and Service the service of the owner identity set in Metax.
+type PublishJob struct {
+	Id      uuid.UUID `json:"id"`
+	Owner   uuid.UUID `json:"owner"`
+	Service string    `json:"service"`
+}
+
+// PublishResult is the result of a publish job. Failed jobs have Status, Origin and Payload set if Metax refused the dataset.
+type PublishResult struct {
+	Id       uuid.UUID  `json:"id"`
+	Extid    string     `json:"extid,omitempty"`
+	NewId    *uuid.UUID `json:"new_id,omitempty"`
+	NewExtid string     `json:"new_extid,omitempty"`
+
+	Status  int             `json:"status,omitempty"`
+	Origin  string          `json:"origin,omitempty"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// SyncResult is the result of a sync job that didn't run because the user's datasets were synced less than RetryInterval ago.
+type SyncResult struct {
+	Skipped bool   `json:"skipped"`
+	LastRun string `json:"last_run,omitempty"`
+}
+
+// RegisterJobs sets the handlers for sync and publish jobs.
+func RegisterJobs(queue *jobs.Queue, api *metax.MetaxService, db *psql.DB, logger zerolog.Logger) {
+	queue.Register(JobSync, func(ctx context.Context, job *psql.Job) (interface{}, error) {
+		var req SyncJob
+		if err := json.Unmarshal(job.Payload, &req); err != nil {
+			return nil, jobs.Permanent(err)
+		}
+
+		var err error
+		switch {
+		case req.All:
+			err = FetchAll(ctx, api, db, logger, req.Uid, req.Identity, req.Trigger)
+		case req.Since != nil:
+			err = FetchSince(ctx, api, db, logger, req.Uid, req.Identity, *req.Since, req.Trigger)
+		default:
+			err = Fetch(ctx, api, db, logger, req.Uid, req.Identity, req.Trigger)
+		}
+		if err == ErrTooSoon {
+			// someone else just did the work; tell where to find it
+			result := &SyncResult{Skipped: true}
+			if result.LastRun, err = db.LastSyncRun(req.Uid); err != nil && err != psql.ErrNotFound {
+				logger.Warn().Err(err).Str("user", req.Uid.String()).Msg("can't get last sync run")
+			}
+			return result, nil
+		}
+		return nil, err
+	})
+
+	queue.Register(JobPublish, func(ctx context.Context, job *psql.Job) (interface{}, error) {
+		var req PublishJob
+		if err := json.Unmarshal(job.Payload, &req); err != nil {
+			return nil, jobs.Permanent(err)
+		}
+
+		result := &PublishResult{Id: req.Id}
+		extid, newExtid, newId, err := Publish(ctx, api, db, req.Id, req.Owner, req.Service)
+		if err != nil {
+			return result, publishError(err, result)
+		}
+
+		result.Extid, result.NewId, result.NewExtid = extid, newId, newExtid
+		return result, nil
+	})
+}
+
+// publishError decides if a failed publish can be retried, recording Metax errors in the result.
+// A create that Metax may have received is never retried, as that could publish the dataset twice.
+func publishError(err error, result *PublishResult) error {
+	switch t := err.(type) {
+	case *CreateError:
+		publishError(t.Err, result)
+		return jobs.Permanent(err)
+	case *metax.ApiError:
+		result.Status, result.Origin, result.Payload = t.StatusCode(), "metax", t.OriginalError()
+		// Metax refused the dataset; trying again won't help
+		if t.StatusCode() >= 400 && t.StatusCode() < 500 && t.StatusCode() != 429 {
+			return jobs.Permanent(err)
+		}
+	case *psql.DatabaseError:
+		switch err {
+		case psql.ErrTemporary, psql.ErrTimeout, psql.ErrConnection:
+		default:
+			return jobs.Permanent(err)
+		}
+	}
+	return err
+}
+
+// EnqueueFetch queues a sync of a user's datasets since the last successful sync; there is at most one such sync per user in the queue.
+func EnqueueFetch(queue *jobs.Queue, uid uuid.UUID, extid string, trigger string) (int64, error) {
+	return queue.Enqueue(JobSync, "sync:"+uid.String(), &uid, &SyncJob{Uid: uid, Identity: extid, Trigger: trigger})
+}
+
+// EnqueueFetchSince queues a sync of a user's datasets changed since the given time, to run at the given time.
+func EnqueueFetchSince(queue *jobs.Queue, uid uuid.UUID, extid string, since time.Time, trigger string, at time.Time) (int64, error) {
+	req := &SyncJob{Uid: uid, Identity: extid, Trigger: trigger}
+	if !since.IsZero() {
+		req.Since = &since
+	}
+	return queue.EnqueueAt(JobSync, "sync:"+uid.String(), &uid, req, at)
+}
+
+// EnqueueFetchAll queues a sync of all of a user's datasets.
+func EnqueueFetchAll(queue *jobs.Queue, uid uuid.UUID, extid string, trigger string) (int64, error) {
+	return queue.Enqueue(JobSync, "sync-all:"+uid.String(), &uid, &SyncJob{Uid: uid, Identity: extid, Trigger: trigger, All: true})
+}
+
+// EnqueuePublish queues publishing a dataset to Metax; there is at most one unfinished publish per dataset in the queue.
+func EnqueuePublish(queue *jobs.Queue, id uuid.UUID, owner uuid.UUID, svc string) (int64, error) {
+	return queue.Enqueue(JobPublish, "publish:"+id.String(), &owner, &PublishJob{Id: id, Owner: owner, Service: svc})
+}
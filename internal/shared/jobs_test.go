@@ -0,0 +1,37 @@
+package shared
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/NatLibFi/qvain-api/internal/jobs"
+	"github.com/NatLibFi/qvain-api/internal/psql"
+	"github.com/NatLibFi/qvain-api/pkg/metax"
+)
+
+// TestPublishError checks that a failed create that Metax may have received is not retried.
+func TestPublishError(t *testing.T) {
+	reset := errors.New("connection reset by peer")
+
+	var tests = []struct {
+		name      string
+		err       error
+		permanent bool
+	}{
+		{name: "update network error", err: maybeCreated(false, reset), permanent: false},
+		{name: "update database error", err: maybeCreated(false, psql.ErrConnection), permanent: false},
+		{name: "create network error", err: maybeCreated(true, reset), permanent: true},
+		{name: "create database error", err: maybeCreated(true, psql.ErrConnection), permanent: true},
+		{name: "create no identifier", err: maybeCreated(true, ErrNoIdentifier), permanent: true},
+		{name: "create not sent", err: maybeCreated(true, metax.ErrCircuitOpen), permanent: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := publishError(test.err, &PublishResult{})
+			if jobs.IsPermanent(err) != test.permanent {
+				t.Errorf("expected permanent %v for %v", test.permanent, test.err)
+			}
+		})
+	}
+}
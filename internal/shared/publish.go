@@ -17,10 +17,36 @@ var (
 	ErrNoIdentifier = errors.New("no identifier in dataset")
 )
 
+// CreateError is returned by Publish if creating a new dataset in Metax failed after Metax may have stored it.
+// Publishing again would create a duplicate; a sync links the created record to the Qvain dataset through its `editor.record_id` instead.
+type CreateError struct {
+	Err error
+}
+
+func (e *CreateError) Error() string {
+	return "dataset may have been created in Metax, sync before publishing again: " + e.Err.Error()
+}
+
+func (e *CreateError) Unwrap() error {
+	return e.Err
+}
+
+// maybeCreated wraps err in a CreateError if the failed publish was a create that Metax may have received.
+func maybeCreated(create bool, err error) error {
+	if !create || metax.NotReceived(err) {
+		return err
+	}
+	return &CreateError{Err: err}
+}
+
 // Publish stores a dataset in Metax and updates the Qvain database.
+// If svc is not empty, the identity in that service of the dataset's owner – not of the user publishing, who may be a collaborator –
+// is set as the dataset's metadata provider user, so Metax follows ownership changes in Qvain.
 // It returns the Metax identifier for the dataset, the new version idenifier if such was created, and an error.
-// The error returned can be a Metax ApiError, a Qvain database error, or a basic Go error.
-func Publish(api *metax.MetaxService, db *psql.DB, id uuid.UUID, owner uuid.UUID) (versionId string, newVersionId string, newQVersionId *uuid.UUID, err error) {
+// The Metax requests are bound to ctx.
+// The error returned can be a Metax ApiError, a Qvain database error, a basic Go error,
+// or a CreateError wrapping one of those if a new dataset may have been created in Metax regardless.
+func Publish(ctx context.Context, api *metax.MetaxService, db *psql.DB, id uuid.UUID, owner uuid.UUID, svc string) (versionId string, newVersionId string, newQVersionId *uuid.UUID, err error) {
 	/*
 		tx, err := db.Begin()
 		if err != nil {
@@ -38,19 +64,32 @@ func Publish(api *metax.MetaxService, db *psql.DB, id uuid.UUID, owner uuid.UUID
 		return
 	}
 
-	fmt.Fprintln(os.Stderr, "About to publish:", id)
+	blob := dataset.Blob()
+	if svc != "" && dataset.Family() == metax.MetaxDatasetFamily {
+		var extid string
+		extid, err = db.GetIdentityForUid(svc, dataset.Owner)
+		if err != nil {
+			return
+		}
+		if extid != "" {
+			blob, err = metax.SetMetadataProviderUser(blob, extid)
+			if err != nil {
+				return
+			}
+		}
+	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+	fmt.Fprintln(os.Stderr, "About to publish:", id)
 
-	res, err := api.Store(ctx, dataset.Blob())
+	create := metax.GetIdentifier(blob) == ""
+	res, err := api.Store(ctx, blob)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "type: %T\n", err)
 		if apiErr, ok := err.(*metax.ApiError); ok {
 			fmt.Fprintf(os.Stderr, "metax error: [%d] %s\n", apiErr.StatusCode(), apiErr.OriginalError())
 		}
 		//return err
-		return
+		return "", "", nil, maybeCreated(create, err)
 	}
 
 	fmt.Fprintln(os.Stderr, "Success! Response follows:")
@@ -61,13 +100,13 @@ func Publish(api *metax.MetaxService, db *psql.DB, id uuid.UUID, owner uuid.UUID
 
 	versionId = metax.GetIdentifier(res)
 	if versionId == "" {
-		return "", "", nil, ErrNoIdentifier
+		return "", "", nil, maybeCreated(create, ErrNoIdentifier)
 	}
 
-	err = db.StorePublished(id, res)
+	err = db.StorePublished(id, res, &owner)
 	if err != nil {
 		//return err
-		return
+		return versionId, "", nil, maybeCreated(create, err)
 	}
 
 	/*
@@ -83,7 +122,7 @@ func Publish(api *metax.MetaxService, db *psql.DB, id uuid.UUID, owner uuid.UUID
 
 		var newVersion []byte
 		// get the new version from the Metax api
-		newVersion, err = api.GetId(newVersionId)
+		newVersion, err = api.GetId(ctx, newVersionId)
 		if err != nil {
 			fmt.Println("error getting new version:", err)
 			//return err
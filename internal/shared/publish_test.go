@@ -1,6 +1,7 @@
 package shared
 
 import (
+	"context"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -107,7 +108,7 @@ func TestPublish(t *testing.T) {
 		var versionId string
 
 		t.Run(test.fn+"(new)", func(t *testing.T) {
-			vId, nId, _, err := Publish(api, db, id, owner)
+			vId, nId, _, err := Publish(context.Background(), api, db, id, owner, "")
 			if err != nil {
 				if apiErr, ok := err.(*metax.ApiError); ok {
 					t.Errorf("API error: [%d] %s", apiErr.StatusCode(), apiErr.Error())
@@ -129,7 +130,7 @@ func TestPublish(t *testing.T) {
 		}
 
 		t.Run(test.fn+"(update)", func(t *testing.T) {
-			vId, nId, _, err := Publish(api, db, id, owner)
+			vId, nId, _, err := Publish(context.Background(), api, db, id, owner, "")
 			if err != nil {
 				if apiErr, ok := err.(*metax.ApiError); ok {
 					t.Errorf("API error: [%d] %s", apiErr.StatusCode(), apiErr.Error())
@@ -154,7 +155,7 @@ func TestPublish(t *testing.T) {
 		}
 
 		t.Run(test.fn+"(files)", func(t *testing.T) {
-			vId, nId, qId, err := Publish(api, db, id, owner)
+			vId, nId, qId, err := Publish(context.Background(), api, db, id, owner, "")
 			if err != nil {
 				if apiErr, ok := err.(*metax.ApiError); ok {
 					t.Errorf("API error: [%d] %s", apiErr.StatusCode(), apiErr.Error())
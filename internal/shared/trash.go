@@ -0,0 +1,15 @@
+package shared
+
+import (
+	"time"
+
+	"github.com/NatLibFi/qvain-api/pkg/env"
+)
+
+// DefaultTrashRetention is how long deleted datasets are kept in the trash bin (env APP_TRASH_RETENTION).
+const DefaultTrashRetention = "720h" // 30d
+
+// TrashRetention returns the trash retention period set in the environment, or the default.
+func TrashRetention() (time.Duration, error) {
+	return time.ParseDuration(env.GetDefault("APP_TRASH_RETENTION", DefaultTrashRetention))
+}
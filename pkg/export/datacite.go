@@ -0,0 +1,200 @@
+package export
+
+import (
+	"encoding/xml"
+)
+
+// dataciteResource is the root element of a DataCite Metadata Schema 4 document.
+type dataciteResource struct {
+	XMLName        xml.Name `xml:"resource"`
+	Xmlns          string   `xml:"xmlns,attr"`
+	XmlnsXsi       string   `xml:"xmlns:xsi,attr"`
+	SchemaLocation string   `xml:"xsi:schemaLocation,attr"`
+
+	Identifier      dataciteIdentifier    `xml:"identifier"`
+	Creators        []dataciteCreator     `xml:"creators>creator"`
+	Titles          []dataciteLangValue   `xml:"titles>title"`
+	Publisher       string                `xml:"publisher"`
+	PublicationYear string                `xml:"publicationYear"`
+	ResourceType    dataciteResourceType  `xml:"resourceType"`
+	Subjects        []dataciteSubject     `xml:"subjects>subject,omitempty"`
+	Contributors    []dataciteContributor `xml:"contributors>contributor,omitempty"`
+	Dates           []dataciteDate        `xml:"dates>date,omitempty"`
+	Language        string                `xml:"language,omitempty"`
+	RightsList      []dataciteRights      `xml:"rightsList>rights,omitempty"`
+	Descriptions    []dataciteDescription `xml:"descriptions>description,omitempty"`
+}
+
+type dataciteIdentifier struct {
+	Type  string `xml:"identifierType,attr"`
+	Value string `xml:",chardata"`
+}
+
+type dataciteName struct {
+	Type  string `xml:"nameType,attr,omitempty"`
+	Value string `xml:",chardata"`
+}
+
+type dataciteNameIdentifier struct {
+	Scheme string `xml:"nameIdentifierScheme,attr"`
+	Value  string `xml:",chardata"`
+}
+
+type dataciteCreator struct {
+	Name           dataciteName            `xml:"creatorName"`
+	NameIdentifier *dataciteNameIdentifier `xml:"nameIdentifier,omitempty"`
+	Affiliation    string                  `xml:"affiliation,omitempty"`
+}
+
+type dataciteContributor struct {
+	Type           string                  `xml:"contributorType,attr"`
+	Name           dataciteName            `xml:"contributorName"`
+	NameIdentifier *dataciteNameIdentifier `xml:"nameIdentifier,omitempty"`
+	Affiliation    string                  `xml:"affiliation,omitempty"`
+}
+
+type dataciteLangValue struct {
+	Lang  string `xml:"xml:lang,attr,omitempty"`
+	Value string `xml:",chardata"`
+}
+
+type dataciteResourceType struct {
+	General string `xml:"resourceTypeGeneral,attr"`
+	Value   string `xml:",chardata"`
+}
+
+type dataciteSubject struct {
+	Lang     string `xml:"xml:lang,attr,omitempty"`
+	Scheme   string `xml:"subjectScheme,attr,omitempty"`
+	ValueURI string `xml:"valueURI,attr,omitempty"`
+	Value    string `xml:",chardata"`
+}
+
+type dataciteDate struct {
+	Type  string `xml:"dateType,attr"`
+	Value string `xml:",chardata"`
+}
+
+type dataciteRights struct {
+	URI   string `xml:"rightsURI,attr,omitempty"`
+	Value string `xml:",chardata"`
+}
+
+type dataciteDescription struct {
+	Lang  string `xml:"xml:lang,attr,omitempty"`
+	Type  string `xml:"descriptionType,attr"`
+	Value string `xml:",chardata"`
+}
+
+// dataciteNameType maps agent types to DataCite name types.
+func dataciteNameType(a *agent) dataciteName {
+	if a.isPerson() {
+		return dataciteName{Type: "Personal", Value: a.name()}
+	}
+	return dataciteName{Type: "Organizational", Value: a.name()}
+}
+
+// dataciteNameId returns the agent's identifier, if any.
+func dataciteNameId(a *agent) *dataciteNameIdentifier {
+	if a.Identifier == "" {
+		return nil
+	}
+	return &dataciteNameIdentifier{Scheme: "URI", Value: a.Identifier}
+}
+
+// exportDataCite converts a Metax research dataset to DataCite 4 XML.
+func exportDataCite(record *Record) ([]byte, error) {
+	rd, err := parseResearchDataset(record)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &dataciteResource{
+		Xmlns:          "http://datacite.org/schema/kernel-4",
+		XmlnsXsi:       "http://www.w3.org/2001/XMLSchema-instance",
+		SchemaLocation: "http://datacite.org/schema/kernel-4 http://schema.datacite.org/meta/kernel-4/metadata.xsd",
+		ResourceType:   dataciteResourceType{General: "Dataset", Value: "Dataset"},
+	}
+
+	idType, id := identifierType(rd.PreferredIdentifier)
+	res.Identifier = dataciteIdentifier{Type: idType, Value: id}
+
+	for i := range rd.Creator {
+		res.Creators = append(res.Creators, dataciteCreator{
+			Name:           dataciteNameType(&rd.Creator[i]),
+			NameIdentifier: dataciteNameId(&rd.Creator[i]),
+			Affiliation:    rd.Creator[i].affiliation(),
+		})
+	}
+
+	for _, lang := range rd.Title.langs() {
+		res.Titles = append(res.Titles, dataciteLangValue{Lang: xmlLang(lang), Value: rd.Title[lang]})
+	}
+
+	if rd.Publisher != nil {
+		res.Publisher = rd.Publisher.name()
+	}
+
+	res.PublicationYear = year(rd.Issued)
+	if res.PublicationYear == "" && !record.Created.IsZero() {
+		res.PublicationYear = record.Created.Format("2006")
+	}
+
+	for _, keyword := range rd.Keyword {
+		res.Subjects = append(res.Subjects, dataciteSubject{Value: keyword})
+	}
+	for _, field := range rd.FieldOfScience {
+		res.Subjects = append(res.Subjects, dataciteSubject{Scheme: "Fields of Science and Technology", ValueURI: field.Identifier, Value: field.label()})
+	}
+
+	for _, contributor := range []struct {
+		role   string
+		agents []agent
+	}{
+		{"DataCurator", rd.Curator},
+		{"Other", rd.Contributor},
+	} {
+		for i := range contributor.agents {
+			res.Contributors = append(res.Contributors, dataciteContributor{
+				Type:           contributor.role,
+				Name:           dataciteNameType(&contributor.agents[i]),
+				NameIdentifier: dataciteNameId(&contributor.agents[i]),
+				Affiliation:    contributor.agents[i].affiliation(),
+			})
+		}
+	}
+
+	if rd.Issued != "" {
+		res.Dates = append(res.Dates, dataciteDate{Type: "Issued", Value: rd.Issued})
+	}
+	if rd.Modified != "" {
+		res.Dates = append(res.Dates, dataciteDate{Type: "Updated", Value: rd.Modified})
+	}
+
+	// DataCite allows only one language
+	if len(rd.Language) > 0 {
+		res.Language = languageCode(rd.Language[0].Identifier)
+	}
+
+	for _, license := range rd.licenses() {
+		res.RightsList = append(res.RightsList, dataciteRights{URI: license.url(), Value: license.Title.best()})
+	}
+	if rd.isOpenAccess() {
+		res.RightsList = append(res.RightsList, dataciteRights{URI: "info:eu-repo/semantics/openAccess", Value: "Open Access"})
+	}
+
+	for _, lang := range rd.Description.langs() {
+		res.Descriptions = append(res.Descriptions, dataciteDescription{Lang: xmlLang(lang), Type: "Abstract", Value: rd.Description[lang]})
+	}
+
+	return marshalXml(res)
+}
+
+// marshalXml serialises an XML document with declaration and indentation.
+func marshalXml(v interface{}) ([]byte, error) {
+	out, err := xml.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), append(out, '\n')...), nil
+}
@@ -0,0 +1,93 @@
+package export
+
+import (
+	"encoding/xml"
+)
+
+// oaiDc is the root element of an OAI-PMH Dublin Core (oai_dc) document.
+type oaiDc struct {
+	XMLName        xml.Name `xml:"oai_dc:dc"`
+	XmlnsOaiDc     string   `xml:"xmlns:oai_dc,attr"`
+	XmlnsDc        string   `xml:"xmlns:dc,attr"`
+	XmlnsXsi       string   `xml:"xmlns:xsi,attr"`
+	SchemaLocation string   `xml:"xsi:schemaLocation,attr"`
+
+	Title       []dcValue `xml:"dc:title"`
+	Creator     []dcValue `xml:"dc:creator"`
+	Subject     []dcValue `xml:"dc:subject"`
+	Description []dcValue `xml:"dc:description"`
+	Publisher   []dcValue `xml:"dc:publisher"`
+	Contributor []dcValue `xml:"dc:contributor"`
+	Date        []dcValue `xml:"dc:date"`
+	Type        []dcValue `xml:"dc:type"`
+	Identifier  []dcValue `xml:"dc:identifier"`
+	Language    []dcValue `xml:"dc:language"`
+	Rights      []dcValue `xml:"dc:rights"`
+}
+
+type dcValue struct {
+	Lang  string `xml:"xml:lang,attr,omitempty"`
+	Value string `xml:",chardata"`
+}
+
+// dcLangValues converts a multilingual string to language-tagged elements.
+func dcLangValues(ls langString) []dcValue {
+	var values []dcValue
+	for _, lang := range ls.langs() {
+		values = append(values, dcValue{Lang: xmlLang(lang), Value: ls[lang]})
+	}
+	return values
+}
+
+// exportOaiDc converts a Metax research dataset to simple Dublin Core as used by OAI-PMH.
+func exportOaiDc(record *Record) ([]byte, error) {
+	rd, err := parseResearchDataset(record)
+	if err != nil {
+		return nil, err
+	}
+
+	dc := &oaiDc{
+		XmlnsOaiDc:     "http://www.openarchives.org/OAI/2.0/oai_dc/",
+		XmlnsDc:        "http://purl.org/dc/elements/1.1/",
+		XmlnsXsi:       "http://www.w3.org/2001/XMLSchema-instance",
+		SchemaLocation: "http://www.openarchives.org/OAI/2.0/oai_dc/ http://www.openarchives.org/OAI/2.0/oai_dc.xsd",
+		Title:          dcLangValues(rd.Title),
+		Description:    dcLangValues(rd.Description),
+		Type:           []dcValue{{Value: "Dataset"}},
+	}
+
+	for i := range rd.Creator {
+		dc.Creator = append(dc.Creator, dcValue{Value: rd.Creator[i].name()})
+	}
+	for _, keyword := range rd.Keyword {
+		dc.Subject = append(dc.Subject, dcValue{Value: keyword})
+	}
+	for _, field := range rd.FieldOfScience {
+		dc.Subject = append(dc.Subject, dcValue{Value: field.label()})
+	}
+	if rd.Publisher != nil {
+		dc.Publisher = append(dc.Publisher, dcValue{Value: rd.Publisher.name()})
+	}
+	for _, agents := range [][]agent{rd.Curator, rd.Contributor} {
+		for i := range agents {
+			dc.Contributor = append(dc.Contributor, dcValue{Value: agents[i].name()})
+		}
+	}
+	if rd.Issued != "" {
+		dc.Date = append(dc.Date, dcValue{Value: rd.Issued})
+	}
+	if rd.PreferredIdentifier != "" {
+		dc.Identifier = append(dc.Identifier, dcValue{Value: rd.PreferredIdentifier})
+	}
+	for _, language := range rd.Language {
+		dc.Language = append(dc.Language, dcValue{Value: languageCode(language.Identifier)})
+	}
+	for _, license := range rd.licenses() {
+		dc.Rights = append(dc.Rights, dcValue{Value: license.url()})
+	}
+	if rd.isOpenAccess() {
+		dc.Rights = append(dc.Rights, dcValue{Value: "info:eu-repo/semantics/openAccess"})
+	}
+
+	return marshalXml(dc)
+}
@@ -0,0 +1,148 @@
+// Package export converts Qvain datasets to external metadata formats.
+//
+// Exporters are registered by format name; the package registers Qvain's own JSON format as well as
+// DataCite, Dublin Core and schema.org exporters for datasets with a Metax research_dataset.
+package export
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/wvh/uuid"
+)
+
+var (
+	// ErrUnknownFormat means there is no exporter registered for the requested format.
+	ErrUnknownFormat = errors.New("unknown export format")
+
+	// ErrUnsupportedDataset means the dataset doesn't contain the metadata the export format needs.
+	ErrUnsupportedDataset = errors.New("dataset can't be exported in this format")
+
+	// ErrInvalidRecord means the record to export could not be parsed.
+	ErrInvalidRecord = errors.New("invalid record")
+)
+
+// Record is a dataset as shown by the Qvain API, with its metadata fields.
+type Record struct {
+	Id        uuid.UUID       `json:"id"`
+	Created   time.Time       `json:"created"`
+	Modified  time.Time       `json:"modified"`
+	Published bool            `json:"published"`
+	Family    int             `json:"type"`
+	Schema    string          `json:"schema"`
+	Blob      json.RawMessage `json:"dataset"`
+
+	// raw is the whole record as it was parsed.
+	raw json.RawMessage
+}
+
+// ParseRecord parses a JSON object with a dataset record as returned by the API view,
+// or as stored in the database, where the type is called `family` and the dataset `blob`.
+func ParseRecord(raw []byte) (*Record, error) {
+	record := new(Record)
+	if err := json.Unmarshal(raw, record); err != nil {
+		return nil, ErrInvalidRecord
+	}
+	if record.Blob == nil {
+		var row struct {
+			Family int             `json:"family"`
+			Blob   json.RawMessage `json:"blob"`
+		}
+		if err := json.Unmarshal(raw, &row); err != nil {
+			return nil, ErrInvalidRecord
+		}
+		record.Family, record.Blob = row.Family, row.Blob
+	}
+	record.raw = raw
+	return record, nil
+}
+
+// Format describes an export format and the function that converts records to it.
+type Format struct {
+	// Name is the format name used to look up the exporter.
+	Name string
+
+	// Description is a short human-readable description of the format.
+	Description string
+
+	// ContentType is the MIME type of the exported document.
+	ContentType string
+
+	// Extension is the file extension, without dot, to use for exported files.
+	Extension string
+
+	// Export converts the record.
+	Export func(*Record) ([]byte, error)
+}
+
+// Filename returns a file name for the exported record.
+func (format *Format) Filename(record *Record) string {
+	return record.Id.String() + "." + format.Extension
+}
+
+var registry = make(map[string]*Format)
+
+// Register adds an export format to the registry, replacing any format with the same name.
+// It is not safe to call this function concurrently with lookups.
+func Register(format *Format) {
+	registry[format.Name] = format
+}
+
+// Lookup returns the export format with the given name.
+func Lookup(name string) (*Format, error) {
+	if format, ok := registry[name]; ok {
+		return format, nil
+	}
+	return nil, ErrUnknownFormat
+}
+
+// Formats returns the names of all registered formats in alphabetical order.
+func Formats() []string {
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func init() {
+	Register(&Format{
+		Name:        "qvain",
+		Description: "Qvain JSON record",
+		ContentType: "application/json",
+		Extension:   "json",
+		Export:      exportQvain,
+	})
+	Register(&Format{
+		Name:        "datacite",
+		Description: "DataCite Metadata Schema 4 XML",
+		ContentType: "application/xml",
+		Extension:   "xml",
+		Export:      exportDataCite,
+	})
+	Register(&Format{
+		Name:        "oai_dc",
+		Description: "OAI-PMH Dublin Core XML",
+		ContentType: "application/xml",
+		Extension:   "xml",
+		Export:      exportOaiDc,
+	})
+	Register(&Format{
+		Name:        "schemaorg",
+		Description: "schema.org Dataset JSON-LD",
+		ContentType: "application/ld+json",
+		Extension:   "jsonld",
+		Export:      exportSchemaOrg,
+	})
+}
+
+// exportQvain returns the record as it is shown by the Qvain API.
+func exportQvain(record *Record) ([]byte, error) {
+	if record.raw != nil {
+		return record.raw, nil
+	}
+	return json.Marshal(record)
+}
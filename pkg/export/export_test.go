@@ -0,0 +1,161 @@
+package export
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wvh/uuid"
+)
+
+func readRecord(t *testing.T, fn string) *Record {
+	blob, err := ioutil.ReadFile(filepath.Join("..", "metax", "testdata", fn))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	raw, err := json.Marshal(map[string]interface{}{
+		"id":       uuid.MustNewUUID(),
+		"created":  time.Date(2018, 6, 1, 0, 0, 0, 0, time.UTC),
+		"modified": time.Date(2018, 6, 2, 0, 0, 0, 0, time.UTC),
+		"type":     2,
+		"schema":   "metax-ida",
+		"dataset":  json.RawMessage(blob),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	record, err := ParseRecord(raw)
+	if err != nil {
+		t.Fatal("ParseRecord():", err)
+	}
+	return record
+}
+
+func TestFormats(t *testing.T) {
+	expected := []string{"datacite", "oai_dc", "qvain", "schemaorg"}
+	if formats := Formats(); strings.Join(formats, ",") != strings.Join(expected, ",") {
+		t.Errorf("expected formats %v, got %v", expected, formats)
+	}
+
+	if _, err := Lookup("marc21"); err != ErrUnknownFormat {
+		t.Errorf("expected %v, got %v", ErrUnknownFormat, err)
+	}
+}
+
+func TestExport(t *testing.T) {
+	record := readRecord(t, "published.json")
+
+	tests := []struct {
+		format   string
+		contains []string
+	}{
+		{format: "qvain", contains: []string{`"research_dataset"`}},
+		{format: "datacite", contains: []string{
+			`<resource xmlns="http://datacite.org/schema/kernel-4"`,
+			`<identifier identifierType="URN">urn:nbn:fi:att:fe7ed696-2a60-4d0c-b707-ee02c2bcd616</identifier>`,
+			`<creatorName nameType="Personal">Teppo Testaaja</creatorName>`,
+			`<affiliation>Mysteeriorganisaatio</affiliation>`,
+			`<title xml:lang="en">Wonderful Title</title>`,
+			`<contributor contributorType="DataCurator">`,
+			`<language>eng</language>`,
+			`<description xml:lang="en" descriptionType="Abstract">`,
+		}},
+		{format: "oai_dc", contains: []string{
+			`<oai_dc:dc xmlns:oai_dc="http://www.openarchives.org/OAI/2.0/oai_dc/"`,
+			`<dc:title xml:lang="en">Wonderful Title</dc:title>`,
+			`<dc:creator>Teppo Testaaja</dc:creator>`,
+			`<dc:type>Dataset</dc:type>`,
+			`<dc:rights>info:eu-repo/semantics/openAccess</dc:rights>`,
+		}},
+		{format: "schemaorg", contains: []string{
+			`"@type": "Dataset"`,
+			`"@value": "Wonderful Title"`,
+			`"isAccessibleForFree": true`,
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.format, func(t *testing.T) {
+			format, err := Lookup(test.format)
+			if err != nil {
+				t.Fatal("Lookup():", err)
+			}
+
+			out, err := format.Export(record)
+			if err != nil {
+				t.Fatal("Export():", err)
+			}
+
+			for _, s := range test.contains {
+				if !bytes.Contains(out, []byte(s)) {
+					t.Errorf("output doesn't contain %s:\n%s", s, out)
+				}
+			}
+
+			switch format.Extension {
+			case "xml":
+				if err := xml.Unmarshal(out, new(struct{})); err != nil {
+					t.Errorf("invalid XML: %s", err)
+				}
+			default:
+				if !json.Valid(out) {
+					t.Errorf("invalid JSON: %s", out)
+				}
+			}
+		})
+	}
+}
+
+func TestExportUnsupported(t *testing.T) {
+	record, err := ParseRecord([]byte(`{"id":"053bffbcc41edad4853bea91fc42ea18","type":1,"dataset":{"title":"open"}}`))
+	if err != nil {
+		t.Fatal("ParseRecord():", err)
+	}
+
+	for _, name := range []string{"datacite", "oai_dc", "schemaorg"} {
+		format, _ := Lookup(name)
+		if _, err := format.Export(record); err != ErrUnsupportedDataset {
+			t.Errorf("%s: expected %v, got %v", name, ErrUnsupportedDataset, err)
+		}
+	}
+}
+
+func TestParseRecordShapes(t *testing.T) {
+	for name, raw := range map[string]string{
+		"view": `{"id":"053bffbcc41edad4853bea91fc42ea18","type":2,"dataset":{"title":"open"}}`,
+		"row":  `{"id":"053bffbcc41edad4853bea91fc42ea18","family":2,"blob":{"title":"open"}}`,
+	} {
+		record, err := ParseRecord([]byte(raw))
+		if err != nil {
+			t.Fatalf("%s: ParseRecord(): %s", name, err)
+		}
+		if record.Family != 2 || string(record.Blob) != `{"title":"open"}` {
+			t.Errorf("%s: unexpected record: family %d, blob %s", name, record.Family, record.Blob)
+		}
+	}
+}
+
+func TestIdentifierType(t *testing.T) {
+	tests := []struct {
+		pid, typ, id string
+	}{
+		{"doi:10.23729/abc", "DOI", "10.23729/abc"},
+		{"https://doi.org/10.23729/abc", "DOI", "10.23729/abc"},
+		{"urn:nbn:fi:att:123", "URN", "urn:nbn:fi:att:123"},
+		{"http://example.com/ds", "URL", "http://example.com/ds"},
+		{"local-id", "Other", "local-id"},
+	}
+
+	for _, test := range tests {
+		if typ, id := identifierType(test.pid); typ != test.typ || id != test.id {
+			t.Errorf("%s: expected (%s, %s), got (%s, %s)", test.pid, test.typ, test.id, typ, id)
+		}
+	}
+}
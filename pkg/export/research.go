@@ -0,0 +1,194 @@
+package export
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+)
+
+// preferredLangs is the order in which languages are picked when a format needs a single value from a multilingual field.
+var preferredLangs = []string{"en", "fi", "sv", "und"}
+
+// langString is a multilingual string as used in Metax, keyed by language code.
+type langString map[string]string
+
+// best returns the value in the most preferred language available.
+func (ls langString) best() string {
+	for _, lang := range preferredLangs {
+		if s, ok := ls[lang]; ok && s != "" {
+			return s
+		}
+	}
+	for _, lang := range ls.langs() {
+		if ls[lang] != "" {
+			return ls[lang]
+		}
+	}
+	return ""
+}
+
+// langs returns the language codes in sorted order; "und" (undetermined) is left out if there are other languages.
+func (ls langString) langs() []string {
+	langs := make([]string, 0, len(ls))
+	for lang, s := range ls {
+		if s == "" || (lang == "und" && len(ls) > 1) {
+			continue
+		}
+		langs = append(langs, lang)
+	}
+	sort.Strings(langs)
+	return langs
+}
+
+// xmlLang returns the language code to use in an xml:lang attribute, or the empty string if the language is undetermined.
+func xmlLang(lang string) string {
+	if lang == "und" {
+		return ""
+	}
+	return lang
+}
+
+// agent is a person or organisation in a Metax research dataset.
+type agent struct {
+	Type       string          `json:"@type"`
+	Name       json.RawMessage `json:"name"`
+	Identifier string          `json:"identifier"`
+	MemberOf   *agent          `json:"member_of"`
+}
+
+// isPerson returns true if the agent is a person rather than an organisation.
+func (a *agent) isPerson() bool {
+	return a.Type == "Person"
+}
+
+// name returns the agent's name; persons have a plain string, organisations a multilingual one.
+func (a *agent) name() string {
+	var s string
+	if err := json.Unmarshal(a.Name, &s); err == nil {
+		return s
+	}
+
+	var ls langString
+	if err := json.Unmarshal(a.Name, &ls); err == nil {
+		return ls.best()
+	}
+	return ""
+}
+
+// affiliation returns the name of the organisation a person belongs to, if any.
+func (a *agent) affiliation() string {
+	if a.isPerson() && a.MemberOf != nil {
+		return a.MemberOf.name()
+	}
+	return ""
+}
+
+// concept is a reference data entry, such as a language or field of science.
+type concept struct {
+	Identifier string     `json:"identifier"`
+	PrefLabel  langString `json:"pref_label"`
+	Title      langString `json:"title"`
+}
+
+// label returns the concept's label.
+func (c *concept) label() string {
+	if s := c.PrefLabel.best(); s != "" {
+		return s
+	}
+	return c.Title.best()
+}
+
+// license is a license entry in a dataset's access rights.
+type license struct {
+	Identifier string     `json:"identifier"`
+	Title      langString `json:"title"`
+	License    string     `json:"license"`
+}
+
+// url returns the URL of the license document, falling back to the reference data identifier.
+func (l *license) url() string {
+	if l.License != "" {
+		return l.License
+	}
+	return l.Identifier
+}
+
+// researchDataset holds the parts of a Metax research_dataset that are used by the export formats.
+type researchDataset struct {
+	PreferredIdentifier string     `json:"preferred_identifier"`
+	Title               langString `json:"title"`
+	Description         langString `json:"description"`
+	Keyword             []string   `json:"keyword"`
+	Creator             []agent    `json:"creator"`
+	Contributor         []agent    `json:"contributor"`
+	Curator             []agent    `json:"curator"`
+	Publisher           *agent     `json:"publisher"`
+	Issued              string     `json:"issued"`
+	Modified            string     `json:"modified"`
+	Language            []concept  `json:"language"`
+	FieldOfScience      []concept  `json:"field_of_science"`
+	AccessRights        *struct {
+		License    []license `json:"license"`
+		AccessType *concept  `json:"access_type"`
+	} `json:"access_rights"`
+}
+
+// parseResearchDataset extracts the research_dataset from a record's blob.
+func parseResearchDataset(record *Record) (*researchDataset, error) {
+	var blob struct {
+		ResearchDataset *researchDataset `json:"research_dataset"`
+	}
+
+	if err := json.Unmarshal(record.Blob, &blob); err != nil {
+		return nil, ErrInvalidRecord
+	}
+	if blob.ResearchDataset == nil {
+		return nil, ErrUnsupportedDataset
+	}
+	return blob.ResearchDataset, nil
+}
+
+// identifierType returns the DataCite identifier type for a persistent identifier, and the identifier without scheme prefix for DOIs.
+func identifierType(pid string) (string, string) {
+	switch {
+	case strings.HasPrefix(pid, "doi:"):
+		return "DOI", strings.TrimPrefix(pid, "doi:")
+	case strings.HasPrefix(pid, "https://doi.org/"):
+		return "DOI", strings.TrimPrefix(pid, "https://doi.org/")
+	case strings.HasPrefix(pid, "urn:"):
+		return "URN", pid
+	case strings.HasPrefix(pid, "http://"), strings.HasPrefix(pid, "https://"):
+		return "URL", pid
+	}
+	return "Other", pid
+}
+
+// languageCode returns the language code from a lexvo.org identifier such as http://lexvo.org/id/iso639-3/eng.
+func languageCode(identifier string) string {
+	if i := strings.LastIndex(identifier, "/"); i >= 0 {
+		return identifier[i+1:]
+	}
+	return identifier
+}
+
+// year returns the year part of an ISO 8601 date.
+func year(date string) string {
+	if len(date) >= 4 {
+		return date[:4]
+	}
+	return ""
+}
+
+// isOpenAccess checks if the dataset's access type is open.
+func (rd *researchDataset) isOpenAccess() bool {
+	return rd.AccessRights != nil && rd.AccessRights.AccessType != nil &&
+		strings.HasSuffix(rd.AccessRights.AccessType.Identifier, "access_type_open_access")
+}
+
+// licenses returns the dataset's licenses.
+func (rd *researchDataset) licenses() []license {
+	if rd.AccessRights == nil {
+		return nil
+	}
+	return rd.AccessRights.License
+}
@@ -0,0 +1,98 @@
+package export
+
+import (
+	"encoding/json"
+)
+
+// jsonldValue is a language-tagged JSON-LD value.
+type jsonldValue struct {
+	Value    string `json:"@value"`
+	Language string `json:"@language,omitempty"`
+}
+
+// jsonldLangValues converts a multilingual string to language-tagged JSON-LD values.
+func jsonldLangValues(ls langString) []jsonldValue {
+	var values []jsonldValue
+	for _, lang := range ls.langs() {
+		values = append(values, jsonldValue{Value: ls[lang], Language: xmlLang(lang)})
+	}
+	return values
+}
+
+type schemaOrgAgent struct {
+	Type        string          `json:"@type"`
+	Id          string          `json:"@id,omitempty"`
+	Name        string          `json:"name"`
+	Affiliation *schemaOrgAgent `json:"affiliation,omitempty"`
+}
+
+type schemaOrgDataset struct {
+	Context             string           `json:"@context"`
+	Type                string           `json:"@type"`
+	Identifier          string           `json:"identifier,omitempty"`
+	Name                []jsonldValue    `json:"name,omitempty"`
+	Description         []jsonldValue    `json:"description,omitempty"`
+	Keywords            []string         `json:"keywords,omitempty"`
+	Creator             []schemaOrgAgent `json:"creator,omitempty"`
+	Contributor         []schemaOrgAgent `json:"contributor,omitempty"`
+	Publisher           *schemaOrgAgent  `json:"publisher,omitempty"`
+	DatePublished       string           `json:"datePublished,omitempty"`
+	DateModified        string           `json:"dateModified,omitempty"`
+	InLanguage          []string         `json:"inLanguage,omitempty"`
+	License             []string         `json:"license,omitempty"`
+	IsAccessibleForFree bool             `json:"isAccessibleForFree"`
+}
+
+// schemaOrgAgentFrom converts a Metax agent to a schema.org Person or Organization.
+func schemaOrgAgentFrom(a *agent) schemaOrgAgent {
+	sa := schemaOrgAgent{Type: "Organization", Id: a.Identifier, Name: a.name()}
+	if a.isPerson() {
+		sa.Type = "Person"
+		if a.MemberOf != nil {
+			org := schemaOrgAgentFrom(a.MemberOf)
+			sa.Affiliation = &org
+		}
+	}
+	return sa
+}
+
+// exportSchemaOrg converts a Metax research dataset to a schema.org Dataset in JSON-LD.
+func exportSchemaOrg(record *Record) ([]byte, error) {
+	rd, err := parseResearchDataset(record)
+	if err != nil {
+		return nil, err
+	}
+
+	ds := &schemaOrgDataset{
+		Context:             "https://schema.org/",
+		Type:                "Dataset",
+		Identifier:          rd.PreferredIdentifier,
+		Name:                jsonldLangValues(rd.Title),
+		Description:         jsonldLangValues(rd.Description),
+		Keywords:            rd.Keyword,
+		DatePublished:       rd.Issued,
+		DateModified:        rd.Modified,
+		IsAccessibleForFree: rd.isOpenAccess(),
+	}
+
+	for i := range rd.Creator {
+		ds.Creator = append(ds.Creator, schemaOrgAgentFrom(&rd.Creator[i]))
+	}
+	for _, agents := range [][]agent{rd.Curator, rd.Contributor} {
+		for i := range agents {
+			ds.Contributor = append(ds.Contributor, schemaOrgAgentFrom(&agents[i]))
+		}
+	}
+	if rd.Publisher != nil {
+		publisher := schemaOrgAgentFrom(rd.Publisher)
+		ds.Publisher = &publisher
+	}
+	for _, language := range rd.Language {
+		ds.InLanguage = append(ds.InLanguage, languageCode(language.Identifier))
+	}
+	for _, license := range rd.licenses() {
+		ds.License = append(ds.License, license.url())
+	}
+
+	return json.MarshalIndent(ds, "", "  ")
+}
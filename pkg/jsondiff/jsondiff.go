@@ -0,0 +1,178 @@
+// Package jsondiff computes structural differences between JSON documents.
+/*
+example:
+	changes, err := jsondiff.Diff(
+		[]byte(`{"title":{"en":"old","fi":"vanha"},"keyword":["a"]}`),
+		[]byte(`{"title":{"en":"new"},"keyword":["a","b"]}`),
+	)
+	// changes:
+	//   {"op":"add","path":"/keyword/1","value":"b"}
+	//   {"op":"replace","path":"/title/en","old":"old","value":"new"}
+	//   {"op":"remove","path":"/title/fi","old":"vanha"}
+*/
+package jsondiff
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Change operations, named after their JSON Patch (RFC 6902) counterparts.
+const (
+	OpAdd     = "add"
+	OpRemove  = "remove"
+	OpReplace = "replace"
+)
+
+// ErrInvalidDocument is returned when a document can't be parsed.
+var ErrInvalidDocument = errors.New("invalid json document")
+
+// Change is a difference between two documents at a given location.
+type Change struct {
+	// Op is one of OpAdd, OpRemove or OpReplace.
+	Op string `json:"op"`
+
+	// Path is the JSON pointer (RFC 6901) to the changed value.
+	Path string `json:"path"`
+
+	// Old is the value in the first document; it is not set for additions.
+	Old interface{} `json:"old"`
+
+	// Value is the value in the second document; it is not set for removals.
+	Value interface{} `json:"value"`
+}
+
+// MarshalJSON leaves out the side of a change that doesn't exist for its operation, so a JSON null on the other side is kept.
+func (change Change) MarshalJSON() ([]byte, error) {
+	switch change.Op {
+	case OpAdd:
+		return json.Marshal(struct {
+			Op    string      `json:"op"`
+			Path  string      `json:"path"`
+			Value interface{} `json:"value"`
+		}{change.Op, change.Path, change.Value})
+	case OpRemove:
+		return json.Marshal(struct {
+			Op   string      `json:"op"`
+			Path string      `json:"path"`
+			Old  interface{} `json:"old"`
+		}{change.Op, change.Path, change.Old})
+	}
+
+	type plain Change
+	return json.Marshal(plain(change))
+}
+
+// Diff returns the changes needed to get from document a to document b, sorted by path.
+// Objects are compared key by key and arrays element by element; any other difference replaces the whole value.
+// An empty document is treated as JSON null.
+func Diff(a []byte, b []byte) ([]Change, error) {
+	var va, vb interface{}
+	if err := decode(a, &va); err != nil {
+		return nil, ErrInvalidDocument
+	}
+	if err := decode(b, &vb); err != nil {
+		return nil, ErrInvalidDocument
+	}
+
+	changes := diff("", va, vb, []Change{})
+	sort.SliceStable(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes, nil
+}
+
+// diff appends the differences between two parsed values at the given path to changes.
+func diff(path string, a interface{}, b interface{}, changes []Change) []Change {
+	switch va := a.(type) {
+	case map[string]interface{}:
+		vb, ok := b.(map[string]interface{})
+		if !ok {
+			break
+		}
+		for key, value := range va {
+			if other, ok := vb[key]; ok {
+				changes = diff(path+"/"+escape(key), value, other, changes)
+			} else {
+				changes = append(changes, Change{Op: OpRemove, Path: path + "/" + escape(key), Old: value})
+			}
+		}
+		for key, value := range vb {
+			if _, ok := va[key]; !ok {
+				changes = append(changes, Change{Op: OpAdd, Path: path + "/" + escape(key), Value: value})
+			}
+		}
+		return changes
+
+	case []interface{}:
+		vb, ok := b.([]interface{})
+		if !ok {
+			break
+		}
+		for i := 0; i < len(va) || i < len(vb); i++ {
+			p := path + "/" + strconv.Itoa(i)
+			switch {
+			case i >= len(vb):
+				changes = append(changes, Change{Op: OpRemove, Path: p, Old: va[i]})
+			case i >= len(va):
+				changes = append(changes, Change{Op: OpAdd, Path: p, Value: vb[i]})
+			default:
+				changes = diff(p, va[i], vb[i], changes)
+			}
+		}
+		return changes
+	}
+
+	if !equal(a, b) {
+		changes = append(changes, Change{Op: OpReplace, Path: path, Old: a, Value: b})
+	}
+	return changes
+}
+
+// equal compares two parsed scalar values or values of different types; numbers are compared by value.
+func equal(a interface{}, b interface{}) bool {
+	na, aok := a.(json.Number)
+	nb, bok := b.(json.Number)
+	if aok && bok {
+		if na == nb {
+			return true
+		}
+		fa, erra := na.Float64()
+		fb, errb := nb.Float64()
+		return erra == nil && errb == nil && fa == fb
+	}
+
+	switch a.(type) {
+	case map[string]interface{}, []interface{}:
+		return false
+	}
+	switch b.(type) {
+	case map[string]interface{}, []interface{}:
+		return false
+	}
+	return a == b
+}
+
+// escape escapes a key for use as JSON pointer reference token.
+func escape(key string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(key)
+}
+
+// decode unmarshals JSON keeping numbers as json.Number, so they are shown in changes exactly as they were.
+func decode(data []byte, v interface{}) error {
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	if dec.More() {
+		return ErrInvalidDocument
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package jsondiff
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	var tests = []struct {
+		name    string
+		a, b    string
+		changes string
+	}{
+		{
+			name:    "equal",
+			a:       `{"title": {"en": "T"}, "n": 1.0, "list": [1, 2]}`,
+			b:       `{"list": [1, 2], "n": 1, "title": {"en": "T"}}`,
+			changes: `[]`,
+		},
+		{
+			name:    "objects",
+			a:       `{"title": {"en": "old", "fi": "vanha"}}`,
+			b:       `{"title": {"en": "new", "sv": "ny"}}`,
+			changes: `[{"op":"replace","path":"/title/en","old":"old","value":"new"},{"op":"remove","path":"/title/fi","old":"vanha"},{"op":"add","path":"/title/sv","value":"ny"}]`,
+		},
+		{
+			name:    "arrays",
+			a:       `{"keyword": ["a", "b", "c"], "creator": [{"name": "A"}]}`,
+			b:       `{"keyword": ["a", "x"], "creator": [{"name": "A"}, {"name": "B"}]}`,
+			changes: `[{"op":"add","path":"/creator/1","value":{"name":"B"}},{"op":"replace","path":"/keyword/1","old":"b","value":"x"},{"op":"remove","path":"/keyword/2","old":"c"}]`,
+		},
+		{
+			name:    "types",
+			a:       `{"a": {"b": 1}, "c": [1], "d": false, "e/f": null}`,
+			b:       `{"a": [1], "c": "1", "d": 0, "e/f": 12345678901234567890}`,
+			changes: `[{"op":"replace","path":"/a","old":{"b":1},"value":[1]},{"op":"replace","path":"/c","old":[1],"value":"1"},{"op":"replace","path":"/d","old":false,"value":0},{"op":"replace","path":"/e~1f","old":null,"value":12345678901234567890}]`,
+		},
+		{
+			name:    "empty",
+			a:       ``,
+			b:       `{"a": 1}`,
+			changes: `[{"op":"replace","path":"","old":null,"value":{"a":1}}]`,
+		},
+		{
+			name:    "nulls",
+			a:       `{"a": null, "b": 1, "c": [null]}`,
+			b:       `{"b": null, "c": [null, null], "d": null}`,
+			changes: `[{"op":"remove","path":"/a","old":null},{"op":"replace","path":"/b","old":1,"value":null},{"op":"add","path":"/c/1","value":null},{"op":"add","path":"/d","value":null}]`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			changes, err := Diff([]byte(test.a), []byte(test.b))
+			if err != nil {
+				t.Fatal(err)
+			}
+			out, err := json.Marshal(changes)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(out) != test.changes {
+				t.Errorf("expected:\n%s\ngot:\n%s", test.changes, out)
+			}
+		})
+	}
+
+	if _, err := Diff([]byte(`{}`), []byte(`{"a":`)); err != ErrInvalidDocument {
+		t.Errorf("expected ErrInvalidDocument, got %v", err)
+	}
+}
@@ -0,0 +1,593 @@
+// Package jsonschema implements a validator for the subset of JSON Schema (draft-07) used by Qvain's dataset schemas.
+//
+// Supported keywords:
+//
+//	general:  $ref (local references only), type, enum, const, allOf, anyOf, oneOf, not, if/then/else
+//	objects:  properties, required, additionalProperties, minProperties, maxProperties
+//	arrays:   items (single schema), minItems, maxItems
+//	strings:  minLength, maxLength, pattern, format (date, date-time, uri, email)
+//	numbers:  minimum, maximum
+//
+// Other keywords, such as title and description, are ignored.
+package jsonschema
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+var (
+	ErrInvalidSchema   = errors.New("invalid schema")
+	ErrInvalidDocument = errors.New("invalid json document")
+)
+
+// Error is a validation error for one location in a document.
+type Error struct {
+	// Pointer is the JSON pointer (RFC 6901) to the offending value; for missing properties it points to where the property should be.
+	Pointer string `json:"pointer"`
+
+	// Keyword is the schema keyword that failed.
+	Keyword string `json:"keyword"`
+
+	// Message is a human-readable description of the problem.
+	Message string `json:"message"`
+}
+
+// Error satisfies Go's error interface.
+func (e Error) Error() string {
+	if e.Pointer == "" {
+		return e.Message
+	}
+	return e.Pointer + ": " + e.Message
+}
+
+// Schema is a compiled JSON Schema.
+type Schema struct {
+	root interface{}
+
+	mu       sync.Mutex
+	patterns map[string]*regexp.Regexp
+}
+
+// Compile parses a JSON Schema. It checks that the schema is well-formed as far as the supported keywords go.
+func Compile(data []byte) (*Schema, error) {
+	root, err := decode(data)
+	if err != nil {
+		return nil, ErrInvalidSchema
+	}
+
+	schema := &Schema{root: root, patterns: make(map[string]*regexp.Regexp)}
+	if err := schema.check(root, ""); err != nil {
+		return nil, err
+	}
+	return schema, nil
+}
+
+// MustCompile is like Compile but panics if the schema is invalid.
+func MustCompile(data []byte) *Schema {
+	schema, err := Compile(data)
+	if err != nil {
+		panic(err)
+	}
+	return schema
+}
+
+// Validate validates a JSON document and returns the validation errors, if any.
+// It returns ErrInvalidDocument if the document is not valid JSON.
+func (s *Schema) Validate(doc []byte) ([]Error, error) {
+	value, err := decode(doc)
+	if err != nil {
+		return nil, ErrInvalidDocument
+	}
+	return s.ValidateValue(value), nil
+}
+
+// ValidateValue validates a decoded JSON value. Numbers should be json.Number or float64.
+func (s *Schema) ValidateValue(value interface{}) []Error {
+	v := &validator{schema: s}
+	v.validate(s.root, value, "")
+	return v.errs
+}
+
+// decode parses JSON, keeping numbers as json.Number.
+func decode(data []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	var value interface{}
+	if err := dec.Decode(&value); err != nil {
+		return nil, err
+	}
+	if dec.More() {
+		return nil, ErrInvalidDocument
+	}
+	return value, nil
+}
+
+// check walks the schema and returns an error for malformed keywords and unresolvable references.
+func (s *Schema) check(node interface{}, ptr string) error {
+	switch node := node.(type) {
+	case bool:
+		return nil
+	case map[string]interface{}:
+		if ref, ok := node["$ref"]; ok {
+			str, ok := ref.(string)
+			if !ok {
+				return fmt.Errorf("%s: %s/$ref must be a string", ErrInvalidSchema, ptr)
+			}
+			if _, err := s.resolve(str); err != nil {
+				return err
+			}
+		}
+		if pattern, ok := node["pattern"].(string); ok {
+			if _, err := s.pattern(pattern); err != nil {
+				return fmt.Errorf("%s: %s/pattern: %s", ErrInvalidSchema, ptr, err)
+			}
+		}
+		for _, key := range []string{"items", "additionalProperties", "not", "if", "then", "else"} {
+			if sub, ok := node[key]; ok {
+				if err := s.check(sub, ptr+"/"+key); err != nil {
+					return err
+				}
+			}
+		}
+		for _, key := range []string{"properties", "definitions", "$defs"} {
+			if subs, ok := node[key].(map[string]interface{}); ok {
+				for name, sub := range subs {
+					if err := s.check(sub, ptr+"/"+key+"/"+escape(name)); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		for _, key := range []string{"allOf", "anyOf", "oneOf"} {
+			if subs, ok := node[key]; ok {
+				list, ok := subs.([]interface{})
+				if !ok || len(list) == 0 {
+					return fmt.Errorf("%s: %s/%s must be a non-empty array", ErrInvalidSchema, ptr, key)
+				}
+				for i, sub := range list {
+					if err := s.check(sub, ptr+"/"+key+"/"+strconv.Itoa(i)); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("%s: %s must be an object or boolean", ErrInvalidSchema, ptr)
+	}
+}
+
+// resolve looks up a local reference such as `#/definitions/agent`.
+func (s *Schema) resolve(ref string) (interface{}, error) {
+	if !strings.HasPrefix(ref, "#") {
+		return nil, fmt.Errorf("%s: only local references are supported: %s", ErrInvalidSchema, ref)
+	}
+
+	node := s.root
+	path := strings.TrimPrefix(ref, "#")
+	if path == "" {
+		return node, nil
+	}
+
+	for _, token := range strings.Split(strings.TrimPrefix(path, "/"), "/") {
+		token = unescape(token)
+		switch cur := node.(type) {
+		case map[string]interface{}:
+			next, ok := cur[token]
+			if !ok {
+				return nil, fmt.Errorf("%s: unresolvable reference: %s", ErrInvalidSchema, ref)
+			}
+			node = next
+		case []interface{}:
+			i, err := strconv.Atoi(token)
+			if err != nil || i < 0 || i >= len(cur) {
+				return nil, fmt.Errorf("%s: unresolvable reference: %s", ErrInvalidSchema, ref)
+			}
+			node = cur[i]
+		default:
+			return nil, fmt.Errorf("%s: unresolvable reference: %s", ErrInvalidSchema, ref)
+		}
+	}
+	return node, nil
+}
+
+// pattern returns a cached compiled regular expression.
+func (s *Schema) pattern(expr string) (*regexp.Regexp, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if re, ok := s.patterns[expr]; ok {
+		return re, nil
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	s.patterns[expr] = re
+	return re, nil
+}
+
+// validator collects errors while validating one document.
+type validator struct {
+	schema *Schema
+	errs   []Error
+	depth  int
+}
+
+// maxDepth guards against reference cycles.
+const maxDepth = 64
+
+func (v *validator) fail(ptr string, keyword string, format string, args ...interface{}) {
+	v.errs = append(v.errs, Error{Pointer: ptr, Keyword: keyword, Message: fmt.Sprintf(format, args...)})
+}
+
+// matches returns true if the value validates against the schema, without recording errors.
+func (v *validator) matches(schema interface{}, value interface{}, ptr string) bool {
+	sub := &validator{schema: v.schema, depth: v.depth}
+	sub.validate(schema, value, ptr)
+	return len(sub.errs) == 0
+}
+
+func (v *validator) validate(schema interface{}, value interface{}, ptr string) {
+	v.depth++
+	defer func() { v.depth-- }()
+	if v.depth > maxDepth {
+		v.fail(ptr, "$ref", "schema nesting too deep")
+		return
+	}
+
+	node, ok := schema.(map[string]interface{})
+	if !ok {
+		if b, ok := schema.(bool); ok && !b {
+			v.fail(ptr, "false", "value not allowed")
+		}
+		return
+	}
+
+	if ref, ok := node["$ref"].(string); ok {
+		target, err := v.schema.resolve(ref)
+		if err != nil {
+			v.fail(ptr, "$ref", "%s", err)
+			return
+		}
+		// in draft-07, $ref overrides sibling keywords
+		v.validate(target, value, ptr)
+		return
+	}
+
+	if types, ok := node["type"]; ok && !hasType(types, value) {
+		v.fail(ptr, "type", "expected %s, got %s", typeList(types), typeOf(value))
+		// other keywords are unlikely to give useful errors for the wrong type
+		return
+	}
+
+	if enum, ok := node["enum"].([]interface{}); ok {
+		found := false
+		for _, allowed := range enum {
+			if equal(allowed, value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			v.fail(ptr, "enum", "value is not one of the allowed values")
+		}
+	}
+
+	if constant, ok := node["const"]; ok && !equal(constant, value) {
+		v.fail(ptr, "const", "value does not match the expected value")
+	}
+
+	if subs, ok := node["allOf"].([]interface{}); ok {
+		for _, sub := range subs {
+			v.validate(sub, value, ptr)
+		}
+	}
+
+	if subs, ok := node["anyOf"].([]interface{}); ok {
+		found := false
+		for _, sub := range subs {
+			if v.matches(sub, value, ptr) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			v.fail(ptr, "anyOf", "value does not match any of the allowed schemas")
+		}
+	}
+
+	if subs, ok := node["oneOf"].([]interface{}); ok {
+		count := 0
+		for _, sub := range subs {
+			if v.matches(sub, value, ptr) {
+				count++
+			}
+		}
+		if count != 1 {
+			v.fail(ptr, "oneOf", "value must match exactly one of the allowed schemas, matches %d", count)
+		}
+	}
+
+	if sub, ok := node["not"]; ok && v.matches(sub, value, ptr) {
+		v.fail(ptr, "not", "value matches a disallowed schema")
+	}
+
+	if cond, ok := node["if"]; ok {
+		if v.matches(cond, value, ptr) {
+			if then, ok := node["then"]; ok {
+				v.validate(then, value, ptr)
+			}
+		} else if otherwise, ok := node["else"]; ok {
+			v.validate(otherwise, value, ptr)
+		}
+	}
+
+	switch value := value.(type) {
+	case map[string]interface{}:
+		v.validateObject(node, value, ptr)
+	case []interface{}:
+		v.validateArray(node, value, ptr)
+	case string:
+		v.validateString(node, value, ptr)
+	case json.Number, float64:
+		v.validateNumber(node, toFloat(value), ptr)
+	}
+}
+
+func (v *validator) validateObject(node map[string]interface{}, obj map[string]interface{}, ptr string) {
+	if required, ok := node["required"].([]interface{}); ok {
+		for _, name := range required {
+			if name, ok := name.(string); ok {
+				if _, exists := obj[name]; !exists {
+					v.fail(ptr+"/"+escape(name), "required", "missing required property")
+				}
+			}
+		}
+	}
+
+	if min, ok := toInt(node["minProperties"]); ok && len(obj) < min {
+		v.fail(ptr, "minProperties", "must have at least %d properties", min)
+	}
+	if max, ok := toInt(node["maxProperties"]); ok && len(obj) > max {
+		v.fail(ptr, "maxProperties", "must have at most %d properties", max)
+	}
+
+	properties, _ := node["properties"].(map[string]interface{})
+	additional, hasAdditional := node["additionalProperties"]
+
+	// sort keys so errors come out in a stable order
+	keys := make([]string, 0, len(obj))
+	for key := range obj {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		child := ptr + "/" + escape(key)
+		if sub, ok := properties[key]; ok {
+			v.validate(sub, obj[key], child)
+		} else if hasAdditional {
+			if b, ok := additional.(bool); ok {
+				if !b {
+					v.fail(child, "additionalProperties", "property not allowed")
+				}
+			} else {
+				v.validate(additional, obj[key], child)
+			}
+		}
+	}
+}
+
+func (v *validator) validateArray(node map[string]interface{}, arr []interface{}, ptr string) {
+	if min, ok := toInt(node["minItems"]); ok && len(arr) < min {
+		v.fail(ptr, "minItems", "must have at least %d items", min)
+	}
+	if max, ok := toInt(node["maxItems"]); ok && len(arr) > max {
+		v.fail(ptr, "maxItems", "must have at most %d items", max)
+	}
+
+	if items, ok := node["items"]; ok {
+		for i, item := range arr {
+			v.validate(items, item, ptr+"/"+strconv.Itoa(i))
+		}
+	}
+}
+
+func (v *validator) validateString(node map[string]interface{}, str string, ptr string) {
+	length := utf8.RuneCountInString(str)
+	if min, ok := toInt(node["minLength"]); ok && length < min {
+		if min == 1 {
+			v.fail(ptr, "minLength", "must not be empty")
+		} else {
+			v.fail(ptr, "minLength", "must be at least %d characters long", min)
+		}
+	}
+	if max, ok := toInt(node["maxLength"]); ok && length > max {
+		v.fail(ptr, "maxLength", "must be at most %d characters long", max)
+	}
+
+	if expr, ok := node["pattern"].(string); ok {
+		re, err := v.schema.pattern(expr)
+		if err == nil && !re.MatchString(str) {
+			v.fail(ptr, "pattern", "does not match the expected pattern")
+		}
+	}
+
+	if format, ok := node["format"].(string); ok && !validFormat(format, str) {
+		v.fail(ptr, "format", "not a valid %s", format)
+	}
+}
+
+func (v *validator) validateNumber(node map[string]interface{}, num float64, ptr string) {
+	if min, ok := node["minimum"]; ok && num < toFloat(min) {
+		v.fail(ptr, "minimum", "must be at least %v", min)
+	}
+	if max, ok := node["maximum"]; ok && num > toFloat(max) {
+		v.fail(ptr, "maximum", "must be at most %v", max)
+	}
+}
+
+// validFormat checks the supported string formats; unknown formats always pass.
+func validFormat(format string, str string) bool {
+	switch format {
+	case "date":
+		_, err := time.Parse("2006-01-02", str)
+		return err == nil
+	case "date-time":
+		_, err := time.Parse(time.RFC3339, str)
+		return err == nil
+	case "uri":
+		u, err := url.Parse(str)
+		return err == nil && u.Scheme != ""
+	case "email":
+		at := strings.LastIndexByte(str, '@')
+		return at > 0 && at < len(str)-1
+	}
+	return true
+}
+
+// typeOf returns the JSON Schema type name of a decoded value.
+func typeOf(value interface{}) string {
+	switch value := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case json.Number:
+		if isInteger(value) {
+			return "integer"
+		}
+		return "number"
+	case float64:
+		if value == float64(int64(value)) {
+			return "integer"
+		}
+		return "number"
+	}
+	return "unknown"
+}
+
+func isInteger(n json.Number) bool {
+	if _, err := n.Int64(); err == nil {
+		return true
+	}
+	f, err := n.Float64()
+	return err == nil && f == float64(int64(f))
+}
+
+// hasType checks a value against a type name or list of type names.
+func hasType(types interface{}, value interface{}) bool {
+	actual := typeOf(value)
+	check := func(name interface{}) bool {
+		return name == actual || (name == "number" && actual == "integer")
+	}
+
+	if list, ok := types.([]interface{}); ok {
+		for _, name := range list {
+			if check(name) {
+				return true
+			}
+		}
+		return false
+	}
+	return check(types)
+}
+
+// typeList formats the type keyword for error messages.
+func typeList(types interface{}) string {
+	if list, ok := types.([]interface{}); ok {
+		names := make([]string, 0, len(list))
+		for _, name := range list {
+			names = append(names, fmt.Sprint(name))
+		}
+		return strings.Join(names, " or ")
+	}
+	return fmt.Sprint(types)
+}
+
+// equal compares decoded JSON values, treating numbers by value.
+func equal(a, b interface{}) bool {
+	switch a := a.(type) {
+	case json.Number, float64:
+		switch b.(type) {
+		case json.Number, float64:
+			return toFloat(a) == toFloat(b)
+		}
+		return false
+	case map[string]interface{}:
+		bm, ok := b.(map[string]interface{})
+		if !ok || len(a) != len(bm) {
+			return false
+		}
+		for key, av := range a {
+			bv, ok := bm[key]
+			if !ok || !equal(av, bv) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		bl, ok := b.([]interface{})
+		if !ok || len(a) != len(bl) {
+			return false
+		}
+		for i := range a {
+			if !equal(a[i], bl[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return a == b
+}
+
+func toFloat(value interface{}) float64 {
+	switch value := value.(type) {
+	case json.Number:
+		f, _ := value.Float64()
+		return f
+	case float64:
+		return value
+	}
+	return 0
+}
+
+func toInt(value interface{}) (int, bool) {
+	switch value := value.(type) {
+	case json.Number:
+		i, err := value.Int64()
+		return int(i), err == nil
+	case float64:
+		return int(value), true
+	}
+	return 0, false
+}
+
+// escape escapes a reference token for use in a JSON pointer.
+func escape(token string) string {
+	return strings.Replace(strings.Replace(token, "~", "~0", -1), "/", "~1", -1)
+}
+
+// unescape reverses escape.
+func unescape(token string) string {
+	return strings.Replace(strings.Replace(token, "~1", "/", -1), "~0", "~", -1)
+}
@@ -0,0 +1,175 @@
+package jsonschema
+
+import (
+	"testing"
+)
+
+const testSchema = `{
+	"type": "object",
+	"required": ["title", "creator"],
+	"properties": {
+		"title": {"$ref": "#/definitions/langString"},
+		"creator": {"type": "array", "minItems": 1, "items": {"$ref": "#/definitions/agent"}},
+		"issued": {"type": "string", "format": "date"},
+		"keyword": {"type": "array", "items": {"type": "string", "minLength": 1}},
+		"count": {"type": "integer", "minimum": 0, "maximum": 10},
+		"state": {"enum": ["draft", "final"]},
+		"url": {"type": "string", "format": "uri"},
+		"code": {"type": "string", "pattern": "^[a-z]{2}$"},
+		"id": {"anyOf": [{"type": "string"}, {"type": "integer"}]}
+	},
+	"additionalProperties": false,
+	"definitions": {
+		"langString": {
+			"type": "object",
+			"minProperties": 1,
+			"additionalProperties": {"type": "string", "minLength": 1}
+		},
+		"agent": {
+			"type": "object",
+			"required": ["@type", "name"],
+			"properties": {
+				"@type": {"enum": ["Person", "Organization"]},
+				"name": {"oneOf": [{"type": "string", "minLength": 1}, {"$ref": "#/definitions/langString"}]}
+			}
+		}
+	}
+}`
+
+func TestCompile(t *testing.T) {
+	if _, err := Compile([]byte(testSchema)); err != nil {
+		t.Fatal(err)
+	}
+
+	var bad = []string{
+		`not json`,
+		`"string"`,
+		`{"$ref": "#/definitions/missing"}`,
+		`{"$ref": "http://example.com/schema.json"}`,
+		`{"pattern": "("}`,
+		`{"anyOf": []}`,
+		`{"properties": {"a": 1}}`,
+	}
+	for _, schema := range bad {
+		if _, err := Compile([]byte(schema)); err == nil {
+			t.Errorf("expected error for schema %s", schema)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	schema := MustCompile([]byte(testSchema))
+
+	var tests = []struct {
+		name string
+		doc  string
+		errs []Error
+	}{
+		{
+			name: "valid",
+			doc:  `{"title": {"en": "Title"}, "creator": [{"@type": "Person", "name": "Someone"}], "issued": "2019-04-01", "count": 3, "state": "draft", "url": "http://example.com/", "code": "fi", "id": 5}`,
+		},
+		{
+			name: "missing",
+			doc:  `{}`,
+			errs: []Error{{"/title", "required", ""}, {"/creator", "required", ""}},
+		},
+		{
+			name: "wrong type",
+			doc:  `{"title": "Title", "creator": []}`,
+			errs: []Error{{"/creator", "minItems", ""}, {"/title", "type", ""}},
+		},
+		{
+			name: "nested",
+			doc:  `{"title": {"en": ""}, "creator": [{"@type": "Robot", "name": {}}]}`,
+			errs: []Error{{"/creator/0/@type", "enum", ""}, {"/creator/0/name", "oneOf", ""}, {"/title/en", "minLength", ""}},
+		},
+		{
+			name: "formats and ranges",
+			doc:  `{"title": {"en": "T"}, "creator": [{"@type": "Organization", "name": "Org"}], "issued": "01.04.2019", "count": 11, "url": "example", "code": "FIN", "id": 1.5}`,
+			errs: []Error{{"/code", "pattern", ""}, {"/count", "maximum", ""}, {"/id", "anyOf", ""}, {"/issued", "format", ""}, {"/url", "format", ""}},
+		},
+		{
+			name: "integer",
+			doc:  `{"title": {"en": "T"}, "creator": [{"@type": "Person", "name": "P"}], "count": 2.5}`,
+			errs: []Error{{"/count", "type", ""}},
+		},
+		{
+			name: "additional",
+			doc:  `{"title": {"en": "T"}, "creator": [{"@type": "Person", "name": "P"}], "extra/field": true}`,
+			errs: []Error{{"/extra~1field", "additionalProperties", ""}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			errs, err := schema.Validate([]byte(test.doc))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(errs) != len(test.errs) {
+				t.Fatalf("expected %d errors, got %d: %v", len(test.errs), len(errs), errs)
+			}
+			for i := range errs {
+				if errs[i].Pointer != test.errs[i].Pointer || errs[i].Keyword != test.errs[i].Keyword {
+					t.Errorf("error %d: expected %s (%s), got %s (%s)", i, test.errs[i].Pointer, test.errs[i].Keyword, errs[i].Pointer, errs[i].Keyword)
+				}
+				if errs[i].Message == "" {
+					t.Errorf("error %d: empty message", i)
+				}
+			}
+		})
+	}
+
+	if _, err := schema.Validate([]byte(`{"title":`)); err != ErrInvalidDocument {
+		t.Errorf("expected ErrInvalidDocument, got %v", err)
+	}
+}
+
+func TestIfThenElse(t *testing.T) {
+	schema := MustCompile([]byte(`{
+		"if": {"properties": {"@type": {"const": "Person"}}},
+		"then": {"required": ["member_of"]},
+		"else": {"required": ["name"]}
+	}`))
+
+	var tests = []struct {
+		doc     string
+		pointer string
+	}{
+		{doc: `{"@type": "Person", "member_of": {}}`},
+		{doc: `{"@type": "Person"}`, pointer: "/member_of"},
+		{doc: `{"@type": "Organization", "name": "Org"}`},
+		{doc: `{"@type": "Organization"}`, pointer: "/name"},
+	}
+
+	for _, test := range tests {
+		errs, err := schema.Validate([]byte(test.doc))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if test.pointer == "" && len(errs) > 0 {
+			t.Errorf("%s: expected no errors, got %v", test.doc, errs)
+		}
+		if test.pointer != "" && (len(errs) != 1 || errs[0].Pointer != test.pointer) {
+			t.Errorf("%s: expected error at %s, got %v", test.doc, test.pointer, errs)
+		}
+	}
+}
+
+func TestBooleanSchema(t *testing.T) {
+	if errs := MustCompile([]byte(`true`)).ValidateValue("anything"); len(errs) != 0 {
+		t.Errorf("true schema should accept anything, got %v", errs)
+	}
+	if errs := MustCompile([]byte(`false`)).ValidateValue("anything"); len(errs) != 1 {
+		t.Errorf("false schema should reject anything, got %v", errs)
+	}
+}
+
+func TestPointerEscape(t *testing.T) {
+	for _, token := range []string{"plain", "a/b", "a~b", "~/~1"} {
+		if unescape(escape(token)) != token {
+			t.Errorf("round trip failed for %q: %q", token, escape(token))
+		}
+	}
+}
@@ -0,0 +1,76 @@
+// Package mergepatch implements JSON Merge Patch as defined in RFC 7396.
+/*
+example:
+	// set the English title, remove the Finnish one
+	patched, err := mergepatch.Apply(
+		[]byte(`{"title":{"en":"old","fi":"vanha"}}`),
+		[]byte(`{"title":{"en":"new","fi":null}}`),
+	)
+	// patched: {"title":{"en":"new"}}
+*/
+package mergepatch
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+)
+
+// ErrInvalidDocument is returned when the target document or patch can't be parsed.
+var ErrInvalidDocument = errors.New("invalid json document")
+
+// Apply applies a merge patch to the given JSON document and returns the resulting document.
+// An empty target document is treated as JSON null.
+func Apply(doc []byte, patch []byte) ([]byte, error) {
+	var target, p interface{}
+
+	if len(bytes.TrimSpace(doc)) > 0 {
+		if err := decode(doc, &target); err != nil {
+			return nil, ErrInvalidDocument
+		}
+	}
+
+	if err := decode(patch, &p); err != nil {
+		return nil, ErrInvalidDocument
+	}
+
+	return json.Marshal(merge(target, p))
+}
+
+// merge recursively merges a parsed patch into a parsed target, following the pseudo-code in RFC 7396, section 2.
+func merge(target interface{}, patch interface{}) interface{} {
+	patchObj, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	targetObj, ok := target.(map[string]interface{})
+	if !ok {
+		targetObj = make(map[string]interface{}, len(patchObj))
+	}
+
+	for key, value := range patchObj {
+		if value == nil {
+			delete(targetObj, key)
+			continue
+		}
+		targetObj[key] = merge(targetObj[key], value)
+	}
+
+	return targetObj
+}
+
+// decode unmarshals JSON keeping numbers as json.Number, so large integers and decimals survive the round trip unchanged.
+func decode(data []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+
+	// refuse trailing garbage after the first value
+	if dec.More() {
+		return ErrInvalidDocument
+	}
+	return nil
+}
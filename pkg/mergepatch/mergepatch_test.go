@@ -0,0 +1,85 @@
+package mergepatch
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// equalJson compares two JSON documents semantically.
+func equalJson(t *testing.T, a, b []byte) bool {
+	var va, vb interface{}
+	if err := json.Unmarshal(a, &va); err != nil {
+		t.Fatalf("can't parse %s: %s", a, err)
+	}
+	if err := json.Unmarshal(b, &vb); err != nil {
+		t.Fatalf("can't parse %s: %s", b, err)
+	}
+	return reflect.DeepEqual(va, vb)
+}
+
+// TestApply runs the test cases from RFC 7396, appendix A, and a few of our own.
+func TestApply(t *testing.T) {
+	tests := []struct {
+		doc    string
+		patch  string
+		result string
+	}{
+		{doc: `{"a":"b"}`, patch: `{"a":"c"}`, result: `{"a":"c"}`},
+		{doc: `{"a":"b"}`, patch: `{"b":"c"}`, result: `{"a":"b","b":"c"}`},
+		{doc: `{"a":"b"}`, patch: `{"a":null}`, result: `{}`},
+		{doc: `{"a":"b","b":"c"}`, patch: `{"a":null}`, result: `{"b":"c"}`},
+		{doc: `{"a":["b"]}`, patch: `{"a":"c"}`, result: `{"a":"c"}`},
+		{doc: `{"a":"c"}`, patch: `{"a":["b"]}`, result: `{"a":["b"]}`},
+		{doc: `{"a":{"b":"c"}}`, patch: `{"a":{"b":"d","c":null}}`, result: `{"a":{"b":"d"}}`},
+		{doc: `{"a":[{"b":"c"}]}`, patch: `{"a":[1]}`, result: `{"a":[1]}`},
+		{doc: `["a","b"]`, patch: `["c","d"]`, result: `["c","d"]`},
+		{doc: `{"a":"b"}`, patch: `["c"]`, result: `["c"]`},
+		{doc: `{"a":"foo"}`, patch: `null`, result: `null`},
+		{doc: `{"a":"foo"}`, patch: `"bar"`, result: `"bar"`},
+		{doc: `{"e":null}`, patch: `{"a":1}`, result: `{"e":null,"a":1}`},
+		{doc: `[1,2]`, patch: `{"a":"b","c":null}`, result: `{"a":"b"}`},
+		{doc: `{}`, patch: `{"a":{"bb":{"ccc":null}}}`, result: `{"a":{"bb":{}}}`},
+		// not from the RFC
+		{doc: ``, patch: `{"a":1}`, result: `{"a":1}`},
+		{doc: `{"n":12345678901234567890}`, patch: `{"m":0.1}`, result: `{"n":12345678901234567890,"m":0.1}`},
+		{
+			doc:    `{"research_dataset":{"title":{"en":"old","fi":"vanha"},"keyword":["a"]},"identifier":"x"}`,
+			patch:  `{"research_dataset":{"title":{"en":"new","fi":null}}}`,
+			result: `{"research_dataset":{"title":{"en":"new"},"keyword":["a"]},"identifier":"x"}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.doc+"+"+test.patch, func(t *testing.T) {
+			patched, err := Apply([]byte(test.doc), []byte(test.patch))
+			if err != nil {
+				t.Fatal("Apply():", err)
+			}
+			if !equalJson(t, patched, []byte(test.result)) {
+				t.Errorf("expected %s, got %s", test.result, patched)
+			}
+		})
+	}
+}
+
+func TestApplyInvalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		doc   string
+		patch string
+	}{
+		{name: "invalid document", doc: `{"a":`, patch: `{}`},
+		{name: "invalid patch", doc: `{}`, patch: `{"a":}`},
+		{name: "trailing data", doc: `{}`, patch: `{"a":1} {"b":2}`},
+		{name: "empty patch", doc: `{}`, patch: ``},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := Apply([]byte(test.doc), []byte(test.patch)); err != ErrInvalidDocument {
+				t.Errorf("expected %v, got %v", ErrInvalidDocument, err)
+			}
+		})
+	}
+}
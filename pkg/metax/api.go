@@ -50,6 +50,8 @@ type MetaxService struct {
 	disableHttps        bool
 	returnLatestVersion bool
 	logger              zerolog.Logger
+	retry               RetryPolicy
+	breaker             *Breaker
 
 	urlDatasets string
 
@@ -92,6 +94,8 @@ func NewMetaxService(host string, params ...MetaxOption) *MetaxService {
 		host:      host,
 		logger:    zerolog.Nop(),
 		userAgent: "qvain (Go-http-client/" + runtime.Version() + ")",
+		retry:     DefaultRetryPolicy,
+		breaker:   NewBreaker(DefaultBreakerThreshold, DefaultBreakerCooldown),
 		client: &http.Client{
 			Timeout: 5 * time.Second,
 			Transport: &http.Transport{
@@ -139,6 +143,11 @@ func (api *MetaxService) makeEndpoints(base string) {
 	api.urlDatasets = base + DatasetsEndpoint
 }
 
+// Breaker returns the service's circuit breaker, e.g. to publish it with the application's metrics.
+func (api *MetaxService) Breaker() *Breaker {
+	return api.breaker
+}
+
 type PaginatedResponse struct {
 	Count    int            `json:"count"`
 	Next     string         `json:"next"`
@@ -222,7 +231,7 @@ func (api *MetaxService) Datasets(params ...DatasetOption) (*PaginatedResponse,
 		api.logger.Printf("metax: paginated query processed in %v (count: %d)", time.Since(start), count)
 	}()
 
-	res, err := api.client.Do(req)
+	res, err := api.do(req, true)
 	if err != nil {
 		return nil, err
 	}
@@ -307,7 +316,7 @@ func (api *MetaxService) ReadStream(params ...DatasetOption) ([]MetaxRecord, err
 		api.logger.Printf("metax: stream query processed in %v", time.Since(start))
 	}()
 
-	res, err := api.client.Do(req)
+	res, err := api.do(req, true)
 	if err != nil {
 		return noRecords, err
 	}
@@ -388,7 +397,7 @@ func (api *MetaxService) ReadStreamChannel(ctx context.Context, params ...Datase
 		api.logger.Printf("metax: stream query processed in %v", time.Since(start))
 	}()
 
-	res, err := api.client.Do(req)
+	res, err := api.do(req.WithContext(ctx), true)
 	if err != nil {
 		return 0, nil, nil, err
 	}
@@ -497,7 +506,7 @@ func (api *MetaxService) Create(ctx context.Context, blob json.RawMessage) (json
 		api.logger.Printf("metax: create processed in %v", time.Since(start))
 	}()
 
-	res, err := api.client.Do(req.WithContext(ctx))
+	res, err := api.do(req.WithContext(ctx), false)
 	if err != nil {
 		return nil, err
 	}
@@ -563,7 +572,7 @@ func (api *MetaxService) Store(ctx context.Context, blob json.RawMessage) (json.
 		api.logger.Printf("metax: create processed in %v", time.Since(start))
 	}()
 
-	res, err := api.client.Do(req.WithContext(ctx))
+	res, err := api.do(req.WithContext(ctx), false)
 	if err != nil {
 		return nil, err
 	}
@@ -590,7 +599,7 @@ func (api *MetaxService) Store(ctx context.Context, blob json.RawMessage) (json.
 			fmt.Println("created new version:", newId)
 			//if api.returnLatestVersion {
 			if false {
-				newVersion, err := api.GetId(newId)
+				newVersion, err := api.GetId(ctx, newId)
 				fmt.Println("called newVersion", err)
 				fmt.Printf("old: %s\n\n", body)
 				fmt.Printf("new: %s\n\n", newVersion)
@@ -629,7 +638,7 @@ func (api *MetaxService) Store(ctx context.Context, blob json.RawMessage) (json.
 }
 
 // GetId queries the dataset endpoint for a dataset with the given id.
-func (api *MetaxService) GetId(id string) (json.RawMessage, error) {
+func (api *MetaxService) GetId(ctx context.Context, id string) (json.RawMessage, error) {
 	req, err := http.NewRequest("GET", api.UrlForId(id), nil)
 	if err != nil {
 		return nil, err
@@ -637,7 +646,7 @@ func (api *MetaxService) GetId(id string) (json.RawMessage, error) {
 	api.writeApiHeaders(req)
 
 	api.logger.Printf("request headers: %+v\n", req)
-	res, err := api.client.Do(req)
+	res, err := api.do(req.WithContext(ctx), true)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,138 @@
+package metax
+
+import (
+	"encoding/json"
+	"expvar"
+	"sync"
+	"time"
+)
+
+// Circuit breaker states.
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half-open"
+)
+
+const (
+	// DefaultBreakerThreshold is the number of failed requests in a row after which the circuit opens.
+	DefaultBreakerThreshold = 5
+
+	// DefaultBreakerCooldown is how long the circuit stays open before a single request is let through to probe Metax.
+	DefaultBreakerCooldown = 30 * time.Second
+)
+
+// Breaker is a circuit breaker that fails requests fast while Metax is down instead of letting each wait for a timeout.
+// Connection errors and 5xx responses count as failures, requests cancelled by the caller don't; after threshold failures in a row the circuit opens,
+// and after the cooldown one request is let through: if it succeeds the circuit closes, otherwise it opens again.
+//
+// A Breaker is an expvar.Var, so its state and counters can be published with the application's metrics.
+type Breaker struct {
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+
+	mu       sync.Mutex
+	state    string
+	failures int
+	opened   time.Time
+
+	// counters
+	requests expvar.Int
+	retries  expvar.Int
+	errors   expvar.Int
+	rejected expvar.Int
+	trips    expvar.Int
+}
+
+// NewBreaker returns a closed circuit breaker.
+func NewBreaker(threshold int, cooldown time.Duration) *Breaker {
+	if threshold < 1 {
+		threshold = 1
+	}
+	return &Breaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		now:       time.Now,
+		state:     BreakerClosed,
+	}
+}
+
+// Allow returns ErrCircuitOpen if a request should not be sent.
+// In the half-open state only one request is allowed until its outcome is recorded.
+func (b *Breaker) Allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		if b.now().Sub(b.opened) < b.cooldown {
+			b.rejected.Add(1)
+			return ErrCircuitOpen
+		}
+		b.state = BreakerHalfOpen
+	case BreakerHalfOpen:
+		b.rejected.Add(1)
+		return ErrCircuitOpen
+	}
+
+	b.requests.Add(1)
+	return nil
+}
+
+// Record records the outcome of a request that was allowed.
+func (b *Breaker) Record(ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if ok {
+		b.state, b.failures = BreakerClosed, 0
+		return
+	}
+
+	b.errors.Add(1)
+	b.failures++
+	if b.state == BreakerHalfOpen || b.failures >= b.threshold {
+		if b.state != BreakerOpen {
+			b.trips.Add(1)
+		}
+		b.state, b.opened = BreakerOpen, b.now()
+	}
+}
+
+// Release gives up a request that was allowed but ended without telling anything about Metax, such as one cancelled by its caller.
+// It counts neither as success nor as failure; a half-open circuit lets the next request probe instead.
+func (b *Breaker) Release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == BreakerHalfOpen {
+		// the cooldown has passed, so the next Allow probes again
+		b.state = BreakerOpen
+	}
+}
+
+// State returns the current state of the circuit.
+func (b *Breaker) State() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// String returns the state and counters as JSON; it satisfies expvar.Var.
+func (b *Breaker) String() string {
+	b.mu.Lock()
+	state, failures := b.state, b.failures
+	b.mu.Unlock()
+
+	out, _ := json.Marshal(struct {
+		State    string `json:"state"`
+		Failures int    `json:"failures"`
+		Requests int64  `json:"requests"`
+		Retries  int64  `json:"retries"`
+		Errors   int64  `json:"errors"`
+		Rejected int64  `json:"rejected"`
+		Trips    int64  `json:"trips"`
+	}{state, failures, b.requests.Value(), b.retries.Value(), b.errors.Value(), b.rejected.Value(), b.trips.Value()})
+	return string(out)
+}
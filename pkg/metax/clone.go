@@ -0,0 +1,92 @@
+package metax
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/wvh/uuid"
+)
+
+// ErrInvalidBlob means a Metax dataset is not a JSON object.
+var ErrInvalidBlob = errors.New("dataset is not a JSON object")
+
+// cloneStripKeys are the top-level keys that Metax manages for a published dataset and that should not be copied to a new draft.
+var cloneStripKeys = []string{
+	"id",
+	IdentifierKey,
+	"state",
+	"removed",
+	"deprecated",
+	"date_created",
+	"date_modified",
+	"date_removed",
+	"date_deprecated",
+	"service_created",
+	"service_modified",
+	"user_created",
+	"user_modified",
+	"preservation_state",
+	"preservation_state_modified",
+	"preservation_description",
+	"preservation_reason_description",
+	"preservation_identifier",
+	"preservation_dataset_version",
+	"preservation_dataset_origin_version",
+	"previous_dataset_version",
+	"next_dataset_version",
+	"dataset_version_set",
+	NewVersionKey,
+	"alternate_record_set",
+}
+
+// cloneStripResearchKeys are the identifiers inside the research dataset that Metax assigns on publication.
+var cloneStripResearchKeys = []string{
+	"preferred_identifier",
+	"metadata_version_identifier",
+}
+
+// CloneBlob makes a copy of a Metax dataset for use as a new unpublished draft with the given Qvain id.
+// It removes Metax identifiers, preservation state and version links, and points the editor object to the new record.
+func CloneBlob(blob []byte, newid uuid.UUID) ([]byte, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(blob, &fields); err != nil || fields == nil {
+		return nil, ErrInvalidBlob
+	}
+
+	for _, key := range cloneStripKeys {
+		delete(fields, key)
+	}
+
+	if raw, ok := fields["research_dataset"]; ok {
+		var research map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &research); err == nil && research != nil {
+			for _, key := range cloneStripResearchKeys {
+				delete(research, key)
+			}
+			cleaned, err := json.Marshal(research)
+			if err != nil {
+				return nil, err
+			}
+			fields["research_dataset"] = cleaned
+		}
+	}
+
+	// keep other editor fields such as the owner, but make sure the record id and identifier are ours
+	editor := make(map[string]json.RawMessage)
+	if raw, ok := fields[EditorKey]; ok {
+		json.Unmarshal(raw, &editor)
+		if editor == nil {
+			editor = make(map[string]json.RawMessage)
+		}
+	}
+	editor[QvainIdentifierKey], _ = json.Marshal(appIdent)
+	editor[QvainIdKey], _ = json.Marshal(newid.String())
+
+	editorJson, err := json.Marshal(editor)
+	if err != nil {
+		return nil, err
+	}
+	fields[EditorKey] = editorJson
+
+	return json.Marshal(fields)
+}
@@ -0,0 +1,38 @@
+package metax
+
+import (
+	"testing"
+
+	"github.com/tidwall/gjson"
+	"github.com/wvh/uuid"
+)
+
+func TestCloneBlob(t *testing.T) {
+	blob := readTestFile(t, "published.json")
+	newid := uuid.MustNewUUID()
+
+	cloned, err := CloneBlob(blob, newid)
+	if err != nil {
+		t.Fatal("CloneBlob():", err)
+	}
+
+	for _, key := range []string{"id", "identifier", "preservation_state", "previous_dataset_version", "dataset_version_set", "date_created", "research_dataset.preferred_identifier", "research_dataset.metadata_version_identifier"} {
+		if gjson.GetBytes(cloned, key).Exists() {
+			t.Errorf("key %q should have been removed", key)
+		}
+	}
+
+	for _, key := range []string{"data_catalog", "metadata_provider_user", "research_dataset.title.en", "research_dataset.creator"} {
+		if gjson.GetBytes(blob, key).Raw != gjson.GetBytes(cloned, key).Raw {
+			t.Errorf("key %q should have been copied", key)
+		}
+	}
+
+	if GetQvainId(cloned) != newid.String() {
+		t.Errorf("expected editor record id %s, got %q", newid, GetQvainId(cloned))
+	}
+
+	if _, err := CloneBlob([]byte(`[]`), newid); err != ErrInvalidBlob {
+		t.Errorf("expected %v, got %v", ErrInvalidBlob, err)
+	}
+}
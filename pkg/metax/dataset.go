@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/NatLibFi/qvain-api/pkg/mergepatch"
 	"github.com/NatLibFi/qvain-api/pkg/models"
 	"github.com/wvh/uuid"
 )
@@ -54,11 +55,24 @@ func (dataset *MetaxDataset) CreateData(family int, schema string, blob []byte,
 		return errors.New("need schema family")
 	}
 
-	if _, ok := parsedTemplates[schema]; !ok {
-		return errors.New("unknown schema")
+	var org string
+	if extra != nil {
+		org = extra["org"]
+	}
+
+	template, ok := CurrentTemplates().Get(schema, org)
+	if !ok {
+		return ErrUnknownSchema
 	}
 
-	template := parsedTemplates[schema]
+	// defaults from the template's research_dataset, if any, are overridden by the user's data
+	if defaults, ok := template["research_dataset"]; ok && defaults != nil && len(*defaults) > 0 && string(*defaults) != "{}" {
+		merged, err := mergepatch.Apply(*defaults, blob)
+		if err != nil {
+			return err
+		}
+		blob = merged
+	}
 
 	// don't set Creator and Owner since we don't update the json if they change
 	editor := &Editor{
@@ -101,8 +115,8 @@ func (dataset *MetaxDataset) UpdateData(family int, schema string, blob []byte,
 		return errors.New("need schema family")
 	}
 
-	if _, ok := parsedTemplates[schema]; !ok {
-		return errors.New("unknown schema")
+	if !CurrentTemplates().Has(schema) {
+		return ErrUnknownSchema
 	}
 
 	// don't set Creator and Owner since we don't update the json if they change
@@ -270,10 +284,10 @@ func (raw MetaxRawRecord) ToQvain() (*models.Dataset, bool, error) {
 
 	qdataset := new(models.Dataset)
 
-	if isNew {
-		qdataset.Created = timeOrNow(mrec.DateCreated)
-		qdataset.Modified = timeOrNow(mrec.DateModified)
-	} else {
+	// timestamps are needed if the dataset has to be created locally, even if it has a Qvain id
+	qdataset.Created = timeOrNow(mrec.DateCreated)
+	qdataset.Modified = timeOrNow(mrec.DateModified)
+	if !isNew {
 		qdataset.Id = *qid
 	}
 	qdataset.SetData(MetaxDatasetFamily, "metax", raw.RawMessage)
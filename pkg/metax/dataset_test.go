@@ -124,8 +124,8 @@ func TestMetaxDatasetParsing(t *testing.T) {
 				t.Errorf("id doesn't match: expected %v, got %v", test.id, dataset.Id)
 			}
 
-			// only check creation time for new datasets; we don't care about existing ones
-			if test.isNew && dataset.Created.IsZero() {
+			// existing datasets need a creation time too, in case they have to be created locally during sync
+			if dataset.Created.IsZero() {
 				t.Error("dataset.Created should be set to upstream creation time but is zero")
 			}
 		})
@@ -10,6 +10,9 @@ var (
 	ErrNotFound           = errors.New("not found")
 	ErrIdRequired         = errors.New("dataset without id and not allowed to create")
 	ErrInvalidId          = errors.New("invalid dataset id")
+	ErrUnknownSchema      = errors.New("unknown schema")
+	ErrInvalidTemplate    = errors.New("template must be a JSON object")
+	ErrCircuitOpen        = errors.New("metax unavailable: too many failed requests")
 )
 
 // LinkingError is a custom error type that adds the missing field name.
@@ -0,0 +1,178 @@
+package metax
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Defaults for retrying idempotent requests.
+const (
+	DefaultRetryAttempts  = 3
+	DefaultRetryBaseDelay = 250 * time.Millisecond
+	DefaultRetryMaxDelay  = 5 * time.Second
+)
+
+// RetryPolicy sets how often and how long to wait before idempotent requests are sent again.
+type RetryPolicy struct {
+	// Attempts is the total number of tries; 1 disables retries.
+	Attempts int
+
+	// BaseDelay is doubled for each retry up to MaxDelay; the actual delay is jittered between half and all of it.
+	// A Retry-After header longer than MaxDelay is not waited for.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+}
+
+// DefaultRetryPolicy is used unless the service is created with WithRetry.
+var DefaultRetryPolicy = RetryPolicy{
+	Attempts:  DefaultRetryAttempts,
+	BaseDelay: DefaultRetryBaseDelay,
+	MaxDelay:  DefaultRetryMaxDelay,
+}
+
+// WithRetry sets the retry policy for idempotent requests.
+func WithRetry(policy RetryPolicy) MetaxOption {
+	return func(svc *MetaxService) {
+		if policy.Attempts < 1 {
+			policy.Attempts = 1
+		}
+		svc.retry = policy
+	}
+}
+
+// WithBreaker sets the number of failures in a row after which the circuit breaker opens, and how long it stays open.
+func WithBreaker(threshold int, cooldown time.Duration) MetaxOption {
+	return func(svc *MetaxService) {
+		svc.breaker = NewBreaker(threshold, cooldown)
+	}
+}
+
+// backoff returns the jittered delay before the given (1-based) retry.
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	delay := p.MaxDelay
+	if retry <= 20 {
+		if d := p.BaseDelay << uint(retry-1); d > 0 && d < p.MaxDelay {
+			delay = d
+		}
+	}
+	if delay <= 0 {
+		return 0
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// isRetryable tells if a request that got the given response or error may succeed when sent again.
+func isRetryable(res *http.Response, err error) bool {
+	if err != nil {
+		return err != ErrCircuitOpen
+	}
+	switch res.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// NotReceived tells if a request that failed with the given error never reached Metax, or was turned away without being processed,
+// so that sending it again can't apply it twice. It is conservative: errors it can't be sure about, such as timeouts, return false.
+func NotReceived(err error) bool {
+	if err == nil {
+		return false
+	}
+	if err == ErrCircuitOpen || err == errEmptyDataset {
+		return true
+	}
+	if apiErr, ok := err.(*ApiError); ok {
+		// refused before any work was done
+		return apiErr.StatusCode() == http.StatusTooManyRequests || apiErr.StatusCode() == http.StatusServiceUnavailable
+	}
+
+	// nothing was sent if the connection couldn't be made, including failed name lookups
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// parseRetryAfter parses the value of a Retry-After header, either in seconds or as HTTP date, into a delay from now.
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+
+	if secs, err := strconv.Atoi(value); err == nil {
+		if secs < 0 {
+			return 0, false
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+
+	t, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+	if d := t.Sub(now); d > 0 {
+		return d, true
+	}
+	return 0, true
+}
+
+// do sends a request through the circuit breaker.
+// If retry is set, the request is sent again after connection errors and 429, 502, 503 and 504 responses, honouring Retry-After;
+// only use it for requests without body that can safely be repeated.
+func (api *MetaxService) do(req *http.Request, retry bool) (*http.Response, error) {
+	attempts := 1
+	if retry {
+		attempts = api.retry.Attempts
+	}
+
+	for attempt := 1; ; attempt++ {
+		if err := api.breaker.Allow(); err != nil {
+			return nil, err
+		}
+
+		res, err := api.client.Do(req)
+		if req.Context().Err() != nil {
+			// the caller gave up, which says nothing about Metax
+			api.breaker.Release()
+		} else {
+			api.breaker.Record(err == nil && res.StatusCode < 500)
+		}
+
+		if attempt >= attempts || !isRetryable(res, err) || req.Context().Err() != nil {
+			return res, err
+		}
+
+		delay := api.retry.backoff(attempt)
+		if res != nil {
+			if after, ok := parseRetryAfter(res.Header.Get("Retry-After"), time.Now()); ok {
+				if after > api.retry.MaxDelay {
+					// Metax asks for a longer break than we're willing to wait
+					return res, nil
+				}
+				delay = after
+			}
+			api.drainBody(res.Body)
+			res.Body.Close()
+		}
+
+		if err != nil {
+			api.logger.Printf("metax: %s %s failed, retrying in %v: %s", req.Method, req.URL.Path, delay, err)
+		} else {
+			api.logger.Printf("metax: %s %s returned %d, retrying in %v", req.Method, req.URL.Path, res.StatusCode, delay)
+		}
+		api.breaker.retries.Add(1)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+	}
+}
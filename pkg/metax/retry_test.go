@@ -0,0 +1,259 @@
+package metax
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testService returns a service talking to a test server with short retry delays.
+func testService(t *testing.T, handler http.HandlerFunc, params ...MetaxOption) *MetaxService {
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	params = append([]MetaxOption{DisableHttps, WithRetry(RetryPolicy{Attempts: 3, BaseDelay: time.Millisecond, MaxDelay: 50 * time.Millisecond})}, params...)
+	return NewMetaxService(strings.TrimPrefix(srv.URL, "http://"), params...)
+}
+
+func TestRetry(t *testing.T) {
+	var tests = []struct {
+		name     string
+		statuses []int
+		header   string
+		calls    int32
+		fail     bool
+	}{
+		{name: "ok", statuses: []int{200}, calls: 1},
+		{name: "recovers", statuses: []int{503, 502, 200}, calls: 3},
+		{name: "gives up", statuses: []int{503, 503, 503, 200}, calls: 3, fail: true},
+		{name: "client error", statuses: []int{404, 200}, calls: 1, fail: true},
+		{name: "rate limited", statuses: []int{429, 200}, header: "0", calls: 2},
+		{name: "retry-after too long", statuses: []int{503, 200}, header: "120", calls: 1, fail: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var calls int32
+			api := testService(t, func(w http.ResponseWriter, r *http.Request) {
+				n := atomic.AddInt32(&calls, 1)
+				w.Header().Set("Content-Type", "application/json")
+				if test.header != "" {
+					w.Header().Set("Retry-After", test.header)
+				}
+				w.WriteHeader(test.statuses[n-1])
+				w.Write([]byte(`{"identifier":"x"}`))
+			})
+
+			_, err := api.GetId(context.Background(), "x")
+			if test.fail && err == nil {
+				t.Error("expected error")
+			}
+			if !test.fail && err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+			if calls != test.calls {
+				t.Errorf("expected %d calls, got %d", test.calls, calls)
+			}
+		})
+	}
+}
+
+func TestStoreNotRetried(t *testing.T) {
+	var calls int32
+	api := testService(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(`{}`))
+	})
+
+	if _, err := api.Store(context.Background(), []byte(`{"research_dataset":{}}`)); err == nil {
+		t.Error("expected error")
+	}
+	if calls != 1 {
+		t.Errorf("expected 1 call, got %d", calls)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2019, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	var tests = []struct {
+		value string
+		delay time.Duration
+		ok    bool
+	}{
+		{value: "", ok: false},
+		{value: "3", delay: 3 * time.Second, ok: true},
+		{value: " 0 ", delay: 0, ok: true},
+		{value: "-1", ok: false},
+		{value: "soon", ok: false},
+		{value: "Wed, 01 May 2019 12:00:30 GMT", delay: 30 * time.Second, ok: true},
+		{value: "Wed, 01 May 2019 11:00:00 GMT", delay: 0, ok: true},
+	}
+
+	for _, test := range tests {
+		delay, ok := parseRetryAfter(test.value, now)
+		if ok != test.ok || delay != test.delay {
+			t.Errorf("%q: expected %v %t, got %v %t", test.value, test.delay, test.ok, delay, ok)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	policy := RetryPolicy{Attempts: 5, BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+
+	var tests = []struct {
+		retry int
+		max   time.Duration
+	}{
+		{retry: 1, max: 100 * time.Millisecond},
+		{retry: 2, max: 200 * time.Millisecond},
+		{retry: 4, max: 800 * time.Millisecond},
+		{retry: 5, max: time.Second},
+		{retry: 50, max: time.Second},
+	}
+
+	for _, test := range tests {
+		for i := 0; i < 10; i++ {
+			delay := policy.backoff(test.retry)
+			if delay < test.max/2 || delay > test.max {
+				t.Errorf("retry %d: expected delay between %v and %v, got %v", test.retry, test.max/2, test.max, delay)
+			}
+		}
+	}
+}
+
+func TestBreaker(t *testing.T) {
+	now := time.Now()
+	b := NewBreaker(2, time.Minute)
+	b.now = func() time.Time { return now }
+
+	// closed: failures below the threshold don't open the circuit, a success resets the count
+	b.Record(false)
+	b.Record(true)
+	b.Record(false)
+	if b.State() != BreakerClosed {
+		t.Fatalf("expected %s, got %s", BreakerClosed, b.State())
+	}
+
+	b.Record(false)
+	if b.State() != BreakerOpen {
+		t.Fatalf("expected %s, got %s", BreakerOpen, b.State())
+	}
+	if b.Allow() != ErrCircuitOpen {
+		t.Fatal("expected open circuit to reject requests")
+	}
+
+	// after the cooldown one probe is let through
+	now = now.Add(time.Minute)
+	if err := b.Allow(); err != nil {
+		t.Fatalf("expected probe to be allowed, got %s", err)
+	}
+	if b.Allow() != ErrCircuitOpen {
+		t.Fatal("expected only one probe")
+	}
+
+	// failed probe opens the circuit again, a successful one closes it
+	b.Record(false)
+	if b.State() != BreakerOpen {
+		t.Fatalf("expected %s, got %s", BreakerOpen, b.State())
+	}
+	now = now.Add(time.Minute)
+	b.Allow()
+	b.Record(true)
+	if b.State() != BreakerClosed {
+		t.Fatalf("expected %s, got %s", BreakerClosed, b.State())
+	}
+
+	if !strings.Contains(b.String(), `"trips":2`) || !strings.Contains(b.String(), `"rejected":2`) {
+		t.Errorf("unexpected metrics: %s", b)
+	}
+}
+
+func TestBreakerFailsFast(t *testing.T) {
+	var calls int32
+	api := testService(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusBadGateway)
+	}, WithBreaker(3, time.Minute))
+
+	api.GetId(context.Background(), "x")
+	if calls != 3 {
+		t.Fatalf("expected 3 calls, got %d", calls)
+	}
+	if _, err := api.GetId(context.Background(), "x"); err != ErrCircuitOpen {
+		t.Errorf("expected %q, got %v", ErrCircuitOpen, err)
+	}
+	if calls != 3 {
+		t.Errorf("expected no more calls, got %d", calls)
+	}
+}
+
+func TestBreakerIgnoresCancelled(t *testing.T) {
+	release := make(chan struct{})
+	t.Cleanup(func() { close(release) })
+	api := testService(t, func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}, WithBreaker(2, time.Minute))
+
+	for i := 0; i < 5; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+		_, err := api.GetId(ctx, "x")
+		cancel()
+		if err == nil {
+			t.Fatal("expected error")
+		}
+	}
+	if state := api.Breaker().State(); state != BreakerClosed {
+		t.Errorf("expected cancelled requests to leave the circuit %s, got %s", BreakerClosed, state)
+	}
+
+	// a cancelled probe lets the next request probe again
+	now := time.Now()
+	b := NewBreaker(1, time.Minute)
+	b.now = func() time.Time { return now }
+	b.Record(false)
+	now = now.Add(time.Minute)
+	b.Allow()
+	b.Release()
+	if err := b.Allow(); err != nil {
+		t.Errorf("expected new probe after release, got %s", err)
+	}
+}
+
+func TestNotReceived(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	srv.Close()
+	_, dialErr := http.Get(srv.URL)
+
+	var tests = []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{name: "nil", err: nil, expected: false},
+		{name: "circuit open", err: ErrCircuitOpen, expected: true},
+		{name: "connection refused", err: dialErr, expected: true},
+		{name: "unavailable", err: &ApiError{"API returned error", nil, 503}, expected: true},
+		{name: "rate limited", err: &ApiError{"API returned error", nil, 429}, expected: true},
+		{name: "server error", err: &ApiError{"API returned error", nil, 500}, expected: false},
+		{name: "gateway timeout", err: &ApiError{"API returned error", nil, 504}, expected: false},
+		{name: "deadline", err: context.DeadlineExceeded, expected: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := NotReceived(test.err); got != test.expected {
+				t.Errorf("NotReceived(%v): expected %v, got %v", test.err, test.expected, got)
+			}
+		})
+	}
+}
@@ -1,7 +1,15 @@
 package metax
 
 import (
+	"embed"
 	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
 )
 
 const (
@@ -9,37 +17,203 @@ const (
 	SchemaAtt = "metax-att"
 )
 
+// builtinTemplates holds the base templates for empty Metax datasets, named `<schema>.json`.
+//
+//go:embed templates/*.json
+var builtinTemplates embed.FS
+
+// Templates holds the base JSON for new Metax datasets by schema name and, optionally, organisation.
+//
+// A template's `research_dataset`, if not empty, provides defaults (e.g. a licence) that the user's data is merged into.
+// The zero value is not usable; create one with NewTemplates or BuiltinTemplates. It is safe for concurrent use.
+type Templates struct {
+	mu        sync.RWMutex
+	templates map[templateKey]map[string]*json.RawMessage
+}
+
+// templateKey identifies a template; org is empty for the schema's default template.
+type templateKey struct {
+	schema string
+	org    string
+}
+
+// TemplateInfo describes a template in a Templates collection.
+type TemplateInfo struct {
+	Schema      string `json:"schema"`
+	Org         string `json:"org,omitempty"`
+	DataCatalog string `json:"data_catalog,omitempty"`
+}
+
 var (
-	// templates variable contains the base templates for empty metax datasets.
-	templates = map[string]json.RawMessage{
-		SchemaIda: json.RawMessage(`
-		{
-			"data_catalog": "urn:nbn:fi:att:data-catalog-ida",
-			"metadata_provider_org": "",
-			"metadata_provider_user": "",
-			"research_dataset": {}
-		}`),
-		SchemaAtt: json.RawMessage(`
-		{
-			"data_catalog": "urn:nbn:fi:att:data-catalog-att",
-			"metadata_provider_org": "",
-			"metadata_provider_user": "",
-			"research_dataset": {}
-		}`),
-	}
-
-	// parsedTemplates contains the pre-parsed unserialised json for the templates.
-	parsedTemplates = map[string]map[string]*json.RawMessage{}
+	// current is the template collection used for creating datasets.
+	current   = BuiltinTemplates()
+	currentMu sync.RWMutex
 )
 
-func init() {
-	// preparse on start-up
-	for schema, template := range templates {
-		var parsed map[string]*json.RawMessage
-		err := json.Unmarshal(template, &parsed)
+// CurrentTemplates returns the template collection used for creating datasets.
+func CurrentTemplates() *Templates {
+	currentMu.RLock()
+	defer currentMu.RUnlock()
+	return current
+}
+
+// SetTemplates replaces the template collection used for creating datasets, e.g. after reloading templates.
+func SetTemplates(templates *Templates) {
+	currentMu.Lock()
+	current = templates
+	currentMu.Unlock()
+}
+
+// NewTemplates returns an empty template collection.
+func NewTemplates() *Templates {
+	return &Templates{templates: make(map[templateKey]map[string]*json.RawMessage)}
+}
+
+// BuiltinTemplates returns a template collection with the templates compiled into the application.
+func BuiltinTemplates() *Templates {
+	templates := NewTemplates()
+
+	files, err := builtinTemplates.ReadDir("templates")
+	if err != nil {
+		panic(err)
+	}
+	for _, file := range files {
+		data, err := builtinTemplates.ReadFile("templates/" + file.Name())
 		if err != nil {
 			panic(err)
 		}
-		parsedTemplates[schema] = parsed
+		if err := templates.Add(strings.TrimSuffix(file.Name(), ".json"), "", data); err != nil {
+			panic(file.Name() + ": " + err.Error())
+		}
+	}
+
+	return templates
+}
+
+// Add parses a template and adds it for the given schema and organisation, replacing any existing template.
+// An empty organisation sets the schema's default template.
+func (templates *Templates) Add(schema string, org string, data []byte) error {
+	var parsed map[string]*json.RawMessage
+	if err := json.Unmarshal(data, &parsed); err != nil || parsed == nil {
+		return ErrInvalidTemplate
 	}
+
+	templates.mu.Lock()
+	templates.templates[templateKey{schema, org}] = parsed
+	templates.mu.Unlock()
+	return nil
+}
+
+// LoadDir adds the templates in a directory. Files `<schema>.json` set the default template for a schema;
+// files `<org>/<schema>.json` in a sub-directory set the template for users of the given organisation.
+// It returns the number of templates loaded.
+func (templates *Templates) LoadDir(dir string) (int, error) {
+	var n int
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || filepath.Ext(path) != ".json" {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		var org string
+		if parts := strings.Split(filepath.ToSlash(rel), "/"); len(parts) == 2 {
+			org = parts[0]
+		} else if len(parts) > 2 {
+			return nil
+		}
+
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if err := templates.Add(strings.TrimSuffix(info.Name(), ".json"), org, data); err != nil {
+			return errors.New(path + ": " + err.Error())
+		}
+		n++
+		return nil
+	})
+	return n, err
+}
+
+// Has returns true if there is a template for a schema, be it the default one or one for an organisation.
+func (templates *Templates) Has(schema string) bool {
+	templates.mu.RLock()
+	defer templates.mu.RUnlock()
+	for key := range templates.templates {
+		if key.schema == schema {
+			return true
+		}
+	}
+	return false
+}
+
+// Get returns a copy of the template for a schema and organisation, falling back to the schema's default template.
+// The copy can be modified freely.
+func (templates *Templates) Get(schema string, org string) (map[string]*json.RawMessage, bool) {
+	templates.mu.RLock()
+	template, ok := templates.templates[templateKey{schema, org}]
+	if !ok {
+		template, ok = templates.templates[templateKey{schema, ""}]
+	}
+	templates.mu.RUnlock()
+	if !ok {
+		return nil, false
+	}
+
+	copied := make(map[string]*json.RawMessage, len(template))
+	for k, v := range template {
+		copied[k] = v
+	}
+	return copied, true
+}
+
+// List describes the templates in the collection, sorted by schema and organisation.
+func (templates *Templates) List() []TemplateInfo {
+	templates.mu.RLock()
+	list := make([]TemplateInfo, 0, len(templates.templates))
+	for key, template := range templates.templates {
+		list = append(list, TemplateInfo{Schema: key.schema, Org: key.org, DataCatalog: templateCatalog(template)})
+	}
+	templates.mu.RUnlock()
+
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Schema != list[j].Schema {
+			return list[i].Schema < list[j].Schema
+		}
+		return list[i].Org < list[j].Org
+	})
+	return list
+}
+
+// SchemaForCatalog returns the schema whose template uses the given data catalog identifier, or the empty string if there is none.
+func (templates *Templates) SchemaForCatalog(catalog string) string {
+	if catalog == "" {
+		return ""
+	}
+	for _, info := range templates.List() {
+		if info.DataCatalog == catalog {
+			return info.Schema
+		}
+	}
+	return ""
+}
+
+// templateCatalog returns the data catalog identifier set in a template, if any.
+func templateCatalog(template map[string]*json.RawMessage) string {
+	var catalog string
+	if raw, ok := template["data_catalog"]; ok && raw != nil && json.Unmarshal(*raw, &catalog) == nil {
+		return catalog
+	}
+	return ""
+}
+
+// SchemaForCatalog returns the schema whose current template uses the given data catalog identifier, or the empty string if there is none.
+func SchemaForCatalog(catalog string) string {
+	return CurrentTemplates().SchemaForCatalog(catalog)
 }
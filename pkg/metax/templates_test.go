@@ -0,0 +1,162 @@
+package metax
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/tidwall/gjson"
+	"github.com/wvh/uuid"
+)
+
+func TestBuiltinTemplates(t *testing.T) {
+	templates := BuiltinTemplates()
+
+	if !templates.Has(SchemaIda) || !templates.Has(SchemaAtt) {
+		t.Fatalf("missing built-in templates: %+v", templates.List())
+	}
+	if schema := templates.SchemaForCatalog("urn:nbn:fi:att:data-catalog-att"); schema != SchemaAtt {
+		t.Errorf("expected %s for ATT catalog, got %q", SchemaAtt, schema)
+	}
+	if schema := templates.SchemaForCatalog("urn:nbn:fi:att:data-catalog-nope"); schema != "" {
+		t.Errorf("expected no schema for unknown catalog, got %q", schema)
+	}
+}
+
+func TestTemplatesGet(t *testing.T) {
+	templates := BuiltinTemplates()
+	if err := templates.Add(SchemaIda, "csc.fi", []byte(`{"data_catalog": "urn:nbn:fi:att:data-catalog-ida", "research_dataset": {"language": []}}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := templates.Add(SchemaIda, "", []byte(`[]`)); err != ErrInvalidTemplate {
+		t.Errorf("expected ErrInvalidTemplate for array, got %v", err)
+	}
+
+	org, ok := templates.Get(SchemaIda, "csc.fi")
+	if !ok || org["research_dataset"] == nil || string(*org["research_dataset"]) != `{"language": []}` {
+		t.Errorf("expected organisation template, got %v", org)
+	}
+
+	fallback, ok := templates.Get(SchemaIda, "helsinki.fi")
+	if !ok || string(*fallback["research_dataset"]) != "{}" {
+		t.Errorf("expected default template, got %v", fallback)
+	}
+
+	// changing a copy must not change the template
+	delete(fallback, "data_catalog")
+	if again, _ := templates.Get(SchemaIda, ""); again["data_catalog"] == nil {
+		t.Error("template was modified through copy")
+	}
+
+	if _, ok := templates.Get("nope", ""); ok {
+		t.Error("expected no template for unknown schema")
+	}
+}
+
+func TestTemplatesHasOrgOnly(t *testing.T) {
+	templates := BuiltinTemplates()
+	if err := templates.Add("metax-org", "csc.fi", []byte(`{"data_catalog": "urn:nbn:fi:att:data-catalog-org"}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	if !templates.Has("metax-org") {
+		t.Error("expected schema with only an organisation template to be known")
+	}
+	if templates.Has("nope") {
+		t.Error("expected unknown schema")
+	}
+}
+
+func TestTemplatesLoadDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "qvain-templates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := os.Mkdir(filepath.Join(dir, "csc.fi"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"metax-pas.json":        `{"data_catalog": "urn:nbn:fi:att:data-catalog-pas", "research_dataset": {}}`,
+		"csc.fi/metax-ida.json": `{"data_catalog": "urn:nbn:fi:att:data-catalog-ida", "research_dataset": {}}`,
+		"README.txt":            `not a template`,
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	templates := BuiltinTemplates()
+	n, err := templates.LoadDir(dir)
+	if err != nil || n != 2 {
+		t.Fatalf("expected 2 templates loaded, got %d (%v)", n, err)
+	}
+
+	expected := []TemplateInfo{
+		{Schema: "metax-att", DataCatalog: "urn:nbn:fi:att:data-catalog-att"},
+		{Schema: "metax-ida", DataCatalog: "urn:nbn:fi:att:data-catalog-ida"},
+		{Schema: "metax-ida", Org: "csc.fi", DataCatalog: "urn:nbn:fi:att:data-catalog-ida"},
+		{Schema: "metax-pas", DataCatalog: "urn:nbn:fi:att:data-catalog-pas"},
+	}
+	list := templates.List()
+	if len(list) != len(expected) {
+		t.Fatalf("expected %d templates, got %+v", len(expected), list)
+	}
+	for i := range expected {
+		if list[i] != expected[i] {
+			t.Errorf("template %d: expected %+v, got %+v", i, expected[i], list[i])
+		}
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "broken.json"), []byte(`{`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := templates.LoadDir(dir); err == nil {
+		t.Error("expected error for broken template")
+	}
+}
+
+func TestCreateDataDefaults(t *testing.T) {
+	templates := BuiltinTemplates()
+	templates.Add(SchemaIda, "csc.fi", []byte(`{
+		"data_catalog": "urn:nbn:fi:att:data-catalog-ida",
+		"research_dataset": {"access_rights": {"license": [{"identifier": "CC-BY-4.0"}]}, "title": {"en": "default"}}
+	}`))
+
+	saved := CurrentTemplates()
+	SetTemplates(templates)
+	defer SetTemplates(saved)
+
+	id, err := uuid.NewUUID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	typed, err := NewMetaxDataset(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dataset := typed.(*MetaxDataset)
+
+	err = dataset.CreateData(MetaxDatasetFamily, SchemaIda, []byte(`{"title": {"en": "mine"}}`), map[string]string{"org": "csc.fi", "identity": "someone"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blob := dataset.Blob()
+	if title := gjson.GetBytes(blob, "research_dataset.title.en").String(); title != "mine" {
+		t.Errorf("expected user title to override default, got %q", title)
+	}
+	if license := gjson.GetBytes(blob, "research_dataset.access_rights.license.0.identifier").String(); license != "CC-BY-4.0" {
+		t.Errorf("expected default license, got %q", license)
+	}
+	if org := gjson.GetBytes(blob, "metadata_provider_org").String(); org != "csc.fi" {
+		t.Errorf("expected metadata_provider_org to be set, got %q", org)
+	}
+
+	if err := dataset.CreateData(MetaxDatasetFamily, "metax-nope", []byte(`{}`), nil); err != ErrUnknownSchema {
+		t.Errorf("expected ErrUnknownSchema, got %v", err)
+	}
+}
@@ -1,7 +1,10 @@
 package metax
 
 import (
+	"time"
+
 	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
 )
 
 var (
@@ -22,6 +25,15 @@ var (
 
 	// QvainId is the key with the identifier "qvain".
 	QvainIdentifierKey = "identifier"
+
+	// MetadataProviderUserKey is the key with the external identity of the user responsible for the dataset.
+	MetadataProviderUserKey = "metadata_provider_user"
+
+	// DateModifiedKey is the key with the time the record was last modified in Metax.
+	DateModifiedKey = "date_modified"
+
+	// DeprecatedKey is the key with the flag Metax sets when files of a published dataset have been removed.
+	DeprecatedKey = "deprecated"
 )
 
 func GetIdentifier(blob []byte) string {
@@ -32,6 +44,28 @@ func GetIdentifier(blob []byte) string {
 	return gjson.GetBytes(blob, IdentifierKey).String()
 }
 
+// GetDateModified returns the time the record was last modified in Metax, or the zero time if it is missing or invalid.
+func GetDateModified(blob []byte) time.Time {
+	if len(blob) < 1 {
+		return time.Time{}
+	}
+
+	t, err := time.Parse(time.RFC3339, gjson.GetBytes(blob, DateModifiedKey).String())
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// IsDeprecated returns a boolean indicating whether Metax has marked the record as deprecated.
+func IsDeprecated(blob []byte) bool {
+	if len(blob) < 1 {
+		return false
+	}
+
+	return gjson.GetBytes(blob, DeprecatedKey).Bool()
+}
+
 func IsPublished(blob []byte) bool {
 	if len(blob) < 1 {
 		return false
@@ -83,3 +117,11 @@ func GetIdentifiers(blob []byte) (string, string, string) {
 	}
 	return results[0].String(), results[1].String(), ""
 }
+
+// SetMetadataProviderUser sets the user responsible for the dataset in Metax, if it isn't set to the given identity already.
+func SetMetadataProviderUser(blob []byte, extid string) ([]byte, error) {
+	if gjson.GetBytes(blob, MetadataProviderUserKey).String() == extid {
+		return blob, nil
+	}
+	return sjson.SetBytes(blob, MetadataProviderUserKey, extid)
+}
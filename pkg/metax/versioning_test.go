@@ -4,6 +4,8 @@ import (
 	"io/ioutil"
 	"path/filepath"
 	"testing"
+
+	"github.com/tidwall/gjson"
 )
 
 func readTestFile(t *testing.T, fn string) []byte {
@@ -111,6 +113,24 @@ func TestGetIdentifier(t *testing.T) {
 	}
 }
 
+func TestIsDeprecated(t *testing.T) {
+	tests := []struct {
+		blob       string
+		deprecated bool
+	}{
+		{blob: `{"identifier": "urn:x", "deprecated": true}`, deprecated: true},
+		{blob: `{"identifier": "urn:x", "deprecated": false}`, deprecated: false},
+		{blob: `{"identifier": "urn:x"}`, deprecated: false},
+		{blob: ``, deprecated: false},
+	}
+
+	for _, test := range tests {
+		if result := IsDeprecated([]byte(test.blob)); result != test.deprecated {
+			t.Errorf("IsDeprecated(%s): expected %v, got %v", test.blob, test.deprecated, result)
+		}
+	}
+}
+
 func TestEditor(t *testing.T) {
 	tests := []struct {
 		fn            string
@@ -232,3 +252,20 @@ func TestGetIdentifiers(t *testing.T) {
 		})
 	}
 }
+
+func TestSetMetadataProviderUser(t *testing.T) {
+	blob := []byte(`{"metadata_provider_user":"old@fairdataid","research_dataset":{}}`)
+
+	same, err := SetMetadataProviderUser(blob, "old@fairdataid")
+	if err != nil || string(same) != string(blob) {
+		t.Errorf("expected blob to be unchanged, got %s (%v)", same, err)
+	}
+
+	changed, err := SetMetadataProviderUser(blob, "new@fairdataid")
+	if err != nil {
+		t.Fatal("SetMetadataProviderUser():", err)
+	}
+	if user := gjson.GetBytes(changed, MetadataProviderUserKey).String(); user != "new@fairdataid" {
+		t.Errorf("expected new@fairdataid, got %s", user)
+	}
+}
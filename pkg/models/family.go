@@ -36,7 +36,7 @@ func (fam *SchemaFamily) Key() string {
 }
 
 // IsPathPublic returns a boolean indicating if the dataset's subkey can be shown via API.
-// A nil path list means no restrictions.
+// The key can be a slash-separated path such as `research_dataset/creator`. A nil path list means no restrictions.
 func (fam *SchemaFamily) IsPathPublic(p string) bool {
 	if fam.publicPaths == nil {
 		return true
@@ -55,10 +55,10 @@ func contains(a []string, s string) bool {
 	return false
 }
 
-// inPrefixes does a simple linear string prefix search.
+// inPrefixes does a simple linear string prefix search; prefixes only match whole path segments.
 func inPrefixes(prefixes []string, s string) bool {
 	for _, prefix := range prefixes {
-		if strings.HasPrefix(s, prefix) {
+		if strings.HasPrefix(s, prefix) && (len(s) == len(prefix) || s[len(prefix)] == '/') {
 			return true
 		}
 	}
@@ -0,0 +1,33 @@
+package models
+
+import (
+	"testing"
+)
+
+func TestIsPathPublic(t *testing.T) {
+	family := &SchemaFamily{publicPaths: []string{"research_dataset", "contracts"}}
+	open := &SchemaFamily{}
+
+	var tests = []struct {
+		path   string
+		public bool
+	}{
+		{path: "research_dataset", public: true},
+		{path: "research_dataset/creator", public: true},
+		{path: "research_dataset/creator/0/name", public: true},
+		{path: "contracts", public: true},
+		{path: "contracts_x", public: false},
+		{path: "research_datasets/title", public: false},
+		{path: "identifier", public: false},
+		{path: "", public: false},
+	}
+
+	for _, test := range tests {
+		if public := family.IsPathPublic(test.path); public != test.public {
+			t.Errorf("%q: expected %v, got %v", test.path, test.public, public)
+		}
+		if !open.IsPathPublic(test.path) {
+			t.Errorf("%q: expected family without path list to allow all paths", test.path)
+		}
+	}
+}
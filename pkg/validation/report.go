@@ -0,0 +1,120 @@
+package validation
+
+import (
+	"sort"
+
+	"github.com/tidwall/gjson"
+)
+
+// Issue severities.
+const (
+	// SeverityError means the dataset can't be published as it is.
+	SeverityError = "error"
+
+	// SeverityWarning means the dataset can be published, but should probably be fixed.
+	SeverityWarning = "warning"
+)
+
+// Issue is a single problem found in a dataset.
+type Issue struct {
+	// Pointer is the JSON pointer (RFC 6901) to the offending field in the dataset.
+	Pointer string `json:"pointer"`
+
+	// Severity is SeverityError or SeverityWarning.
+	Severity string `json:"severity"`
+
+	// Message is a human-readable description of the problem.
+	Message string `json:"message"`
+
+	// Rule identifies the check that failed, e.g. `schema/required` or `ida/files`.
+	Rule string `json:"rule"`
+}
+
+// Report is the result of all checks on a dataset.
+type Report struct {
+	// Schema is the dataset's schema name.
+	Schema string `json:"schema"`
+
+	// Version is the version of the JSON Schema used, or 0 for the built-in schema.
+	Version int `json:"version"`
+
+	// Ready is true if there are no errors, i.e. the dataset should pass Metax validation on publish.
+	Ready bool `json:"ready"`
+
+	// Errors and Warnings count the issues by severity.
+	Errors   int `json:"errors"`
+	Warnings int `json:"warnings"`
+
+	// Issues lists the problems, sorted by pointer.
+	Issues []Issue `json:"issues"`
+}
+
+// Rule is a check on a dataset beyond its JSON Schema, such as a Metax catalog business rule.
+type Rule struct {
+	// Id identifies the rule in reports.
+	Id string
+
+	// Schemas are the schema names the rule applies to; an empty list means all schemas.
+	Schemas []string
+
+	// Check returns the issues found in the dataset; the rule id is filled in automatically.
+	Check func(schema string, dataset gjson.Result) []Issue
+}
+
+// appliesTo returns true if the rule should run for the given schema.
+func (rule *Rule) appliesTo(schema string) bool {
+	if len(rule.Schemas) == 0 {
+		return true
+	}
+	for _, name := range rule.Schemas {
+		if name == schema {
+			return true
+		}
+	}
+	return false
+}
+
+// Report runs the JSON Schema and all rules for the dataset's schema on a dataset blob.
+// It returns ErrUnknownSchema if there is no JSON Schema for the schema name.
+func (registry *Registry) Report(schema string, blob []byte) (*Report, error) {
+	errs, version, err := registry.errors(schema, blob)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &Report{Schema: schema, Version: version, Issues: []Issue{}}
+	for _, e := range errs {
+		report.Issues = append(report.Issues, Issue{
+			Pointer:  e.Pointer,
+			Severity: SeverityError,
+			Message:  e.Message,
+			Rule:     "schema/" + e.Keyword,
+		})
+	}
+
+	dataset := gjson.ParseBytes(blob)
+	for i := range Rules {
+		if !Rules[i].appliesTo(schema) {
+			continue
+		}
+		for _, issue := range Rules[i].Check(schema, dataset) {
+			issue.Rule = Rules[i].Id
+			report.Issues = append(report.Issues, issue)
+		}
+	}
+
+	sort.SliceStable(report.Issues, func(i, j int) bool {
+		return report.Issues[i].Pointer < report.Issues[j].Pointer
+	})
+
+	for _, issue := range report.Issues {
+		if issue.Severity == SeverityError {
+			report.Errors++
+		} else {
+			report.Warnings++
+		}
+	}
+	report.Ready = report.Errors == 0
+
+	return report, nil
+}
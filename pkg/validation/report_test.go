@@ -0,0 +1,103 @@
+package validation
+
+import (
+	"testing"
+
+	"github.com/tidwall/sjson"
+)
+
+func findIssue(report *Report, rule string) *Issue {
+	for i := range report.Issues {
+		if report.Issues[i].Rule == rule {
+			return &report.Issues[i]
+		}
+	}
+	return nil
+}
+
+func TestReport(t *testing.T) {
+	registry := Builtin()
+	blob := readTestData(t, "published.json")
+
+	report, err := registry.Report("metax-ida", blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Ready || report.Errors != 0 {
+		t.Errorf("expected published dataset to be ready, got: %+v", report.Issues)
+	}
+	if report.Warnings == 0 || findIssue(report, "qvain/publisher") == nil {
+		t.Errorf("expected publisher warning, got: %+v", report.Issues)
+	}
+
+	broken, _ := sjson.DeleteBytes(blob, "research_dataset.files")
+	broken, _ = sjson.DeleteBytes(broken, "research_dataset.title")
+	broken, _ = sjson.DeleteBytes(broken, "research_dataset.access_rights.restriction_grounds")
+	broken, _ = sjson.SetBytes(broken, "research_dataset.access_rights.access_type.identifier", "http://uri.suomi.fi/codelist/fairdata/access_type/code/embargo")
+
+	report, err = registry.Report("metax-ida", broken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Ready {
+		t.Error("expected broken dataset not to be ready")
+	}
+
+	expected := map[string]string{
+		"schema/required":            "/research_dataset/title",
+		"ida/files":                  "/research_dataset/files",
+		"access/restriction-grounds": "/research_dataset/access_rights/restriction_grounds",
+	}
+	for rule, pointer := range expected {
+		issue := findIssue(report, rule)
+		if issue == nil {
+			t.Errorf("missing issue for rule %s", rule)
+			continue
+		}
+		if issue.Pointer != pointer || issue.Severity != SeverityError || issue.Message == "" {
+			t.Errorf("rule %s: unexpected issue %+v", rule, issue)
+		}
+	}
+	if report.Errors != len(report.Issues)-report.Warnings {
+		t.Errorf("counts don't add up: %d errors, %d warnings, %d issues", report.Errors, report.Warnings, len(report.Issues))
+	}
+	for i := 1; i < len(report.Issues); i++ {
+		if report.Issues[i-1].Pointer > report.Issues[i].Pointer {
+			t.Errorf("issues not sorted by pointer: %s > %s", report.Issues[i-1].Pointer, report.Issues[i].Pointer)
+		}
+	}
+}
+
+func TestReportCatalog(t *testing.T) {
+	blob := []byte(`{"data_catalog": "urn:nbn:fi:att:data-catalog-ida", "research_dataset": {
+		"title": {"en": "Remote"},
+		"description": {"fi": "Etäresurssit"},
+		"creator": [{"@type": "Organization", "name": {"en": "Org"}}],
+		"access_rights": {"access_type": {"identifier": "http://uri.suomi.fi/codelist/fairdata/access_type/code/open"}}
+	}}`)
+
+	report, err := Builtin().Report("metax-att", blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, rule := range []string{"att/remote-resources", "catalog/mismatch"} {
+		if issue := findIssue(report, rule); issue == nil || issue.Severity != SeverityError {
+			t.Errorf("expected error for rule %s, got %+v", rule, issue)
+		}
+	}
+	for _, rule := range []string{"qvain/license", "qvain/description-language", "qvain/editor"} {
+		if issue := findIssue(report, rule); issue == nil || issue.Severity != SeverityWarning {
+			t.Errorf("expected warning for rule %s, got %+v", rule, issue)
+		}
+	}
+	if findIssue(report, "ida/files") != nil {
+		t.Error("IDA rule applied to ATT dataset")
+	}
+}
+
+func TestReportUnknownSchema(t *testing.T) {
+	if _, err := Builtin().Report("no-such-schema", []byte(`{}`)); err != ErrUnknownSchema {
+		t.Errorf("expected ErrUnknownSchema, got %v", err)
+	}
+}
@@ -0,0 +1,133 @@
+package validation
+
+import (
+	"strings"
+
+	"github.com/NatLibFi/qvain-api/pkg/metax"
+	"github.com/tidwall/gjson"
+)
+
+// Rules are the checks run in addition to the JSON Schema when creating a validation report.
+var Rules = []Rule{
+	// Metax catalog business rules; Metax refuses to publish datasets breaking these.
+	{
+		Id:      "ida/files",
+		Schemas: []string{metax.SchemaIda},
+		Check: func(_ string, dataset gjson.Result) []Issue {
+			if hasItems(dataset, "research_dataset.files") || hasItems(dataset, "research_dataset.directories") {
+				return nil
+			}
+			return []Issue{{Pointer: "/research_dataset/files", Severity: SeverityError, Message: "IDA datasets must have at least one file or directory"}}
+		},
+	},
+	{
+		Id:      "att/remote-resources",
+		Schemas: []string{metax.SchemaAtt},
+		Check: func(_ string, dataset gjson.Result) []Issue {
+			if hasItems(dataset, "research_dataset.remote_resources") {
+				return nil
+			}
+			return []Issue{{Pointer: "/research_dataset/remote_resources", Severity: SeverityError, Message: "ATT datasets must have at least one remote resource"}}
+		},
+	},
+	{
+		Id: "catalog/mismatch",
+		Check: func(schema string, dataset gjson.Result) []Issue {
+			catalog := dataset.Get("data_catalog")
+			if catalog.IsObject() {
+				catalog = catalog.Get("identifier")
+			}
+			expected := metax.SchemaForCatalog(catalog.String())
+			if expected == "" || expected == schema {
+				return nil
+			}
+			return []Issue{{Pointer: "/data_catalog", Severity: SeverityError, Message: "data catalog belongs to schema " + expected}}
+		},
+	},
+	{
+		Id: "access/restriction-grounds",
+		Check: func(_ string, dataset gjson.Result) []Issue {
+			access := dataset.Get("research_dataset.access_rights")
+			if !access.Exists() || isOpenAccess(access) || hasItems(access, "restriction_grounds") {
+				return nil
+			}
+			return []Issue{{Pointer: "/research_dataset/access_rights/restriction_grounds", Severity: SeverityError, Message: "restricted access requires restriction grounds"}}
+		},
+	},
+
+	// Qvain checks; these don't block publishing, but make for better metadata.
+	{
+		Id: "qvain/license",
+		Check: func(_ string, dataset gjson.Result) []Issue {
+			access := dataset.Get("research_dataset.access_rights")
+			if !isOpenAccess(access) || hasItems(access, "license") {
+				return nil
+			}
+			return []Issue{{Pointer: "/research_dataset/access_rights/license", Severity: SeverityWarning, Message: "open access datasets should have a license"}}
+		},
+	},
+	{
+		Id: "qvain/publisher",
+		Check: func(_ string, dataset gjson.Result) []Issue {
+			if dataset.Get("research_dataset.publisher").Exists() {
+				return nil
+			}
+			return []Issue{{Pointer: "/research_dataset/publisher", Severity: SeverityWarning, Message: "publisher is missing"}}
+		},
+	},
+	{
+		Id: "qvain/issued",
+		Check: func(_ string, dataset gjson.Result) []Issue {
+			if dataset.Get("research_dataset.issued").Exists() {
+				return nil
+			}
+			return []Issue{{Pointer: "/research_dataset/issued", Severity: SeverityWarning, Message: "issue date is missing"}}
+		},
+	},
+	{
+		Id: "qvain/description-language",
+		Check: func(_ string, dataset gjson.Result) []Issue {
+			description := dataset.Get("research_dataset.description")
+			if !description.IsObject() {
+				return nil
+			}
+			described := make(map[string]bool)
+			description.ForEach(func(lang, _ gjson.Result) bool {
+				described[lang.String()] = true
+				return true
+			})
+			var issues []Issue
+			dataset.Get("research_dataset.title").ForEach(func(lang, _ gjson.Result) bool {
+				if !described[lang.String()] {
+					issues = append(issues, Issue{Pointer: "/research_dataset/description", Severity: SeverityWarning, Message: "description is missing language " + lang.String() + " used in title"})
+				}
+				return true
+			})
+			return issues
+		},
+	},
+	{
+		Id: "qvain/editor",
+		Check: func(_ string, dataset gjson.Result) []Issue {
+			if dataset.Get(metax.EditorKey+"."+metax.QvainIdentifierKey).String() == "qvain" {
+				return nil
+			}
+			return []Issue{{Pointer: "/" + metax.EditorKey, Severity: SeverityWarning, Message: "dataset is not marked as edited in Qvain"}}
+		},
+	},
+}
+
+// hasItems returns true if the value at path is a non-empty array or object.
+func hasItems(dataset gjson.Result, path string) bool {
+	value := dataset.Get(path)
+	if value.IsArray() {
+		return len(value.Array()) > 0
+	}
+	return value.IsObject() && len(value.Map()) > 0
+}
+
+// isOpenAccess returns true if the access rights have an open access type.
+func isOpenAccess(access gjson.Result) bool {
+	identifier := access.Get("access_type.identifier").String()
+	return strings.HasSuffix(identifier, "/open") || strings.HasSuffix(identifier, "access_type_open_access")
+}
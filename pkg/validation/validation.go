@@ -0,0 +1,209 @@
+// Package validation checks dataset blobs against the JSON Schema registered for the dataset's schema name.
+package validation
+
+import (
+	"embed"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/NatLibFi/qvain-api/pkg/jsonschema"
+)
+
+// ErrUnknownSchema is returned when there is no JSON Schema for a dataset's schema name.
+var ErrUnknownSchema = errors.New("unknown schema")
+
+// builtin holds the default schemas, named after the dataset schema they apply to.
+//
+//go:embed schemas/*.json
+var builtin embed.FS
+
+// Registry maps dataset schema names to JSON Schemas. It is safe for concurrent use.
+type Registry struct {
+	mu      sync.RWMutex
+	schemas map[string]*entry
+}
+
+// entry is a compiled JSON Schema along with its source and UI hints.
+type entry struct {
+	schema *jsonschema.Schema
+	info   Info
+}
+
+// Info describes a registered JSON Schema.
+type Info struct {
+	// Name is the dataset schema name the JSON Schema applies to.
+	Name string `json:"name"`
+
+	// Version is the version of the schema in the database, or 0 for the built-in schema.
+	Version int `json:"version"`
+
+	// Schema is the JSON Schema source.
+	Schema json.RawMessage `json:"schema,omitempty"`
+
+	// Ui holds optional hints for rendering the schema in the frontend.
+	Ui json.RawMessage `json:"ui,omitempty"`
+}
+
+// NewRegistry returns an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{schemas: make(map[string]*entry)}
+}
+
+// Builtin returns a registry with the schemas compiled into the application.
+func Builtin() *Registry {
+	registry := NewRegistry()
+
+	files, err := builtin.ReadDir("schemas")
+	if err != nil {
+		panic(err)
+	}
+	for _, file := range files {
+		data, err := builtin.ReadFile("schemas/" + file.Name())
+		if err != nil {
+			panic(err)
+		}
+		if err := registry.Add(schemaName(file.Name()), data); err != nil {
+			panic(file.Name() + ": " + err.Error())
+		}
+	}
+
+	return registry
+}
+
+// schemaName returns the dataset schema name for a schema file.
+func schemaName(filename string) string {
+	return strings.TrimSuffix(filepath.Base(filename), ".json")
+}
+
+// Add compiles a JSON Schema and registers it under the given name as version 0, replacing any existing schema.
+func (registry *Registry) Add(name string, data []byte) error {
+	return registry.AddVersion(name, 0, data, nil)
+}
+
+// AddVersion compiles a versioned JSON Schema with optional UI hints and registers it under the given name, replacing any existing schema.
+func (registry *Registry) AddVersion(name string, version int, data []byte, ui []byte) error {
+	schema, err := jsonschema.Compile(data)
+	if err != nil {
+		return err
+	}
+
+	e := &entry{
+		schema: schema,
+		info:   Info{Name: name, Version: version, Schema: json.RawMessage(data)},
+	}
+	if len(ui) > 0 {
+		e.info.Ui = json.RawMessage(ui)
+	}
+
+	registry.mu.Lock()
+	registry.schemas[name] = e
+	registry.mu.Unlock()
+	return nil
+}
+
+// LoadDir adds all `.json` files in a directory, using the file name without extension as schema name.
+// It returns the number of schemas loaded.
+func (registry *Registry) LoadDir(dir string) (int, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return 0, err
+	}
+
+	for i, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return i, err
+		}
+		if err := registry.Add(schemaName(file), data); err != nil {
+			return i, errors.New(file + ": " + err.Error())
+		}
+	}
+
+	return len(files), nil
+}
+
+// lookup returns the registry entry for a dataset schema name, or nil.
+func (registry *Registry) lookup(name string) *entry {
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+	return registry.schemas[name]
+}
+
+// Lookup returns the JSON Schema for a dataset schema name, or nil.
+func (registry *Registry) Lookup(name string) *jsonschema.Schema {
+	if e := registry.lookup(name); e != nil {
+		return e.schema
+	}
+	return nil
+}
+
+// Get returns the source, version and UI hints of the JSON Schema for a dataset schema name.
+func (registry *Registry) Get(name string) (Info, bool) {
+	if e := registry.lookup(name); e != nil {
+		return e.info, true
+	}
+	return Info{}, false
+}
+
+// List returns the names and versions of the registered schemas, sorted by name, without their source.
+func (registry *Registry) List() []Info {
+	registry.mu.RLock()
+	list := make([]Info, 0, len(registry.schemas))
+	for _, e := range registry.schemas {
+		list = append(list, Info{Name: e.info.Name, Version: e.info.Version})
+	}
+	registry.mu.RUnlock()
+
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// Names returns the sorted names of the registered schemas.
+func (registry *Registry) Names() []string {
+	list := registry.List()
+	names := make([]string, len(list))
+	for i := range list {
+		names[i] = list[i].Name
+	}
+	return names
+}
+
+// Errors validates a dataset blob and returns the schema errors.
+func (registry *Registry) Errors(schema string, blob []byte) ([]jsonschema.Error, error) {
+	errs, _, err := registry.errors(schema, blob)
+	return errs, err
+}
+
+// errors validates a dataset blob and returns the schema errors along with the version of the schema used.
+func (registry *Registry) errors(schema string, blob []byte) ([]jsonschema.Error, int, error) {
+	e := registry.lookup(schema)
+	if e == nil {
+		return nil, 0, ErrUnknownSchema
+	}
+	errs, err := e.schema.Validate(blob)
+	return errs, e.info.Version, err
+}
+
+// Validate validates a dataset blob and returns whether it is valid along with the errors as JSON array and the schema version used.
+// It satisfies the psql.Validator interface.
+func (registry *Registry) Validate(schema string, blob []byte) (bool, json.RawMessage, int, error) {
+	errs, version, err := registry.errors(schema, blob)
+	if err != nil {
+		return false, nil, 0, err
+	}
+
+	if errs == nil {
+		errs = []jsonschema.Error{}
+	}
+	report, err := json.Marshal(errs)
+	if err != nil {
+		return false, nil, 0, err
+	}
+
+	return len(errs) == 0, report, version, nil
+}
@@ -0,0 +1,164 @@
+package validation
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/tidwall/sjson"
+)
+
+func readTestData(t *testing.T, name string) []byte {
+	data, err := ioutil.ReadFile(filepath.Join("..", "metax", "testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestBuiltin(t *testing.T) {
+	registry := Builtin()
+
+	names := registry.Names()
+	if len(names) != 2 || names[0] != "metax-att" || names[1] != "metax-ida" {
+		t.Fatalf("unexpected builtin schemas: %v", names)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	registry := Builtin()
+	blob := readTestData(t, "published.json")
+
+	valid, report, version, err := registry.Validate("metax-ida", blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !valid || string(report) != "[]" {
+		t.Errorf("expected published dataset to be valid, got: %s", report)
+	}
+	if version != 0 {
+		t.Errorf("expected built-in schema version 0, got %d", version)
+	}
+
+	broken, _ := sjson.DeleteBytes(blob, "research_dataset.title")
+	broken, _ = sjson.SetBytes(broken, "research_dataset.creator.0.name", "")
+	broken, _ = sjson.SetBytes(broken, "research_dataset.issued", "yesterday")
+
+	valid, report, _, err = registry.Validate("metax-ida", broken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if valid {
+		t.Fatal("expected broken dataset to be invalid")
+	}
+
+	var errs []struct {
+		Pointer string `json:"pointer"`
+		Keyword string `json:"keyword"`
+	}
+	if err := json.Unmarshal(report, &errs); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{
+		"/research_dataset/title":          "required",
+		"/research_dataset/creator/0/name": "minLength",
+		"/research_dataset/issued":         "format",
+	}
+	for _, e := range errs {
+		if expected[e.Pointer] == e.Keyword {
+			delete(expected, e.Pointer)
+		}
+	}
+	if len(expected) > 0 {
+		t.Errorf("missing expected errors %v in report: %s", expected, report)
+	}
+}
+
+func TestValidateAtt(t *testing.T) {
+	registry := Builtin()
+	blob := []byte(`{"research_dataset": {
+		"title": {"en": "Remote"},
+		"description": {"en": "Remote resources"},
+		"creator": [{"@type": "Organization", "name": {"en": "Org"}}],
+		"access_rights": {"access_type": {"identifier": "http://uri.suomi.fi/codelist/fairdata/access_type/code/open"}},
+		"remote_resources": [{"title": "", "access_url": {"identifier": "not a url"}}]
+	}}`)
+
+	_, report, _, err := registry.Validate("metax-att", blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `[{"pointer":"/research_dataset/remote_resources/0/access_url/identifier","keyword":"format","message":"not a valid uri"},{"pointer":"/research_dataset/remote_resources/0/title","keyword":"minLength","message":"must not be empty"}]`
+	if string(report) != expected {
+		t.Errorf("unexpected report: %s", report)
+	}
+}
+
+func TestUnknownSchema(t *testing.T) {
+	if _, _, _, err := Builtin().Validate("no-such-schema", []byte(`{}`)); err != ErrUnknownSchema {
+		t.Errorf("expected ErrUnknownSchema, got %v", err)
+	}
+}
+
+func TestLoadDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "qvain-schemas")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "metax-ida.json"), []byte(`{"required": ["research_dataset", "extra"]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	registry := Builtin()
+	n, err := registry.LoadDir(dir)
+	if err != nil || n != 1 {
+		t.Fatalf("expected 1 schema loaded, got %d (%v)", n, err)
+	}
+
+	if valid, _, _, _ := registry.Validate("metax-ida", []byte(`{"research_dataset": {}}`)); valid {
+		t.Error("expected overridden schema to be used")
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "broken.json"), []byte(`{"$ref": "#/nowhere"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := registry.LoadDir(dir); err == nil {
+		t.Error("expected error for broken schema")
+	}
+}
+
+func TestAddVersion(t *testing.T) {
+	registry := Builtin()
+
+	if err := registry.AddVersion("metax-ida", 3, []byte(`{"required": ["research_dataset"]}`), []byte(`{"order": ["title"]}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := registry.AddVersion("metax-ida", 4, []byte(`{"pattern": "("}`), nil); err == nil {
+		t.Error("expected error for invalid schema")
+	}
+
+	info, ok := registry.Get("metax-ida")
+	if !ok || info.Version != 3 || string(info.Ui) != `{"order": ["title"]}` {
+		t.Errorf("unexpected schema info: %+v", info)
+	}
+
+	_, _, version, err := registry.Validate("metax-ida", []byte(`{"research_dataset": {}}`))
+	if err != nil || version != 3 {
+		t.Errorf("expected validation against version 3, got %d (%v)", version, err)
+	}
+
+	list := registry.List()
+	if len(list) != 2 || list[1].Name != "metax-ida" || list[1].Version != 3 || list[1].Schema != nil {
+		t.Errorf("unexpected schema list: %+v", list)
+	}
+
+	if _, ok := registry.Get("no-such-schema"); ok {
+		t.Error("expected unknown schema not to be found")
+	}
+}
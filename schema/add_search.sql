@@ -0,0 +1,41 @@
+-- Upgrade step for databases created before full-text search over datasets
+--
+-- Adds the generated `search` column to table `datasets` and its index; see schema.sql.
+-- Generated columns need PostgreSQL 12 or newer. Adding the column computes it for every dataset,
+-- which rewrites the table and locks it while it runs.
+--
+-- Run it once, as the application role, before deploying a version with dataset search:
+--
+--   psql -v ON_ERROR_STOP=1 -1 -f add_search.sql
+--
+
+SET ROLE qvain;
+
+-- Function `search_config` maps a language code used in multilingual dataset fields to a text search configuration.
+CREATE OR REPLACE FUNCTION search_config(lang text) RETURNS regconfig AS $$
+	SELECT CASE lang
+		WHEN 'fi' THEN 'finnish'
+		WHEN 'sv' THEN 'swedish'
+		WHEN 'en' THEN 'english'
+		ELSE 'simple'
+	END::regconfig;
+$$ LANGUAGE SQL IMMUTABLE;
+
+-- Function `dataset_search_vector` builds the full-text search document for a dataset.
+--
+-- Titles rank above descriptions, which rank above keywords. Keywords aren't language-tagged,
+-- so they are indexed without stemming.
+CREATE OR REPLACE FUNCTION dataset_search_vector(blob jsonb) RETURNS tsvector AS $$
+	SELECT
+		setweight(to_tsvector('finnish', coalesce(blob#>>'{research_dataset,title,fi}', '')), 'A') ||
+		setweight(to_tsvector('swedish', coalesce(blob#>>'{research_dataset,title,sv}', '')), 'A') ||
+		setweight(to_tsvector('english', coalesce(blob#>>'{research_dataset,title,en}', '')), 'A') ||
+		setweight(to_tsvector('finnish', coalesce(blob#>>'{research_dataset,description,fi}', '')), 'B') ||
+		setweight(to_tsvector('swedish', coalesce(blob#>>'{research_dataset,description,sv}', '')), 'B') ||
+		setweight(to_tsvector('english', coalesce(blob#>>'{research_dataset,description,en}', '')), 'B') ||
+		setweight(jsonb_to_tsvector('simple', coalesce(blob#>'{research_dataset,keyword}', '[]'), '["string"]'), 'C');
+$$ LANGUAGE SQL IMMUTABLE;
+
+ALTER TABLE datasets ADD COLUMN IF NOT EXISTS search tsvector GENERATED ALWAYS AS (dataset_search_vector(blob)) STORED;
+
+CREATE INDEX IF NOT EXISTS idx_datasets_search ON datasets USING GIN (search);
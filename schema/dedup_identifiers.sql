@@ -0,0 +1,29 @@
+-- Upgrade step for databases created before index `idx_datasets_identifier`
+--
+-- Earlier syncs could store a Metax dataset more than once, which makes creating the unique index fail.
+-- This script keeps one dataset per Metax identifier and creates the index:
+-- the kept copy is the one not in the trash bin, else the most recently modified one.
+-- The other copies lose their `identifier` and are moved to the trash bin, where they are purged as usual.
+--
+-- Run it once, as the application role, before deploying a version that expects the index:
+--
+--   psql -v ON_ERROR_STOP=1 -1 -f dedup_identifiers.sql
+--
+
+SET ROLE qvain;
+
+WITH ranked AS (
+	SELECT id, row_number() OVER (
+		PARTITION BY blob->>'identifier'
+		ORDER BY deleted IS NULL DESC, modified DESC, created DESC
+	) AS n
+	FROM datasets
+	WHERE blob->>'identifier' IS NOT NULL
+)
+UPDATE datasets SET
+	blob = datasets.blob - 'identifier',
+	deleted = coalesce(datasets.deleted, now())
+FROM ranked
+WHERE datasets.id = ranked.id AND ranked.n > 1;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_datasets_identifier ON datasets ((blob->>'identifier')) WHERE blob->>'identifier' IS NOT NULL;
@@ -1,5 +1,8 @@
 -- SQL schema for Qvain
 --
+-- Requires PostgreSQL 12 or newer (generated columns for dataset search).
+-- Databases created with an older version of this schema are brought up to date with the upgrade scripts in this directory.
+--
 -- Make sure the tables are owned by the user the application connects as,
 -- which should not be a role with admin privileges.
 --
@@ -40,26 +43,204 @@ $$ LANGUAGE PLPGSQL;
 
 CREATE SEQUENCE object_id_seq;
 
+-- Function `search_config` maps a language code used in multilingual dataset fields to a text search configuration.
+CREATE OR REPLACE FUNCTION search_config(lang text) RETURNS regconfig AS $$
+	SELECT CASE lang
+		WHEN 'fi' THEN 'finnish'
+		WHEN 'sv' THEN 'swedish'
+		WHEN 'en' THEN 'english'
+		ELSE 'simple'
+	END::regconfig;
+$$ LANGUAGE SQL IMMUTABLE;
+
+-- Function `dataset_search_vector` builds the full-text search document for a dataset.
+--
+-- Titles rank above descriptions, which rank above keywords. Keywords aren't language-tagged,
+-- so they are indexed without stemming.
+CREATE OR REPLACE FUNCTION dataset_search_vector(blob jsonb) RETURNS tsvector AS $$
+	SELECT
+		setweight(to_tsvector('finnish', coalesce(blob#>>'{research_dataset,title,fi}', '')), 'A') ||
+		setweight(to_tsvector('swedish', coalesce(blob#>>'{research_dataset,title,sv}', '')), 'A') ||
+		setweight(to_tsvector('english', coalesce(blob#>>'{research_dataset,title,en}', '')), 'A') ||
+		setweight(to_tsvector('finnish', coalesce(blob#>>'{research_dataset,description,fi}', '')), 'B') ||
+		setweight(to_tsvector('swedish', coalesce(blob#>>'{research_dataset,description,sv}', '')), 'B') ||
+		setweight(to_tsvector('english', coalesce(blob#>>'{research_dataset,description,en}', '')), 'B') ||
+		setweight(jsonb_to_tsvector('simple', coalesce(blob#>'{research_dataset,keyword}', '[]'), '["string"]'), 'C');
+$$ LANGUAGE SQL IMMUTABLE;
+
 -- Table `datasets` contains datasets of different types (families).
 --
 -- The `blob` field has the actual dataset as it is known to external services;
 -- the other fields are internal metadata.
+--
+-- `valid` tells if the blob passed validation against the JSON Schema for its `schema` on the last user edit, or if it came from Metax;
+-- `validation` holds the list of validation errors (`pointer`, `keyword`, `message`) and `validated` the time of the check;
+-- `schema_version` is the version of the JSON Schema in table `schemas` used for the check, or 0 for the built-in one.
+-- `removed_upstream` is set when a full sync for the dataset's `metadata_provider_user` no longer finds the published dataset in Metax,
+-- `deprecated` when Metax marks it deprecated because its files were removed; both are cleared if a later sync finds the dataset in order again.
 CREATE TABLE datasets (
 	id          uuid PRIMARY KEY,
 	creator     uuid,
 	owner       uuid,
+	project     text,
 
 	created     timestamp with time zone DEFAULT now(),
 	modified    timestamp with time zone DEFAULT now(),
 	synced      timestamp with time zone,
+	deleted     timestamp with time zone,
 	seq         integer DEFAULT 0,
 
+	removed_upstream timestamp with time zone,
+	deprecated  timestamp with time zone,
+
 	published   boolean DEFAULT false,
 	valid       boolean DEFAULT false,
+	validated   timestamp with time zone,
+	validation  jsonb,
+	schema_version integer,
 
 	family      int,
 	schema      text,
-	blob        jsonb
+	blob        jsonb,
+
+	search      tsvector GENERATED ALWAYS AS (dataset_search_vector(blob)) STORED
+);
+
+-- Index `idx_datasets_search` is used for full-text search over a dataset's titles, descriptions and keywords.
+CREATE INDEX idx_datasets_search ON datasets USING GIN (search);
+
+-- Index `idx_datasets_project` speeds up listing the datasets owned by an IDA project.
+CREATE INDEX idx_datasets_project ON datasets (project) WHERE project IS NOT NULL;
+
+-- Index `idx_datasets_deleted` speeds up purging the trash bin; datasets are in the trash if `deleted` is set.
+CREATE INDEX idx_datasets_deleted ON datasets (deleted) WHERE deleted IS NOT NULL;
+
+-- Index `idx_datasets_identifier` makes sure a Metax dataset is stored only once, and is used to find datasets by Metax identifier during sync.
+-- Existing databases may hold duplicates from earlier syncs; run `dedup_identifiers.sql` there instead of creating the index by hand.
+CREATE UNIQUE INDEX idx_datasets_identifier ON datasets ((blob->>'identifier')) WHERE blob->>'identifier' IS NOT NULL;
+
+-- Indexes `idx_datasets_owner_*` support keyset pagination of a user's datasets sorted by time.
+CREATE INDEX idx_datasets_owner_modified ON datasets (owner, modified, id);
+CREATE INDEX idx_datasets_owner_created ON datasets (owner, created, id);
+
+-- Table `dataset_revisions` keeps a copy of every state a dataset's `blob` has been in.
+--
+-- A row is written in the same transaction as each change to `datasets.blob`, with the dataset's new `seq`.
+-- `uid` is the user who made or triggered the change, if known.
+-- `source` is one of `user` (edit through the API), `sync` (update from Metax) or `publish` (response from Metax on publish).
+CREATE TABLE dataset_revisions (
+	id       uuid REFERENCES datasets(id) ON DELETE CASCADE,
+	seq      integer,
+	created  timestamp with time zone DEFAULT now(),
+	uid      uuid,
+	source   text,
+	blob     jsonb,
+	PRIMARY KEY (id, seq)
+);
+
+-- Table `dataset_transfers` holds requests to hand over the ownership of a dataset to another user.
+--
+-- A transfer is `pending` until the recipient accepts or declines it, the sender cancels it, or it expires.
+-- Expired transfers are only marked `expired` when a new transfer is created for the same dataset;
+-- queries should treat pending transfers past their expiry time as expired.
+CREATE TABLE dataset_transfers (
+	id         bigserial PRIMARY KEY,
+	dataset    uuid NOT NULL REFERENCES datasets(id) ON DELETE CASCADE,
+	sender     uuid NOT NULL,
+	recipient  uuid NOT NULL,
+	created    timestamp with time zone NOT NULL DEFAULT now(),
+	expires    timestamp with time zone NOT NULL,
+	decided    timestamp with time zone,
+	status     text NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'accepted', 'declined', 'cancelled', 'expired'))
+);
+
+-- Index `idx_dataset_transfers_pending` allows only one pending transfer per dataset.
+CREATE UNIQUE INDEX idx_dataset_transfers_pending ON dataset_transfers (dataset) WHERE status = 'pending';
+
+-- Index `idx_dataset_transfers_recipient` speeds up listing incoming transfers.
+CREATE INDEX idx_dataset_transfers_recipient ON dataset_transfers (recipient) WHERE status = 'pending';
+
+-- Table `sync_conflicts` holds records from Metax that were not applied because the dataset had been edited in Qvain as well.
+--
+-- A conflict is detected during sync if the dataset was modified locally since it was last synced (`modified > synced`)
+-- and Metax has a newer version (`date_modified > synced`); the local copy is kept and the incoming record stored here.
+-- `blob` is the incoming record; `remote_modified` its Metax modification time and `local_seq` the dataset's sequence number at detection.
+-- `resolution` is one of `mine` (keep local copy), `theirs` (take incoming record) or `merge` (user-merged `research_dataset` on top of the incoming record).
+CREATE TABLE sync_conflicts (
+	id              bigserial PRIMARY KEY,
+	dataset         uuid NOT NULL REFERENCES datasets(id) ON DELETE CASCADE,
+	blob            jsonb NOT NULL,
+	remote_modified timestamp with time zone,
+	local_seq       integer,
+	detected        timestamp with time zone NOT NULL DEFAULT now(),
+	resolved        timestamp with time zone,
+	resolution      text CHECK (resolution IN ('mine', 'theirs', 'merge')),
+	resolved_by     uuid
+);
+
+-- Index `idx_sync_conflicts_open` allows only one open conflict per dataset; a newer incoming record replaces the stored one.
+CREATE UNIQUE INDEX idx_sync_conflicts_open ON sync_conflicts (dataset) WHERE resolved IS NULL;
+
+-- Table `dataset_acl` gives users other than the owner access to a dataset.
+--
+-- `role` is one of `viewer` (read), `editor` (read and write) or `owner` (also publish, delete and manage the ACL).
+-- The user in the dataset's `owner` column is always an owner and is not listed here.
+-- `added_by` is the user who granted the role.
+CREATE TABLE dataset_acl (
+	dataset    uuid NOT NULL REFERENCES datasets(id) ON DELETE CASCADE,
+	uid        uuid NOT NULL,
+	role       text NOT NULL CHECK (role IN ('viewer', 'editor', 'owner')),
+	added      timestamp with time zone NOT NULL DEFAULT now(),
+	added_by   uuid,
+	PRIMARY KEY (dataset, uid)
+);
+
+-- Index `idx_dataset_acl_uid` speeds up listing datasets shared with a user.
+CREATE INDEX idx_dataset_acl_uid ON dataset_acl (uid);
+
+-- Table `project_members` mirrors the IDA project memberships from the users' login tokens.
+--
+-- The memberships of a user are replaced on each login. Members of the project in a dataset's `project` column can view and edit it.
+CREATE TABLE project_members (
+	uid        uuid NOT NULL,
+	project    text NOT NULL,
+	updated    timestamp with time zone NOT NULL DEFAULT now(),
+	PRIMARY KEY (uid, project)
+);
+
+-- Index `idx_project_members_project` speeds up finding the members of a project.
+CREATE INDEX idx_project_members_project ON project_members (project);
+
+-- Table `schemas` holds the uploaded versions of the JSON Schemas used to validate datasets, with optional UI hints for the frontend.
+--
+-- `name` is the dataset schema the JSON Schema applies to (e.g. `metax-ida`); versions are numbered from 1 per name.
+-- At most one version per name is `active`; names without an active version use the schema built into the application.
+CREATE TABLE schemas (
+	name       text NOT NULL,
+	version    integer NOT NULL,
+	schema     jsonb NOT NULL,
+	ui         jsonb,
+	active     boolean NOT NULL DEFAULT false,
+	created    timestamp with time zone NOT NULL DEFAULT now(),
+	creator    uuid,
+	PRIMARY KEY (name, version)
+);
+
+-- Index `idx_schemas_active` allows only one active version per schema name.
+CREATE UNIQUE INDEX idx_schemas_active ON schemas (name) WHERE active;
+
+-- Table `templates` holds the base JSON for new datasets, overriding the templates built into the application.
+--
+-- `schema` is the dataset schema the template is for; new schemas can be added by adding a template (and a JSON Schema).
+-- `org` is the organisation of the users the template applies to, or empty for the schema's default template.
+-- Changes take effect when the templates are reloaded.
+CREATE TABLE templates (
+	schema     text NOT NULL,
+	org        text NOT NULL DEFAULT '',
+	template   jsonb NOT NULL CHECK (jsonb_typeof(template) = 'object'),
+	updated    timestamp with time zone NOT NULL DEFAULT now(),
+	updated_by uuid,
+	PRIMARY KEY (schema, org)
 );
 
 -- Table `identities` lists app users and their external identities.
@@ -93,13 +274,73 @@ CREATE INDEX idx_btree_extid_fairdata ON identities USING BTREE ((extids->>'fair
 CREATE INDEX idx_gin_extid_all ON identities USING GIN (extids jsonb_path_ops);
 
 -- Table `lastsync` stores the time of last synchronisation for a user's records from an external service.
+--
+-- `ts` is the start time of the last successful sync; it is NULL if no sync has succeeded yet.
+-- `success`, `msg` and `duration` describe the outcome of the last attempt, successful or not.
 CREATE TABLE lastsync (
 	uid      uuid PRIMARY KEY REFERENCES identities(uid) ON DELETE CASCADE ON UPDATE CASCADE,
 	ts       timestamp with time zone,
 	success  boolean DEFAULT false,
-	msg      text
+	msg      text,
+	duration interval
+);
+
+-- Table `sync_runs` records each sync of a user's datasets from an external service.
+--
+-- `id` is the sync id that also appears in the logs; `trigger` is one of `login`, `user`, `schedule` or `cli`.
+-- `since` is the time changes were requested from, NULL for a full sync; `finished` is NULL while the sync is running.
+-- `skips` is a JSON array with the reasons (`id`, `identifier`, `reason`) for the first skipped records; `error` is set if the sync failed.
+CREATE TABLE sync_runs (
+	id         text PRIMARY KEY,
+	uid        uuid NOT NULL REFERENCES identities(uid) ON DELETE CASCADE ON UPDATE CASCADE,
+	trigger    text NOT NULL,
+	since      timestamp with time zone,
+	started    timestamp with time zone NOT NULL DEFAULT now(),
+	finished   timestamp with time zone,
+	total      integer NOT NULL DEFAULT 0,
+	read       integer NOT NULL DEFAULT 0,
+	written    integer NOT NULL DEFAULT 0,
+	skipped    integer NOT NULL DEFAULT 0,
+	conflicts  integer NOT NULL DEFAULT 0,
+	deprecated integer NOT NULL DEFAULT 0,
+	removed    integer NOT NULL DEFAULT 0,
+	skips      jsonb NOT NULL DEFAULT '[]',
+	error      text
 );
 
+-- Index `idx_sync_runs_uid` speeds up listing a user's latest sync runs.
+CREATE INDEX idx_sync_runs_uid ON sync_runs (uid, started DESC);
+
+-- Table `jobs` is a queue of background work such as syncs and publishing, run by workers in any backend process.
+--
+-- `kind` selects the handler and `payload` is its input; `key`, if set, allows only one pending or running job at a time, e.g. one sync per user.
+-- `state` goes from `pending` to `running` and then to `done`, or back to `pending` with a later `run_at` if the job failed and can be retried;
+-- jobs that fail `max_attempts` times or can't succeed become `dead`.
+-- `locked_until` is the lease of a running job; jobs still running after it are claimed again.
+-- `result` is the handler's output for done jobs and `error` the last error.
+CREATE TABLE jobs (
+	id           bigserial PRIMARY KEY,
+	kind         text NOT NULL,
+	key          text,
+	uid          uuid,
+	payload      jsonb NOT NULL DEFAULT '{}',
+	state        text NOT NULL DEFAULT 'pending' CHECK (state IN ('pending', 'running', 'done', 'dead')),
+	attempts     integer NOT NULL DEFAULT 0,
+	max_attempts integer NOT NULL DEFAULT 5,
+	run_at       timestamp with time zone NOT NULL DEFAULT now(),
+	locked_until timestamp with time zone,
+	created      timestamp with time zone NOT NULL DEFAULT now(),
+	updated      timestamp with time zone NOT NULL DEFAULT now(),
+	result       jsonb,
+	error        text
+);
+
+-- Index `idx_jobs_due` speeds up claiming the next job.
+CREATE INDEX idx_jobs_due ON jobs (run_at) WHERE state IN ('pending', 'running');
+
+-- Index `idx_jobs_key` allows only one unfinished job per key.
+CREATE UNIQUE INDEX idx_jobs_key ON jobs (key) WHERE state IN ('pending', 'running');
+
 -- Table `objects` stores user saved objects.
 CREATE TABLE objects (
     id       bigint NOT NULL DEFAULT next_object_id(),